@@ -3,6 +3,7 @@ package v1
 import (
 	"github.com/gin-gonic/gin"
 	"kama_chat_server/internal/dto/request"
+	"kama_chat_server/internal/middleware"
 	"kama_chat_server/internal/service/gorm"
 	"kama_chat_server/pkg/constants"
 	"kama_chat_server/pkg/zlog"
@@ -20,6 +21,6 @@ func GetCurContactListInChatRoom(c *gin.Context) {
 		})
 		return
 	}
-	message, rspList, ret := gorm.ChatRoomService.GetCurContactListInChatRoom(req.OwnerId, req.ContactId)
+	message, rspList, ret := gorm.ChatRoomService.GetCurContactListInChatRoom(middleware.GetUuid(c), req.ContactId)
 	JsonBack(c, message, ret, rspList)
 }
@@ -29,5 +29,10 @@ func JsonBack(c *gin.Context, message string, ret int, data interface{}) {
 			"code":    500,
 			"message": message,
 		})
+	} else if ret == -3 {
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    403,
+			"message": message,
+		})
 	}
 }
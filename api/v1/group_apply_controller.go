@@ -0,0 +1,53 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"kama_chat_server/internal/dto/request"
+	"kama_chat_server/internal/dto/respond"
+	"kama_chat_server/internal/middleware"
+	"kama_chat_server/internal/service/chat"
+	"kama_chat_server/internal/service/gorm"
+	"kama_chat_server/pkg/constants"
+	"kama_chat_server/pkg/zlog"
+	"net/http"
+)
+
+// PassGroupApplies 批量通过加群申请
+func PassGroupApplies(c *gin.Context) {
+	var req request.HandleGroupApplyRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, events, ret := gorm.GroupApplyService.PassGroupApplies(middleware.GetUuid(c), req)
+	deliverGroupApplyResults(events)
+	JsonBack(c, message, ret, nil)
+}
+
+// RefuseGroupApplies 批量拒绝加群申请
+func RefuseGroupApplies(c *gin.Context) {
+	var req request.HandleGroupApplyRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, events, ret := gorm.GroupApplyService.RefuseGroupApplies(middleware.GetUuid(c), req)
+	deliverGroupApplyResults(events)
+	JsonBack(c, message, ret, nil)
+}
+
+// deliverGroupApplyResults 处理结果通知申请人，同时通知群主和管理员刷新待处理列表
+func deliverGroupApplyResults(events []respond.GroupApplyResultEventRespond) {
+	for _, event := range events {
+		chat.DeliverToUser(event.UserId, event)
+		chat.DeliverToGroupManagers(event.GroupId, event)
+	}
+}
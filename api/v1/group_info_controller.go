@@ -3,6 +3,9 @@ package v1
 import (
 	"github.com/gin-gonic/gin"
 	"kama_chat_server/internal/dto/request"
+	"kama_chat_server/internal/dto/respond"
+	"kama_chat_server/internal/middleware"
+	"kama_chat_server/internal/service/chat"
 	"kama_chat_server/internal/service/gorm"
 	"kama_chat_server/pkg/constants"
 	"kama_chat_server/pkg/zlog"
@@ -20,6 +23,7 @@ func CreateGroup(c *gin.Context) {
 		})
 		return
 	}
+	createGroupReq.OwnerId = middleware.GetUuid(c)
 	message, ret := gorm.GroupInfoService.CreateGroup(createGroupReq)
 	JsonBack(c, message, ret, nil)
 }
@@ -35,7 +39,7 @@ func LoadMyGroup(c *gin.Context) {
 		})
 		return
 	}
-	message, groupList, ret := gorm.GroupInfoService.LoadMyGroup(loadMyGroupReq.OwnerId)
+	message, groupList, ret := gorm.GroupInfoService.LoadMyGroup(middleware.GetUuid(c))
 	JsonBack(c, message, ret, groupList)
 }
 
@@ -65,7 +69,7 @@ func EnterGroupDirectly(c *gin.Context) {
 		})
 		return
 	}
-	message, ret := gorm.GroupInfoService.EnterGroupDirectly(req.OwnerId, req.ContactId)
+	message, ret := gorm.GroupInfoService.EnterGroupDirectly(req.OwnerId, middleware.GetUuid(c))
 	JsonBack(c, message, ret, nil)
 }
 
@@ -80,10 +84,43 @@ func LeaveGroup(c *gin.Context) {
 		})
 		return
 	}
-	message, ret := gorm.GroupInfoService.LeaveGroup(req.UserId, req.GroupId)
+	message, transferEvent, ret := gorm.GroupInfoService.LeaveGroup(middleware.GetUuid(c), req.GroupId)
+	// 群主退群时自动转让，通知新群主
+	if ret == 0 && transferEvent != nil {
+		notifyOwnerTransfer(transferEvent)
+	}
+	JsonBack(c, message, ret, nil)
+}
+
+// TransferGroupOwner 转让群主
+func TransferGroupOwner(c *gin.Context) {
+	var req request.TransferGroupOwnerRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, transferEvent, ret := gorm.GroupInfoService.TransferGroupOwner(middleware.GetUuid(c), req)
+	if ret == 0 {
+		notifyOwnerTransfer(transferEvent)
+	}
 	JsonBack(c, message, ret, nil)
 }
 
+// notifyOwnerTransfer 在群聊中发系统通知，存表后所有成员都能同步到，同时给在线的新群主推送转让事件
+func notifyOwnerTransfer(event *respond.OwnerTransferEventRespond) {
+	notice, err := gorm.GroupInfoService.NewOwnerTransferNotice(event)
+	if err != nil {
+		zlog.Error(err.Error())
+	} else if err := chat.PublishMessages([]request.ChatMessageRequest{*notice}); err != nil {
+		zlog.Error(err.Error())
+	}
+	chat.DeliverToUser(event.NewOwnerId, event)
+}
+
 // DismissGroup 解散群聊
 func DismissGroup(c *gin.Context) {
 	var req request.DismissGroupRequest
@@ -95,7 +132,7 @@ func DismissGroup(c *gin.Context) {
 		})
 		return
 	}
-	message, ret := gorm.GroupInfoService.DismissGroup(req.OwnerId, req.GroupId)
+	message, ret := gorm.GroupInfoService.DismissGroup(middleware.GetUuid(c), req.GroupId)
 	JsonBack(c, message, ret, nil)
 }
 
@@ -161,6 +198,7 @@ func UpdateGroupInfo(c *gin.Context) {
 		})
 		return
 	}
+	req.OwnerId = middleware.GetUuid(c)
 	message, ret := gorm.GroupInfoService.UpdateGroupInfo(req)
 	JsonBack(c, message, ret, nil)
 }
@@ -191,6 +229,67 @@ func RemoveGroupMembers(c *gin.Context) {
 		})
 		return
 	}
+	req.OwnerId = middleware.GetUuid(c)
 	message, ret := gorm.GroupInfoService.RemoveGroupMembers(req)
 	JsonBack(c, message, ret, nil)
 }
+
+// SetGroupNickname 设置自己的群昵称
+func SetGroupNickname(c *gin.Context) {
+	var req request.SetGroupNicknameRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.GroupInfoService.SetGroupNickname(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, nil)
+}
+
+// SetGroupAdmin 设置或取消管理员
+func SetGroupAdmin(c *gin.Context) {
+	var req request.SetGroupAdminRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.GroupInfoService.SetGroupAdmin(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, nil)
+}
+
+// MuteGroupMember 禁言或解除禁言群成员
+func MuteGroupMember(c *gin.Context) {
+	var req request.MuteGroupMemberRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.GroupInfoService.MuteGroupMember(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, nil)
+}
+
+// MuteAll 开启或关闭全员禁言
+func MuteAll(c *gin.Context) {
+	var req request.MuteAllRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.GroupInfoService.MuteAll(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, nil)
+}
@@ -0,0 +1,91 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"kama_chat_server/internal/dto/request"
+	"kama_chat_server/internal/middleware"
+	"kama_chat_server/internal/service/chat"
+	"kama_chat_server/internal/service/gorm"
+	"kama_chat_server/pkg/constants"
+	"kama_chat_server/pkg/zlog"
+	"net/http"
+)
+
+// CreateGroupInvite 创建群聊邀请
+func CreateGroupInvite(c *gin.Context) {
+	var req request.CreateGroupInviteRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.GroupInviteService.CreateInvite(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, rsp)
+}
+
+// GetGroupInviteList 获取群聊有效的邀请
+func GetGroupInviteList(c *gin.Context) {
+	var req request.GetGroupInviteListRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.GroupInviteService.GetInviteList(middleware.GetUuid(c), req.GroupId)
+	JsonBack(c, message, ret, rsp)
+}
+
+// RevokeGroupInvite 撤销群聊邀请
+func RevokeGroupInvite(c *gin.Context) {
+	var req request.GroupInviteTokenRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.GroupInviteService.RevokeInvite(middleware.GetUuid(c), req.Token)
+	JsonBack(c, message, ret, nil)
+}
+
+// PreviewGroupInvite 通过邀请预览群聊，不需要登录
+func PreviewGroupInvite(c *gin.Context) {
+	var req request.GroupInviteTokenRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.GroupInviteService.PreviewInvite(req.Token)
+	JsonBack(c, message, ret, rsp)
+}
+
+// JoinGroupByInvite 通过邀请加入群聊
+func JoinGroupByInvite(c *gin.Context) {
+	var req request.JoinGroupByInviteRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, applyEvent, ret := gorm.GroupInviteService.JoinGroupByInvite(middleware.GetUuid(c), req)
+	// 需要审核时提交了申请，通知群主和管理员
+	if ret == 0 && applyEvent != nil {
+		chat.DeliverToGroupManagers(applyEvent.GroupId, applyEvent)
+	}
+	JsonBack(c, message, ret, rsp)
+}
@@ -3,8 +3,11 @@ package v1
 import (
 	"github.com/gin-gonic/gin"
 	"kama_chat_server/internal/dto/request"
+	"kama_chat_server/internal/middleware"
+	"kama_chat_server/internal/service/chat"
 	"kama_chat_server/internal/service/gorm"
 	"kama_chat_server/pkg/constants"
+	"kama_chat_server/pkg/zlog"
 	"net/http"
 )
 
@@ -18,7 +21,7 @@ func GetMessageList(c *gin.Context) {
 		})
 		return
 	}
-	message, rsp, ret := gorm.MessageService.GetMessageList(req.UserOneId, req.UserTwoId)
+	message, rsp, ret := gorm.MessageService.GetMessageList(middleware.GetUuid(c), req)
 	JsonBack(c, message, ret, rsp)
 }
 
@@ -32,7 +35,207 @@ func GetGroupMessageList(c *gin.Context) {
 		})
 		return
 	}
-	message, rsp, ret := gorm.MessageService.GetGroupMessageList(req.GroupId)
+	message, rsp, ret := gorm.MessageService.GetGroupMessageList(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, rsp)
+}
+
+// GetThreadMessageList 获取群聊话题中的回复
+func GetThreadMessageList(c *gin.Context) {
+	var req request.GetThreadMessageListRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.MessageService.GetThreadMessageList(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, rsp)
+}
+
+// GetMentionList 获取@了自己的群聊消息
+func GetMentionList(c *gin.Context) {
+	var req request.GetMentionListRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.MessageService.GetMentionList(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, rsp)
+}
+
+// SearchMessages 在自己的所有会话中搜索消息
+func SearchMessages(c *gin.Context) {
+	var req request.SearchMessageRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.SearchService.SearchMessages(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, rsp)
+}
+
+// GetMessageListAfterSeq 获取会话中某个序号之后的消息
+func GetMessageListAfterSeq(c *gin.Context) {
+	var req request.GetMessageListAfterSeqRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.MessageService.GetMessageListAfterSeq(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, rsp)
+}
+
+// SyncMessages 同步所有会话中游标之后的消息
+func SyncMessages(c *gin.Context) {
+	var req request.SyncMessageRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.MessageService.SyncMessages(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, rsp)
+}
+
+// GetGroupReadCounts 获取群聊消息的已读人数
+func GetGroupReadCounts(c *gin.Context) {
+	var req request.GetGroupReadCountsRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.MessageService.GetGroupReadCounts(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, rsp)
+}
+
+// RecallMessage 撤回消息，成功后通知会话中的所有用户
+func RecallMessage(c *gin.Context) {
+	var req request.RecallMessageRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.MessageService.RecallMessage(middleware.GetUuid(c), req.MessageUuid)
+	if ret == 0 {
+		chat.DeliverToConversation(rsp.SendId, rsp.ReceiveId, rsp)
+	}
+	JsonBack(c, message, ret, rsp)
+}
+
+// EditMessage 编辑消息，成功后通知会话中的所有用户
+func EditMessage(c *gin.Context) {
+	var req request.EditMessageRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.MessageService.EditMessage(middleware.GetUuid(c), req)
+	if ret == 0 {
+		chat.DeliverToConversation(rsp.SendId, rsp.ReceiveId, rsp)
+	}
+	JsonBack(c, message, ret, rsp)
+}
+
+// GetMessageRevisions 获取消息的编辑历史
+func GetMessageRevisions(c *gin.Context) {
+	var req request.GetMessageRevisionsRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.MessageService.GetMessageRevisions(middleware.GetUuid(c), req.MessageUuid)
+	JsonBack(c, message, ret, rsp)
+}
+
+// AddReaction 添加表情回应
+func AddReaction(c *gin.Context) {
+	var req request.ReactMessageRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.MessageService.ReactMessage(middleware.GetUuid(c), req, false)
+	if ret == 0 {
+		chat.DeliverToConversation(rsp.SendId, rsp.ReceiveId, rsp)
+	}
+	JsonBack(c, message, ret, nil)
+}
+
+// RemoveReaction 取消表情回应
+func RemoveReaction(c *gin.Context) {
+	var req request.ReactMessageRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.MessageService.ReactMessage(middleware.GetUuid(c), req, true)
+	if ret == 0 {
+		chat.DeliverToConversation(rsp.SendId, rsp.ReceiveId, rsp)
+	}
+	JsonBack(c, message, ret, nil)
+}
+
+// ForwardMessage 逐条或合并转发消息
+func ForwardMessage(c *gin.Context) {
+	var req request.ForwardMessageRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, chatMessageReqs, ret := gorm.MessageService.ForwardMessages(middleware.GetUuid(c), req)
+	if ret == 0 {
+		if err := chat.PublishMessages(chatMessageReqs); err != nil {
+			zlog.Error(err.Error())
+			message, ret = "部分消息转发失败，请稍后重试", -1
+		}
+	}
+	JsonBack(c, message, ret, nil)
+}
+
+// GetChatHistory 展开合并转发的聊天记录
+func GetChatHistory(c *gin.Context) {
+	var req request.GetChatHistoryRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.MessageService.GetChatHistory(middleware.GetUuid(c), req.MessageUuid)
 	JsonBack(c, message, ret, rsp)
 }
 
@@ -42,6 +245,31 @@ func UploadAvatar(c *gin.Context) {
 	JsonBack(c, message, ret, nil)
 }
 
+// UploadVoice 上传语音
+func UploadVoice(c *gin.Context) {
+	message, rsp, ret := gorm.MessageService.UploadVoice(middleware.GetUuid(c), c)
+	JsonBack(c, message, ret, rsp)
+}
+
+// ListenVoice 标记语音已收听
+func ListenVoice(c *gin.Context) {
+	var req request.ListenVoiceRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.MessageService.ListenVoice(middleware.GetUuid(c), req.MessageUuid)
+	// 只有第一次收听时通知发送者
+	if ret == 0 && rsp != nil {
+		chat.DeliverToUser(rsp.SendId, rsp)
+	}
+	JsonBack(c, message, ret, nil)
+}
+
 // UploadFile 上传头像
 func UploadFile(c *gin.Context) {
 	message, ret := gorm.MessageService.UploadFile(c)
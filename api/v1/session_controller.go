@@ -3,6 +3,7 @@ package v1
 import (
 	"github.com/gin-gonic/gin"
 	"kama_chat_server/internal/dto/request"
+	"kama_chat_server/internal/middleware"
 	"kama_chat_server/internal/service/gorm"
 	"kama_chat_server/pkg/constants"
 	"kama_chat_server/pkg/zlog"
@@ -20,6 +21,7 @@ func OpenSession(c *gin.Context) {
 		})
 		return
 	}
+	openSessionReq.SendId = middleware.GetUuid(c)
 	message, sessionId, ret := gorm.SessionService.OpenSession(openSessionReq)
 	JsonBack(c, message, ret, sessionId)
 }
@@ -35,7 +37,7 @@ func GetUserSessionList(c *gin.Context) {
 		})
 		return
 	}
-	message, sessionList, ret := gorm.SessionService.GetUserSessionList(getUserSessionListReq.OwnerId)
+	message, sessionList, ret := gorm.SessionService.GetUserSessionList(middleware.GetUuid(c))
 	JsonBack(c, message, ret, sessionList)
 }
 
@@ -50,7 +52,7 @@ func GetGroupSessionList(c *gin.Context) {
 		})
 		return
 	}
-	message, groupList, ret := gorm.SessionService.GetGroupSessionList(getGroupListReq.OwnerId)
+	message, groupList, ret := gorm.SessionService.GetGroupSessionList(middleware.GetUuid(c))
 	JsonBack(c, message, ret, groupList)
 }
 
@@ -65,7 +67,7 @@ func DeleteSession(c *gin.Context) {
 		})
 		return
 	}
-	message, ret := gorm.SessionService.DeleteSession(deleteSessionReq.OwnerId, deleteSessionReq.SessionId)
+	message, ret := gorm.SessionService.DeleteSession(middleware.GetUuid(c), deleteSessionReq.SessionId)
 	JsonBack(c, message, ret, nil)
 }
 
@@ -80,6 +82,66 @@ func CheckOpenSessionAllowed(c *gin.Context) {
 		})
 		return
 	}
-	message, res, ret := gorm.SessionService.CheckOpenSessionAllowed(req.SendId, req.ReceiveId)
+	message, res, ret := gorm.SessionService.CheckOpenSessionAllowed(middleware.GetUuid(c), req.ReceiveId)
 	JsonBack(c, message, ret, res)
 }
+
+// PinSession 置顶或取消置顶会话
+func PinSession(c *gin.Context) {
+	var req request.PinSessionRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.SessionService.PinSession(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, nil)
+}
+
+// ReorderPinnedSessions 调整置顶会话的顺序
+func ReorderPinnedSessions(c *gin.Context) {
+	var req request.ReorderPinnedSessionsRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.SessionService.ReorderPinnedSessions(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, nil)
+}
+
+// MuteSession 开启或关闭会话免打扰
+func MuteSession(c *gin.Context) {
+	var req request.MuteSessionRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.SessionService.MuteSession(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, nil)
+}
+
+// ArchiveSession 归档或取消归档会话
+func ArchiveSession(c *gin.Context) {
+	var req request.ArchiveSessionRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.SessionService.ArchiveSession(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, nil)
+}
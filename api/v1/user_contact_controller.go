@@ -3,6 +3,8 @@ package v1
 import (
 	"github.com/gin-gonic/gin"
 	"kama_chat_server/internal/dto/request"
+	"kama_chat_server/internal/middleware"
+	"kama_chat_server/internal/service/chat"
 	"kama_chat_server/internal/service/gorm"
 	"kama_chat_server/pkg/constants"
 	"kama_chat_server/pkg/zlog"
@@ -20,7 +22,7 @@ func GetUserList(c *gin.Context) {
 			"message": constants.SYSTEM_ERROR,
 		})
 	}
-	message, userList, ret := gorm.UserContactService.GetUserList(myUserListReq.OwnerId)
+	message, userList, ret := gorm.UserContactService.GetUserList(middleware.GetUuid(c))
 	JsonBack(c, message, ret, userList)
 }
 
@@ -35,7 +37,7 @@ func LoadMyJoinedGroup(c *gin.Context) {
 		})
 		return
 	}
-	message, groupList, ret := gorm.UserContactService.LoadMyJoinedGroup(loadMyJoinedGroupReq.OwnerId)
+	message, groupList, ret := gorm.UserContactService.LoadMyJoinedGroup(middleware.GetUuid(c))
 	JsonBack(c, message, ret, groupList)
 }
 
@@ -66,7 +68,7 @@ func DeleteContact(c *gin.Context) {
 		})
 		return
 	}
-	message, ret := gorm.UserContactService.DeleteContact(deleteContactReq.OwnerId, deleteContactReq.ContactId)
+	message, ret := gorm.UserContactService.DeleteContact(middleware.GetUuid(c), deleteContactReq.ContactId)
 	JsonBack(c, message, ret, nil)
 }
 
@@ -81,6 +83,15 @@ func ApplyContact(c *gin.Context) {
 		})
 		return
 	}
+	applyContactReq.OwnerId = middleware.GetUuid(c)
+	if applyContactReq.ContactId != "" && applyContactReq.ContactId[0] == 'G' {
+		message, rsp, ret := gorm.GroupApplyService.ApplyGroup(applyContactReq)
+		if ret == 0 {
+			chat.DeliverToGroupManagers(rsp.GroupId, rsp)
+		}
+		JsonBack(c, message, ret, nil)
+		return
+	}
 	message, ret := gorm.UserContactService.ApplyContact(applyContactReq)
 	JsonBack(c, message, ret, nil)
 }
@@ -96,7 +107,7 @@ func GetNewContactList(c *gin.Context) {
 		})
 		return
 	}
-	message, data, ret := gorm.UserContactService.GetNewContactList(req.OwnerId)
+	message, data, ret := gorm.UserContactService.GetNewContactList(middleware.GetUuid(c))
 	JsonBack(c, message, ret, data)
 }
 
@@ -111,7 +122,17 @@ func PassContactApply(c *gin.Context) {
 		})
 		return
 	}
-	message, ret := gorm.UserContactService.PassContactApply(passContactApplyReq.OwnerId, passContactApplyReq.ContactId)
+	// 群聊的申请走加群审核，兼容只处理一条的旧接口
+	if passContactApplyReq.OwnerId != "" && passContactApplyReq.OwnerId[0] == 'G' {
+		message, events, ret := gorm.GroupApplyService.PassGroupApplies(middleware.GetUuid(c), request.HandleGroupApplyRequest{
+			GroupId: passContactApplyReq.OwnerId,
+			UserIds: []string{passContactApplyReq.ContactId},
+		})
+		deliverGroupApplyResults(events)
+		JsonBack(c, message, ret, nil)
+		return
+	}
+	message, ret := gorm.UserContactService.PassContactApply(middleware.GetUuid(c), passContactApplyReq.ContactId)
 	JsonBack(c, message, ret, nil)
 }
 
@@ -126,7 +147,16 @@ func RefuseContactApply(c *gin.Context) {
 		})
 		return
 	}
-	message, ret := gorm.UserContactService.RefuseContactApply(passContactApplyReq.OwnerId, passContactApplyReq.ContactId)
+	if passContactApplyReq.OwnerId != "" && passContactApplyReq.OwnerId[0] == 'G' {
+		message, events, ret := gorm.GroupApplyService.RefuseGroupApplies(middleware.GetUuid(c), request.HandleGroupApplyRequest{
+			GroupId: passContactApplyReq.OwnerId,
+			UserIds: []string{passContactApplyReq.ContactId},
+		})
+		deliverGroupApplyResults(events)
+		JsonBack(c, message, ret, nil)
+		return
+	}
+	message, ret := gorm.UserContactService.RefuseContactApply(middleware.GetUuid(c), passContactApplyReq.ContactId)
 	JsonBack(c, message, ret, nil)
 }
 
@@ -141,7 +171,7 @@ func BlackContact(c *gin.Context) {
 		})
 		return
 	}
-	message, ret := gorm.UserContactService.BlackContact(req.OwnerId, req.ContactId)
+	message, ret := gorm.UserContactService.BlackContact(middleware.GetUuid(c), req.ContactId)
 	JsonBack(c, message, ret, nil)
 }
 
@@ -156,7 +186,7 @@ func CancelBlackContact(c *gin.Context) {
 		})
 		return
 	}
-	message, ret := gorm.UserContactService.CancelBlackContact(req.OwnerId, req.ContactId)
+	message, ret := gorm.UserContactService.CancelBlackContact(middleware.GetUuid(c), req.ContactId)
 	JsonBack(c, message, ret, nil)
 }
 
@@ -171,7 +201,7 @@ func GetAddGroupList(c *gin.Context) {
 		})
 		return
 	}
-	message, data, ret := gorm.UserContactService.GetAddGroupList(req.GroupId)
+	message, data, ret := gorm.UserContactService.GetAddGroupList(middleware.GetUuid(c), req.GroupId)
 	JsonBack(c, message, ret, data)
 }
 
@@ -186,6 +216,6 @@ func BlackApply(c *gin.Context) {
 		})
 		return
 	}
-	message, ret := gorm.UserContactService.BlackApply(req.OwnerId, req.ContactId)
+	message, ret := gorm.UserContactService.BlackApply(middleware.GetUuid(c), req.OwnerId, req.ContactId)
 	JsonBack(c, message, ret, nil)
 }
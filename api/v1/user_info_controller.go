@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"kama_chat_server/internal/dto/request"
+	"kama_chat_server/internal/middleware"
 	"kama_chat_server/internal/service/gorm"
 	"kama_chat_server/pkg/constants"
 	"kama_chat_server/pkg/zlog"
@@ -67,6 +68,7 @@ func UpdateUserInfo(c *gin.Context) {
 		})
 		return
 	}
+	req.Uuid = middleware.GetUuid(c)
 	message, ret := gorm.UserInfoService.UpdateUserInfo(req)
 	JsonBack(c, message, ret, nil)
 }
@@ -82,7 +84,7 @@ func GetUserInfoList(c *gin.Context) {
 		})
 		return
 	}
-	message, userList, ret := gorm.UserInfoService.GetUserInfoList(req.OwnerId)
+	message, userList, ret := gorm.UserInfoService.GetUserInfoList(middleware.GetUuid(c))
 	JsonBack(c, message, ret, userList)
 }
 
@@ -161,6 +163,51 @@ func SetAdmin(c *gin.Context) {
 	JsonBack(c, message, ret, nil)
 }
 
+// ChangePassword 修改密码
+func ChangePassword(c *gin.Context) {
+	var req request.ChangePasswordRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.UserInfoService.ChangePassword(middleware.GetUuid(c), req)
+	JsonBack(c, message, ret, nil)
+}
+
+// ResetPassword 通过短信验证码重置密码
+func ResetPassword(c *gin.Context) {
+	var req request.ResetPasswordRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.UserInfoService.ResetPassword(req)
+	JsonBack(c, message, ret, nil)
+}
+
+// RefreshToken 刷新token
+func RefreshToken(c *gin.Context) {
+	var req request.RefreshTokenRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.UserInfoService.RefreshToken(req.RefreshToken)
+	JsonBack(c, message, ret, rsp)
+}
+
 // SendSmsCode 发送短信验证码
 func SendSmsCode(c *gin.Context) {
 	var req request.SendSmsCodeRequest
@@ -3,6 +3,7 @@ package v1
 import (
 	"github.com/gin-gonic/gin"
 	"kama_chat_server/internal/dto/request"
+	"kama_chat_server/internal/middleware"
 	"kama_chat_server/internal/service/chat"
 	"kama_chat_server/pkg/constants"
 	"kama_chat_server/pkg/zlog"
@@ -10,8 +11,9 @@ import (
 )
 
 // WsLogin wss登录 Get
+// 连接身份只来自握手时携带的token，不再信任client_id参数
 func WsLogin(c *gin.Context) {
-	clientId := c.Query("client_id")
+	clientId := middleware.GetUuid(c)
 	if clientId == "" {
 		zlog.Error("clientId获取失败")
 		c.JSON(http.StatusOK, gin.H{
@@ -34,6 +36,6 @@ func WsLogout(c *gin.Context) {
 		})
 		return
 	}
-	message, ret := chat.ClientLogout(req.OwnerId)
+	message, ret := chat.ClientLogout(middleware.GetUuid(c))
 	JsonBack(c, message, ret, nil)
 }
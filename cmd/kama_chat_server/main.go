@@ -1,12 +1,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"kama_chat_server/internal/config"
+	"kama_chat_server/internal/dao"
 	"kama_chat_server/internal/https_server"
 	"kama_chat_server/internal/service/chat"
 	"kama_chat_server/internal/service/kafka"
-	myredis "kama_chat_server/internal/service/redis"
 	"kama_chat_server/pkg/zlog"
 	"os"
 	"os/signal"
@@ -14,6 +15,17 @@ import (
 )
 
 func main() {
+	// 运维确认群成员迁移无误后，用这个参数单独执行一次，删除旧版的members列
+	dropLegacyGroupMembers := flag.Bool("drop-legacy-group-members", false, "删除group_info表中旧版的members列后退出")
+	flag.Parse()
+	if *dropLegacyGroupMembers {
+		if err := dao.DropLegacyGroupMembers(); err != nil {
+			zlog.Fatal(err.Error())
+		}
+		zlog.Info("已删除group_info.members列")
+		return
+	}
+
 	conf := config.GetConfig()
 	host := conf.MainConfig.Host
 	port := conf.MainConfig.Port
@@ -22,12 +34,17 @@ func main() {
 		kafka.KafkaService.KafkaInit()
 	}
 
-	if kafkaConfig.MessageMode == "channel" {
-		go chat.ChatServer.Start()
-	} else {
-		go chat.KafkaChatServer.Start()
+	// 数据迁移改动了会话序号时，先重置redis中的计数器再启动消息流水线
+	if migrated, err := dao.MigrateMessageSeq(); err != nil {
+		zlog.Fatal(err.Error())
+	} else if migrated {
+		if err := chat.ResetSeqCounters(); err != nil {
+			zlog.Fatal(err.Error())
+		}
 	}
 
+	go chat.ChatServer.Start()
+
 	go func() {
 		// Win10本地部署
 		// if err := https_server.GE.RunTLS(fmt.Sprintf("%s:%d", host, port), "pkg/ssl/127.0.0.1+2.pem", "pkg/ssl/127.0.0.1+2-key.pem"); err != nil {
@@ -48,20 +65,16 @@ func main() {
 	// 等待信号
 	<-quit
 
+	chat.ChatServer.Close()
+
 	if kafkaConfig.MessageMode == "kafka" {
 		kafka.KafkaService.KafkaClose()
 	}
 
-	chat.ChatServer.Close()
-
 	zlog.Info("关闭服务器...")
 
-	// 删除所有Redis键
-	if err := myredis.DeleteAllRedisKeys(); err != nil {
-		zlog.Error(err.Error())
-	} else {
-		zlog.Info("所有Redis键已删除")
-	}
+	// 多实例部署时redis由所有节点共享，不能在单个节点退出时清空，缓存依靠过期时间失效
+	// 本机用户的在线状态已经在ChatServer.Close中删除
 
 	zlog.Info("服务器已关闭")
 
@@ -10,6 +10,7 @@ type MainConfig struct {
 	AppName string `toml:"appName"`
 	Host    string `toml:"host"`
 	Port    int    `toml:"port"`
+	NodeId  string `toml:"nodeId"` // 多实例部署时每个实例唯一，留空则使用 主机名:端口
 }
 
 type MysqlConfig struct {
@@ -51,6 +52,25 @@ type KafkaConfig struct {
 type StaticSrcConfig struct {
 	StaticAvatarPath string `toml:"staticAvatarPath"`
 	StaticFilePath   string `toml:"staticFilePath"`
+	StaticVoicePath  string `toml:"staticVoicePath"`
+}
+
+type JwtConfig struct {
+	Secret        string        `toml:"secret"`
+	AccessExpire  time.Duration `toml:"accessExpire"`
+	RefreshExpire time.Duration `toml:"refreshExpire"`
+}
+
+type MessageConfig struct {
+	RecallWindow     time.Duration `toml:"recallWindow"`
+	AllowEdit        bool          `toml:"allowEdit"`
+	EditWindow       time.Duration `toml:"editWindow"`
+	MaxEditCount     int           `toml:"maxEditCount"`
+	MaxVoiceDuration int           `toml:"maxVoiceDuration"`
+}
+
+type SearchConfig struct {
+	Engine string `toml:"engine"`
 }
 
 type Config struct {
@@ -61,6 +81,9 @@ type Config struct {
 	LogConfig       `toml:"logConfig"`
 	KafkaConfig     `toml:"kafkaConfig"`
 	StaticSrcConfig `toml:"staticSrcConfig"`
+	JwtConfig       `toml:"jwtConfig"`
+	MessageConfig   `toml:"messageConfig"`
+	SearchConfig    `toml:"searchConfig"`
 }
 
 var config *Config
@@ -0,0 +1,25 @@
+package dao
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"kama_chat_server/internal/model"
+	"time"
+)
+
+// runOnce 执行一次数据迁移，成功后记录到data_migration表，已经记录过的返回false
+// 迁移本身要能在中途失败后重新执行
+func runOnce(name string, migrate func() error) (bool, error) {
+	var record model.DataMigration
+	res := GormDB.Where("name = ?", name).First(&record)
+	if res.Error == nil {
+		return false, nil
+	}
+	if !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return false, res.Error
+	}
+	if err := migrate(); err != nil {
+		return false, err
+	}
+	return true, GormDB.Create(&model.DataMigration{Name: name, CreatedAt: time.Now()}).Error
+}
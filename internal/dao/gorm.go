@@ -25,8 +25,14 @@ func init() {
 	if err != nil {
 		zlog.Fatal(err.Error())
 	}
-	err = GormDB.AutoMigrate(&model.UserInfo{}, &model.GroupInfo{}, &model.UserContact{}, &model.Session{}, &model.ContactApply{}, &model.Message{}) // 自动迁移，如果没有建表，会自动创建对应的表
+	err = GormDB.AutoMigrate(&model.UserInfo{}, &model.GroupInfo{}, &model.UserContact{}, &model.Session{}, &model.ContactApply{}, &model.Message{}, &model.MessageCursor{}, &model.MessageRevision{}, &model.MessageReaction{}, &model.MessageMention{}, &model.VoiceListen{}, &model.VoiceUpload{}, &model.GroupMember{}, &model.GroupInvite{}, &model.DataMigration{}) // 自动迁移，如果没有建表，会自动创建对应的表
 	if err != nil {
 		zlog.Fatal(err.Error())
 	}
+	if err := ensureMessageFulltext(); err != nil {
+		zlog.Fatal(err.Error())
+	}
+	if err := migrateGroupMembers(); err != nil {
+		zlog.Fatal(err.Error())
+	}
 }
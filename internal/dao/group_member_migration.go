@@ -0,0 +1,109 @@
+package dao
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"kama_chat_server/internal/model"
+	"kama_chat_server/pkg/enum/group_member/member_role_enum"
+	"kama_chat_server/pkg/zlog"
+	"time"
+)
+
+// legacyGroup 旧版group_info表中用json数组保存成员的群聊
+type legacyGroup struct {
+	Uuid      string
+	OwnerId   string
+	Members   json.RawMessage
+	CreatedAt time.Time
+}
+
+// migrateGroupMembers 把旧版group_info.members中的成员迁移到group_member表，只执行一次
+// 已解散的群聊也迁移，members列保留不删，确认迁移无误后由运维通过DropLegacyGroupMembers删除
+func migrateGroupMembers() error {
+	if !GormDB.Migrator().HasColumn(&model.GroupInfo{}, "members") {
+		return nil
+	}
+	_, err := runOnce("group_member", backfillGroupMembers)
+	return err
+}
+
+// backfillGroupMembers 包括已解散的群聊，中途失败时重新执行不会重复插入
+func backfillGroupMembers() error {
+	var groupList []legacyGroup
+	if res := GormDB.Table("group_info").Select("uuid, owner_id, members, created_at").Find(&groupList); res.Error != nil {
+		return res.Error
+	}
+	err := GormDB.Transaction(func(tx *gorm.DB) error {
+		for _, group := range groupList {
+			var members []string
+			if len(group.Members) > 0 {
+				if err := json.Unmarshal(group.Members, &members); err != nil {
+					return fmt.Errorf("群聊%s的成员格式错误：%w", group.Uuid, err)
+				}
+			}
+			// 入群时间取群聊联系人的创建时间，没有时用建群时间，已解散群聊的联系人已经软删除
+			var contactList []model.UserContact
+			if res := tx.Unscoped().Where("contact_id = ?", group.Uuid).Find(&contactList); res.Error != nil {
+				return res.Error
+			}
+			joinedAt := make(map[string]time.Time, len(contactList))
+			for _, contact := range contactList {
+				joinedAt[contact.UserId] = contact.CreatedAt
+			}
+			memberList := []model.GroupMember{{
+				GroupId:   group.Uuid,
+				UserId:    group.OwnerId,
+				Role:      member_role_enum.OWNER,
+				JoinedAt:  group.CreatedAt,
+				UpdatedAt: time.Now(),
+			}}
+			for _, member := range members {
+				if member == group.OwnerId {
+					continue
+				}
+				groupMember := model.GroupMember{
+					GroupId:   group.Uuid,
+					UserId:    member,
+					Role:      member_role_enum.MEMBER,
+					JoinedAt:  group.CreatedAt,
+					UpdatedAt: time.Now(),
+				}
+				if t, ok := joinedAt[member]; ok {
+					groupMember.JoinedAt = t
+				}
+				memberList = append(memberList, groupMember)
+			}
+			// 旧数据中可能有重复的成员
+			if res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&memberList); res.Error != nil {
+				return res.Error
+			}
+			if res := tx.Exec("UPDATE group_info SET member_cnt = (SELECT COUNT(*) FROM group_member WHERE group_id = ?) WHERE uuid = ?", group.Uuid, group.Uuid); res.Error != nil {
+				return res.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	zlog.Info(fmt.Sprintf("已迁移%d个群聊的成员到group_member表", len(groupList)))
+	return nil
+}
+
+// DropLegacyGroupMembers 删除旧版group_info.members列，不会自动执行，确认迁移无误后由运维手动执行
+func DropLegacyGroupMembers() error {
+	if !GormDB.Migrator().HasColumn(&model.GroupInfo{}, "members") {
+		return nil
+	}
+	var count int64
+	if res := GormDB.Model(&model.DataMigration{}).Where("name = ?", "group_member").Count(&count); res.Error != nil {
+		return res.Error
+	}
+	if count == 0 {
+		return errors.New("群成员还没有迁移到group_member表，不能删除members列")
+	}
+	return GormDB.Migrator().DropColumn(&model.GroupInfo{}, "members")
+}
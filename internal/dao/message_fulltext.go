@@ -0,0 +1,18 @@
+package dao
+
+import (
+	"kama_chat_server/internal/config"
+	"kama_chat_server/internal/model"
+)
+
+// ensureMessageFulltext 使用mysql搜索时，没有全文索引就在启动时创建，使用ngram分词支持中文
+// 大表上建索引比较慢，放在启动迁移中执行，失败时直接退出，不能等到搜索时才发现
+func ensureMessageFulltext() error {
+	if config.GetConfig().SearchConfig.Engine == "memory" {
+		return nil
+	}
+	if GormDB.Migrator().HasIndex(&model.Message{}, "idx_message_fulltext") {
+		return nil
+	}
+	return GormDB.Exec("CREATE FULLTEXT INDEX idx_message_fulltext ON message(content, file_name) WITH PARSER ngram").Error
+}
@@ -0,0 +1,93 @@
+package dao
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"kama_chat_server/internal/model"
+	"kama_chat_server/pkg/util/conversation"
+	"kama_chat_server/pkg/zlog"
+)
+
+// legacyMessage 加入会话序号之前的消息，conversation_id为空，seq为0
+type legacyMessage struct {
+	Id        int64
+	SendId    string
+	ReceiveId string
+}
+
+// MigrateMessageSeq 给加入会话序号之前的消息补上conversation_id，并按id顺序在会话内编号，只执行一次
+// 执行过时返回true，调用方需要重置redis中的会话序号计数器，让它从补齐后的最大序号开始
+// 需要在消息流水线启动之前执行
+func MigrateMessageSeq() (bool, error) {
+	return runOnce("message_seq", migrateMessageSeq)
+}
+
+// migrateMessageSeq 旧消息都早于已经编号的消息，所以旧消息占用1到k，已经编号的消息和用户进度、@记录的序号整体后移k
+// 每个会话一个事务，中途失败时重新执行只处理还没有编号的会话
+func migrateMessageSeq() error {
+	// 先分批补上conversation_id，同一批中同一会话的消息一次更新
+	var batch []legacyMessage
+	res := GormDB.Model(&model.Message{}).Select("id, send_id, receive_id").Where("conversation_id = ''").
+		FindInBatches(&batch, 1000, func(tx *gorm.DB, _ int) error {
+			idsByConversation := make(map[string][]int64)
+			for _, message := range batch {
+				conversationId := conversation.GetConversationId(message.SendId, message.ReceiveId)
+				idsByConversation[conversationId] = append(idsByConversation[conversationId], message.Id)
+			}
+			for conversationId, ids := range idsByConversation {
+				if res := GormDB.Model(&model.Message{}).Where("id IN ?", ids).Update("conversation_id", conversationId); res.Error != nil {
+					return res.Error
+				}
+			}
+			return nil
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	// 再给每个还有未编号消息的会话编号
+	var conversationIds []string
+	if res := GormDB.Model(&model.Message{}).Distinct("conversation_id").Where("seq = 0").Pluck("conversation_id", &conversationIds); res.Error != nil {
+		return res.Error
+	}
+	for _, conversationId := range conversationIds {
+		if err := backfillConversationSeq(conversationId); err != nil {
+			return fmt.Errorf("会话%s补齐序号失败：%w", conversationId, err)
+		}
+	}
+	zlog.Info(fmt.Sprintf("已为%d个会话补齐会话序号", len(conversationIds)))
+	return nil
+}
+
+// backfillConversationSeq 在一个事务中给会话中seq为0的旧消息按id顺序编号
+func backfillConversationSeq(conversationId string) error {
+	return GormDB.Transaction(func(tx *gorm.DB) error {
+		var offset int64
+		if res := tx.Model(&model.Message{}).Where("conversation_id = ? AND seq = 0", conversationId).Count(&offset); res.Error != nil {
+			return res.Error
+		}
+		if offset == 0 {
+			return nil
+		}
+		if res := tx.Model(&model.Message{}).Where("conversation_id = ? AND seq > 0", conversationId).
+			Update("seq", gorm.Expr("seq + ?", offset)); res.Error != nil {
+			return res.Error
+		}
+		// 已经有进度的用户，旧消息视为已经确认和已读
+		if res := tx.Model(&model.MessageCursor{}).Where("conversation_id = ? AND ack_seq > 0", conversationId).
+			Update("ack_seq", gorm.Expr("ack_seq + ?", offset)); res.Error != nil {
+			return res.Error
+		}
+		if res := tx.Model(&model.MessageCursor{}).Where("conversation_id = ? AND read_seq > 0", conversationId).
+			Update("read_seq", gorm.Expr("read_seq + ?", offset)); res.Error != nil {
+			return res.Error
+		}
+		if res := tx.Model(&model.MessageMention{}).Where("group_id = ?", conversationId).
+			Update("seq", gorm.Expr("seq + ?", offset)); res.Error != nil {
+			return res.Error
+		}
+		// 一条语句按id顺序编号，派生表会先物化，可以更新同一张表
+		return tx.Exec(`UPDATE message m JOIN (
+			SELECT id, ROW_NUMBER() OVER (ORDER BY id) AS rn FROM message WHERE conversation_id = ? AND seq = 0
+		) t ON m.id = t.id SET m.seq = t.rn`, conversationId).Error
+	})
+}
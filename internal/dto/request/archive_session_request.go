@@ -0,0 +1,6 @@
+package request
+
+type ArchiveSessionRequest struct {
+	SessionId string `json:"session_id"`
+	Archived  bool   `json:"archived"`
+}
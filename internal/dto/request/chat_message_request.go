@@ -1,16 +1,21 @@
 package request
 
 type ChatMessageRequest struct {
-	SessionId  string `json:"session_id"`
-	Type       int8   `json:"type"`
-	Content    string `json:"content"`
-	Url        string `json:"url"`
-	SendId     string `json:"send_id"`
-	SendName   string `json:"send_name"`
-	SendAvatar string `json:"send_avatar"`
-	ReceiveId  string `json:"receive_id"`
-	FileSize   string `json:"file_size"`
-	FileType   string `json:"file_type"`
-	FileName   string `json:"file_name"`
-	AVdata     string `json:"av_data"`
+	SessionId  string   `json:"session_id"`
+	Type       int8     `json:"type"`
+	Content    string   `json:"content"`
+	Url        string   `json:"url"`
+	SendId     string   `json:"send_id"`
+	SendName   string   `json:"send_name"`
+	SendAvatar string   `json:"send_avatar"`
+	ReceiveId  string   `json:"receive_id"`
+	FileSize   string   `json:"file_size"`
+	FileType   string   `json:"file_type"`
+	FileName   string   `json:"file_name"`
+	AVdata     string   `json:"av_data"`
+	ReplyTo    string   `json:"reply_to"`    // 引用的消息uuid，可选，必须是同一会话中的消息
+	Mentions   []string `json:"mentions"`    // 群聊中@的成员uuid
+	MentionAll bool     `json:"mention_all"` // 群聊中@所有人，只有群主可以
+	Duration   int      `json:"duration"`    // 语音时长，单位秒，语音消息以上传记录为准
+	Waveform   []int    `json:"waveform"`    // 语音波形，语音消息以上传记录为准
 }
@@ -0,0 +1,9 @@
+package request
+
+// CreateGroupInviteRequest ExpireIn是有效秒数，为0时不过期；MaxUses为0时不限次数
+type CreateGroupInviteRequest struct {
+	GroupId     string `json:"group_id"`
+	ExpireIn    int    `json:"expire_in"`
+	MaxUses     int    `json:"max_uses"`
+	BypassAudit bool   `json:"bypass_audit"`
+}
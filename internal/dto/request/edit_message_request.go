@@ -0,0 +1,6 @@
+package request
+
+type EditMessageRequest struct {
+	MessageUuid string `json:"message_uuid"`
+	Content     string `json:"content"`
+}
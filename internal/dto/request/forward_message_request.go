@@ -0,0 +1,8 @@
+package request
+
+// ForwardMessageRequest Merge为false时逐条转发，为true时合并成一条聊天记录转发
+type ForwardMessageRequest struct {
+	MessageUuids []string `json:"message_uuids"`
+	Targets      []string `json:"targets"` // 目标用户或群聊uuid
+	Merge        bool     `json:"merge"`
+}
@@ -0,0 +1,5 @@
+package request
+
+type GetChatHistoryRequest struct {
+	MessageUuid string `json:"message_uuid"` // 聊天记录卡片消息的uuid
+}
@@ -1,5 +1,9 @@
 package request
 
+// GetGroupMessageListRequest before和after是消息uuid，都不传时返回最新的一页
 type GetGroupMessageListRequest struct {
-	GroupId string `json:"group_id"`
+	GroupId  string `json:"group_id"`
+	Before   string `json:"before"`
+	After    string `json:"after"`
+	PageSize int    `json:"page_size"`
 }
@@ -0,0 +1,6 @@
+package request
+
+type GetGroupReadCountsRequest struct {
+	GroupId      string   `json:"group_id"`
+	MessageUuids []string `json:"message_uuids"`
+}
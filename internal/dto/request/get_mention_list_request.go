@@ -0,0 +1,8 @@
+package request
+
+// GetMentionListRequest before和after是消息uuid，都不传时返回最新的一页
+type GetMentionListRequest struct {
+	Before   string `json:"before"`
+	After    string `json:"after"`
+	PageSize int    `json:"page_size"`
+}
@@ -0,0 +1,7 @@
+package request
+
+type GetMessageListAfterSeqRequest struct {
+	ConversationId string `json:"conversation_id"`
+	Seq            int64  `json:"seq"`
+	Limit          int    `json:"limit"`
+}
@@ -1,6 +1,10 @@
 package request
 
+// GetMessageListRequest before和after是消息uuid，都不传时返回最新的一页
 type GetMessageListRequest struct {
 	UserOneId string `json:"user_one_id"`
 	UserTwoId string `json:"user_two_id"`
+	Before    string `json:"before"`
+	After     string `json:"after"`
+	PageSize  int    `json:"page_size"`
 }
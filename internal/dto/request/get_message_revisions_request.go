@@ -0,0 +1,5 @@
+package request
+
+type GetMessageRevisionsRequest struct {
+	MessageUuid string `json:"message_uuid"`
+}
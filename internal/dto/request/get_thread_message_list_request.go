@@ -0,0 +1,9 @@
+package request
+
+// GetThreadMessageListRequest RootUuid是话题的根消息，before和after是话题中回复的uuid，都不传时返回最新的一页
+type GetThreadMessageListRequest struct {
+	RootUuid string `json:"root_uuid"`
+	Before   string `json:"before"`
+	After    string `json:"after"`
+	PageSize int    `json:"page_size"`
+}
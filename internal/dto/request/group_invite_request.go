@@ -0,0 +1,15 @@
+package request
+
+type GetGroupInviteListRequest struct {
+	GroupId string `json:"group_id"`
+}
+
+type GroupInviteTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// JoinGroupByInviteRequest Message是需要审核时的申请信息
+type JoinGroupByInviteRequest struct {
+	Token   string `json:"token"`
+	Message string `json:"message"`
+}
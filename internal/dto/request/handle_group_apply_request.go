@@ -0,0 +1,8 @@
+package request
+
+// HandleGroupApplyRequest 批量处理加群申请，UserIds是申请人
+type HandleGroupApplyRequest struct {
+	GroupId string   `json:"group_id"`
+	UserIds []string `json:"user_ids"`
+	Reason  string   `json:"reason"`
+}
@@ -0,0 +1,5 @@
+package request
+
+type ListenVoiceRequest struct {
+	MessageUuid string `json:"message_uuid"`
+}
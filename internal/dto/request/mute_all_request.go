@@ -0,0 +1,6 @@
+package request
+
+type MuteAllRequest struct {
+	GroupId string `json:"group_id"`
+	MuteAll bool   `json:"mute_all"`
+}
@@ -0,0 +1,8 @@
+package request
+
+// MuteGroupMemberRequest Duration是禁言秒数，为0时解除禁言
+type MuteGroupMemberRequest struct {
+	GroupId  string `json:"group_id"`
+	UserId   string `json:"user_id"`
+	Duration int    `json:"duration"`
+}
@@ -0,0 +1,7 @@
+package request
+
+type MuteSessionRequest struct {
+	SessionId string `json:"session_id"`
+	Muted     bool   `json:"muted"`
+	Duration  int    `json:"duration"` // 免打扰时长，单位分钟，0表示一直免打扰
+}
@@ -0,0 +1,6 @@
+package request
+
+type PinSessionRequest struct {
+	SessionId string `json:"session_id"`
+	Pinned    bool   `json:"pinned"`
+}
@@ -0,0 +1,6 @@
+package request
+
+type ReactMessageRequest struct {
+	MessageUuid string `json:"message_uuid"`
+	Emoji       string `json:"emoji"`
+}
@@ -0,0 +1,5 @@
+package request
+
+type RecallMessageRequest struct {
+	MessageUuid string `json:"message_uuid"`
+}
@@ -0,0 +1,5 @@
+package request
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
@@ -0,0 +1,6 @@
+package request
+
+// ReorderPinnedSessionsRequest SessionIds为置顶会话从上到下的顺序
+type ReorderPinnedSessionsRequest struct {
+	SessionIds []string `json:"session_ids"`
+}
@@ -0,0 +1,7 @@
+package request
+
+type ResetPasswordRequest struct {
+	Telephone   string `json:"telephone"`
+	SmsCode     string `json:"sms_code"`
+	NewPassword string `json:"new_password"`
+}
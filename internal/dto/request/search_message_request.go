@@ -0,0 +1,13 @@
+package request
+
+// SearchMessageRequest 时间格式为2006-01-02 15:04:05，Cursor为上一页返回的next_cursor
+type SearchMessageRequest struct {
+	Keyword   string `json:"keyword"`
+	ContactId string `json:"contact_id"` // 只搜索和某个用户或群聊的会话
+	SendId    string `json:"send_id"`
+	Type      *int8  `json:"type"` // 不传时搜索文本和文件消息
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	Cursor    int64  `json:"cursor"`
+	PageSize  int    `json:"page_size"`
+}
@@ -0,0 +1,7 @@
+package request
+
+type SetGroupAdminRequest struct {
+	GroupId string `json:"group_id"`
+	UserId  string `json:"user_id"`
+	IsAdmin bool   `json:"is_admin"`
+}
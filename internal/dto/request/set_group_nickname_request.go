@@ -0,0 +1,6 @@
+package request
+
+type SetGroupNicknameRequest struct {
+	GroupId  string `json:"group_id"`
+	Nickname string `json:"nickname"`
+}
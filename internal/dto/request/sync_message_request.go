@@ -0,0 +1,6 @@
+package request
+
+type SyncMessageRequest struct {
+	Cursor int64 `json:"cursor"` // 上次同步返回的next_cursor，为0时从上次离线开始
+	Limit  int   `json:"limit"`
+}
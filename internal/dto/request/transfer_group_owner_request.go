@@ -0,0 +1,6 @@
+package request
+
+type TransferGroupOwnerRequest struct {
+	GroupId    string `json:"group_id"`
+	NewOwnerId string `json:"new_owner_id"`
+}
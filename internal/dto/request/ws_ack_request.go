@@ -0,0 +1,8 @@
+package request
+
+// WsAckRequest 客户端确认收到消息，表示该会话中seq及之前的消息都已收到
+type WsAckRequest struct {
+	Event          string `json:"event"`
+	ConversationId string `json:"conversation_id"`
+	Seq            int64  `json:"seq"`
+}
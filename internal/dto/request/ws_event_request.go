@@ -0,0 +1,7 @@
+package request
+
+// WsEventRequest websocket上所有事件的公共部分，先按event区分再解析成具体的请求
+// 没有event字段的是聊天消息，按ChatMessageRequest解析
+type WsEventRequest struct {
+	Event string `json:"event"`
+}
@@ -0,0 +1,7 @@
+package request
+
+// WsPresenceRequest 客户端主动切换在线状态，只能是online或away，offline由断开连接触发
+type WsPresenceRequest struct {
+	Event  string `json:"event"`
+	Status string `json:"status"`
+}
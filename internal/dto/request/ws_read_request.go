@@ -0,0 +1,8 @@
+package request
+
+// WsReadRequest 客户端已读到会话中的某条消息，表示这条及之前的消息都已读
+type WsReadRequest struct {
+	Event          string `json:"event"`
+	ConversationId string `json:"conversation_id"`
+	MessageUuid    string `json:"message_uuid"`
+}
@@ -0,0 +1,7 @@
+package request
+
+// WsTypingRequest 正在输入或停止输入，receive_id是对方用户或群聊uuid
+type WsTypingRequest struct {
+	Event     string `json:"event"`
+	ReceiveId string `json:"receive_id"`
+}
@@ -5,4 +5,5 @@ type AddGroupListRespond struct {
 	ContactName   string `json:"contact_name"`
 	ContactAvatar string `json:"contact_avatar"`
 	Message       string `json:"message"`
+	ApplyAt       string `json:"apply_at"`
 }
@@ -0,0 +1,8 @@
+package respond
+
+// ChatHistoryContent 聊天记录卡片消息的content，被合并的消息通过/message/getChatHistory展开
+type ChatHistoryContent struct {
+	Title        string   `json:"title"`
+	MessageUuids []string `json:"message_uuids"`
+	Previews     []string `json:"previews"` // 卡片上展示的前几条，格式为"昵称: 预览"
+}
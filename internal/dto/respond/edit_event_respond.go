@@ -0,0 +1,12 @@
+package respond
+
+// EditEventRespond 编辑成功后推送给会话中所有在线用户
+type EditEventRespond struct {
+	Event          string `json:"event"`
+	MessageUuid    string `json:"message_uuid"`
+	ConversationId string `json:"conversation_id"`
+	SendId         string `json:"send_id"`
+	ReceiveId      string `json:"receive_id"`
+	Content        string `json:"content"`
+	EditedAt       string `json:"edited_at"`
+}
@@ -7,6 +7,7 @@ type GetGroupInfoRespond struct {
 	MemberCnt int    `json:"member_cnt"`
 	OwnerId   string `json:"owner_id"`
 	AddMode   int8   `json:"add_mode"`
+	MuteAll   bool   `json:"mute_all"`
 	Status    int8   `json:"status"`
 	Avatar    string `json:"avatar"`
 	IsDeleted bool   `json:"is_deleted"`
@@ -1,7 +1,11 @@
 package respond
 
 type GetGroupMemberListRespond struct {
-	UserId   string `json:"user_id"`
-	Nickname string `json:"nickname"`
-	Avatar   string `json:"avatar"`
+	UserId        string `json:"user_id"`
+	Nickname      string `json:"nickname"`
+	Avatar        string `json:"avatar"`
+	GroupNickname string `json:"group_nickname"`
+	Role          int8   `json:"role"`
+	JoinedAt      string `json:"joined_at"`
+	MutedUntil    string `json:"muted_until,omitempty"`
 }
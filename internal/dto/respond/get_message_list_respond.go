@@ -1,15 +1,28 @@
 package respond
 
 type GetMessageListRespond struct {
-	SendId     string `json:"send_id"`
-	SendName   string `json:"send_name"`
-	SendAvatar string `json:"send_avatar"`
-	ReceiveId  string `json:"receive_id"`
-	Type       int8   `json:"type"`
-	Content    string `json:"content"`
-	Url        string `json:"url"`
-	FileType   string `json:"file_type"`
-	FileName   string `json:"file_name"`
-	FileSize   string `json:"file_size"`
-	CreatedAt  string `json:"created_at"` // 先用CreatedAt排序，后面考虑改成SentAt
+	Uuid           string            `json:"uuid"`
+	ConversationId string            `json:"conversation_id"`
+	Seq            int64             `json:"seq"`
+	SendId         string            `json:"send_id"`
+	SendName       string            `json:"send_name"`
+	SendAvatar     string            `json:"send_avatar"`
+	ReceiveId      string            `json:"receive_id"`
+	Type           int8              `json:"type"`
+	Content        string            `json:"content"`
+	Url            string            `json:"url"`
+	FileType       string            `json:"file_type"`
+	FileName       string            `json:"file_name"`
+	FileSize       string            `json:"file_size"`
+	CreatedAt      string            `json:"created_at"` // 先用CreatedAt排序，后面考虑改成SentAt
+	Recalled       bool              `json:"recalled"`   // 撤回后不再返回原内容
+	EditedAt       string            `json:"edited_at"`  // 未编辑过为空
+	Quote          *QuoteRespond     `json:"quote,omitempty"`
+	ThreadId       string            `json:"thread_id"` // 群聊话题的根消息uuid，不在话题中为空
+	Mentions       []string          `json:"mentions,omitempty"`
+	MentionAll     bool              `json:"mention_all,omitempty"`
+	Duration       int               `json:"duration,omitempty"` // 语音时长，单位秒
+	Waveform       []int             `json:"waveform,omitempty"`
+	Listened       bool              `json:"listened"`            // 语音是否已听，自己发的单聊语音表示对方是否已听
+	Reactions      []ReactionRespond `json:"reactions,omitempty"` // 因人而异，不进聊天记录缓存
 }
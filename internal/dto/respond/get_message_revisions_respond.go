@@ -0,0 +1,7 @@
+package respond
+
+type GetMessageRevisionsRespond struct {
+	Content   string `json:"content"`
+	EditorId  string `json:"editor_id"`
+	CreatedAt string `json:"created_at"`
+}
@@ -0,0 +1,23 @@
+package respond
+
+// GroupApplyEventRespond 有新的加群申请时推送给群主和管理员
+type GroupApplyEventRespond struct {
+	Event    string `json:"event"`
+	GroupId  string `json:"group_id"`
+	UserId   string `json:"user_id"`
+	Nickname string `json:"nickname"`
+	Avatar   string `json:"avatar"`
+	Message  string `json:"message"`
+	ApplyAt  string `json:"apply_at"`
+}
+
+// GroupApplyResultEventRespond 加群申请处理后推送给申请人，同时推送给群主和管理员用于刷新待处理列表
+type GroupApplyResultEventRespond struct {
+	Event     string `json:"event"`
+	GroupId   string `json:"group_id"`
+	GroupName string `json:"group_name"`
+	UserId    string `json:"user_id"`
+	Approved  bool   `json:"approved"`
+	Reason    string `json:"reason"`
+	HandledBy string `json:"handled_by"`
+}
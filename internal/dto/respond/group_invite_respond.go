@@ -0,0 +1,31 @@
+package respond
+
+type GroupInviteRespond struct {
+	Token       string `json:"token"`
+	GroupId     string `json:"group_id"`
+	CreatorId   string `json:"creator_id"`
+	ExpireAt    string `json:"expire_at"`
+	MaxUses     int    `json:"max_uses"`
+	UsedCount   int    `json:"used_count"`
+	BypassAudit bool   `json:"bypass_audit"`
+	CreatedAt   string `json:"created_at"`
+	// QrPayload 客户端直接生成二维码的内容
+	QrPayload string `json:"qr_payload"`
+}
+
+// InvitePreviewRespond 未登录也能通过邀请token看到的群聊信息
+type InvitePreviewRespond struct {
+	GroupId   string `json:"group_id"`
+	Name      string `json:"name"`
+	Avatar    string `json:"avatar"`
+	Notice    string `json:"notice"`
+	MemberCnt int    `json:"member_cnt"`
+	NeedAudit bool   `json:"need_audit"`
+	ExpireAt  string `json:"expire_at"`
+}
+
+// JoinGroupByInviteRespond Joined为true表示已经入群，否则已提交申请等待审核
+type JoinGroupByInviteRespond struct {
+	GroupId string `json:"group_id"`
+	Joined  bool   `json:"joined"`
+}
@@ -0,0 +1,8 @@
+package respond
+
+// GroupReadCountRespond 群聊消息的已读人数，MemberCount不包括发送者
+type GroupReadCountRespond struct {
+	MessageUuid string `json:"message_uuid"`
+	ReadCount   int64  `json:"read_count"`
+	MemberCount int64  `json:"member_count"`
+}
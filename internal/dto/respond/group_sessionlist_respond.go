@@ -1,8 +1,16 @@
 package respond
 
 type GroupSessionListRespond struct {
-	SessionId string `json:"session_id"`
-	GroupName string `json:"group_name"`
-	GroupId   string `json:"group_id"`
-	Avatar    string `json:"avatar"`
+	SessionId     string `json:"session_id"`
+	GroupName     string `json:"group_name"`
+	GroupId       string `json:"group_id"`
+	Avatar        string `json:"avatar"`
+	UnreadCount   int64  `json:"unread_count"`
+	Mentioned     bool   `json:"mentioned"` // 有未读的消息@了自己
+	LastMessage   string `json:"last_message"`
+	LastMessageAt string `json:"last_message_at"`
+	Pinned        bool   `json:"pinned"`
+	Muted         bool   `json:"muted"`
+	MutedUntil    string `json:"muted_until"` // 为空表示一直免打扰
+	Archived      bool   `json:"archived"`
 }
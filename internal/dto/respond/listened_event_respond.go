@@ -0,0 +1,10 @@
+package respond
+
+// ListenedEventRespond 接收者第一次收听语音时推送给发送者
+type ListenedEventRespond struct {
+	Event          string `json:"event"`
+	MessageUuid    string `json:"message_uuid"`
+	ConversationId string `json:"conversation_id"`
+	SendId         string `json:"send_id"`
+	ListenerId     string `json:"listener_id"`
+}
@@ -0,0 +1,12 @@
+package respond
+
+// MentionEventRespond 只推送给被@的成员，用于提醒
+type MentionEventRespond struct {
+	Event       string `json:"event"`
+	GroupId     string `json:"group_id"`
+	MessageUuid string `json:"message_uuid"`
+	SendId      string `json:"send_id"`
+	SendName    string `json:"send_name"`
+	Preview     string `json:"preview"`
+	MentionAll  bool   `json:"mention_all"`
+}
@@ -0,0 +1,20 @@
+package respond
+
+// MessagePageRespond 一页单聊记录，从新到旧
+type MessagePageRespond struct {
+	Messages []GetMessageListRespond `json:"messages"`
+	HasMore  bool                    `json:"has_more"`
+}
+
+// GroupMessagePageRespond 一页群聊记录，从新到旧
+type GroupMessagePageRespond struct {
+	Messages []GetGroupMessageListRespond `json:"messages"`
+	HasMore  bool                         `json:"has_more"`
+}
+
+// ThreadMessagePageRespond 话题的根消息和一页回复，回复从新到旧
+type ThreadMessagePageRespond struct {
+	Root     GetGroupMessageListRespond   `json:"root"`
+	Messages []GetGroupMessageListRespond `json:"messages"`
+	HasMore  bool                         `json:"has_more"`
+}
@@ -0,0 +1,11 @@
+package respond
+
+// OwnerTransferEventRespond 群主转让后推送给新群主，Auto为true表示原群主退群时自动转让
+type OwnerTransferEventRespond struct {
+	Event      string `json:"event"`
+	GroupId    string `json:"group_id"`
+	GroupName  string `json:"group_name"`
+	OldOwnerId string `json:"old_owner_id"`
+	NewOwnerId string `json:"new_owner_id"`
+	Auto       bool   `json:"auto"`
+}
@@ -0,0 +1,17 @@
+package respond
+
+// PendingSessionRespond 一个会话中还没有送达的消息概况
+type PendingSessionRespond struct {
+	SessionId      string `json:"session_id"`
+	ConversationId string `json:"conversation_id"`
+	ContactId      string `json:"contact_id"` // 对方用户或群聊uuid
+	PendingCount   int64  `json:"pending_count"`
+	LastSeq        int64  `json:"last_seq"`
+	Muted          bool   `json:"muted"` // 免打扰的会话客户端只同步消息，不弹出提醒
+}
+
+// PendingSummaryRespond 登录时通过websocket推送的未送达消息概况
+type PendingSummaryRespond struct {
+	Event    string                  `json:"event"`
+	Sessions []PendingSessionRespond `json:"sessions"`
+}
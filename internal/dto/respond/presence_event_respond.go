@@ -0,0 +1,9 @@
+package respond
+
+// PresenceEventRespond 广播给联系人的在线状态变化
+type PresenceEventRespond struct {
+	Event    string `json:"event"`
+	UserId   string `json:"user_id"`
+	Status   string `json:"status"`
+	ChangeAt string `json:"change_at"`
+}
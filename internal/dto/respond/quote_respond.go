@@ -0,0 +1,9 @@
+package respond
+
+// QuoteRespond 被引用消息的摘要，引用时生成，之后原消息的变化不会同步过来
+type QuoteRespond struct {
+	Uuid     string `json:"uuid"`
+	SendId   string `json:"send_id"`
+	SendName string `json:"send_name"`
+	Snippet  string `json:"snippet"`
+}
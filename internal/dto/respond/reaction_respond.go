@@ -0,0 +1,20 @@
+package respond
+
+// ReactionRespond 一条消息上某个表情的汇总
+type ReactionRespond struct {
+	Emoji   string `json:"emoji"`
+	Count   int64  `json:"count"`
+	Reacted bool   `json:"reacted"` // 当前用户是否回应过
+}
+
+// ReactionEventRespond 表情回应变化时推送给会话中所有在线用户，客户端自行增减计数
+type ReactionEventRespond struct {
+	Event          string `json:"event"`
+	MessageUuid    string `json:"message_uuid"`
+	ConversationId string `json:"conversation_id"`
+	SendId         string `json:"send_id"`
+	ReceiveId      string `json:"receive_id"`
+	UserId         string `json:"user_id"`
+	Emoji          string `json:"emoji"`
+	Removed        bool   `json:"removed"`
+}
@@ -0,0 +1,10 @@
+package respond
+
+// ReadEventRespond 单聊中对方已读的通知
+type ReadEventRespond struct {
+	Event          string `json:"event"`
+	ConversationId string `json:"conversation_id"`
+	ReaderId       string `json:"reader_id"`
+	MessageUuid    string `json:"message_uuid"`
+	Seq            int64  `json:"seq"`
+}
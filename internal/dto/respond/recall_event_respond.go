@@ -0,0 +1,12 @@
+package respond
+
+// RecallEventRespond 撤回成功后推送给会话中所有在线用户
+type RecallEventRespond struct {
+	Event          string `json:"event"`
+	MessageUuid    string `json:"message_uuid"`
+	ConversationId string `json:"conversation_id"`
+	SendId         string `json:"send_id"`
+	ReceiveId      string `json:"receive_id"`
+	OperatorId     string `json:"operator_id"`
+	RecalledAt     string `json:"recalled_at"`
+}
@@ -0,0 +1,6 @@
+package respond
+
+type RefreshTokenRespond struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
@@ -0,0 +1,12 @@
+package respond
+
+type SearchHitRespond struct {
+	Message   GetMessageListRespond `json:"message"`
+	Highlight string                `json:"highlight"` // 命中的片段，关键词用<em>包裹，已做html转义
+}
+
+type SearchMessageRespond struct {
+	Hits       []SearchHitRespond `json:"hits"`
+	NextCursor int64              `json:"next_cursor"`
+	HasMore    bool               `json:"has_more"`
+}
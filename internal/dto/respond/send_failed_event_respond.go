@@ -0,0 +1,9 @@
+package respond
+
+// SendFailedEventRespond 消息没有发出时推送给发送者
+type SendFailedEventRespond struct {
+	Event      string `json:"event"`
+	ReceiveId  string `json:"receive_id"`
+	Reason     string `json:"reason"`
+	MutedUntil string `json:"muted_until,omitempty"`
+}
@@ -0,0 +1,9 @@
+package respond
+
+// SessionUpdateRespond 会话有新消息时推送，客户端据此更新会话列表的预览和顺序
+type SessionUpdateRespond struct {
+	Event         string `json:"event"`
+	ContactId     string `json:"contact_id"` // 会话对象，对方用户或群聊uuid
+	LastMessage   string `json:"last_message"`
+	LastMessageAt string `json:"last_message_at"`
+}
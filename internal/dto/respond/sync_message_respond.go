@@ -0,0 +1,7 @@
+package respond
+
+type SyncMessageRespond struct {
+	Messages   []GetMessageListRespond `json:"messages"`
+	NextCursor int64                   `json:"next_cursor"`
+	HasMore    bool                    `json:"has_more"`
+}
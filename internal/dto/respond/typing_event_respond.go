@@ -0,0 +1,8 @@
+package respond
+
+// TypingEventRespond 转发给对方或群成员的输入状态
+type TypingEventRespond struct {
+	Event     string `json:"event"`
+	SendId    string `json:"send_id"`
+	ReceiveId string `json:"receive_id"`
+}
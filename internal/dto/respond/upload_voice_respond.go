@@ -0,0 +1,10 @@
+package respond
+
+// UploadVoiceRespond 上传语音后返回，发送语音消息时只需要带上url，时长和波形以服务端保存的为准
+type UploadVoiceRespond struct {
+	Url      string `json:"url"`
+	FileSize string `json:"file_size"`
+	FileType string `json:"file_type"`
+	Duration int    `json:"duration"`
+	Waveform []int  `json:"waveform"`
+}
@@ -1,8 +1,15 @@
 package respond
 
 type UserSessionListRespond struct {
-	SessionId string `json:"session_id"`
-	Avatar    string `json:"avatar"`
-	UserId    string `json:"user_id"`
-	Username  string `json:"user_name"`
+	SessionId     string `json:"session_id"`
+	Avatar        string `json:"avatar"`
+	UserId        string `json:"user_id"`
+	Username      string `json:"user_name"`
+	UnreadCount   int64  `json:"unread_count"`
+	LastMessage   string `json:"last_message"`
+	LastMessageAt string `json:"last_message_at"`
+	Pinned        bool   `json:"pinned"`
+	Muted         bool   `json:"muted"`
+	MutedUntil    string `json:"muted_until"` // 为空表示一直免打扰
+	Archived      bool   `json:"archived"`
 }
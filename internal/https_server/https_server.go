@@ -5,6 +5,7 @@ import (
 	"github.com/gin-gonic/gin"
 	v1 "kama_chat_server/api/v1"
 	"kama_chat_server/internal/config"
+	"kama_chat_server/internal/middleware"
 	"kama_chat_server/pkg/ssl"
 )
 
@@ -20,53 +21,93 @@ func init() {
 	GE.Use(ssl.TlsHandler(config.GetConfig().MainConfig.Host, config.GetConfig().MainConfig.Port))
 	GE.Static("/static/avatars", config.GetConfig().StaticAvatarPath)
 	GE.Static("/static/files", config.GetConfig().StaticFilePath)
+	GE.Static("/static/voices", config.GetConfig().StaticVoicePath)
 	GE.POST("/login", v1.Login)
 	GE.POST("/register", v1.Register)
-	GE.POST("/user/updateUserInfo", v1.UpdateUserInfo)
-	GE.POST("/user/getUserInfoList", v1.GetUserInfoList)
-	GE.POST("/user/ableUsers", v1.AbleUsers)
-	GE.POST("/user/getUserInfo", v1.GetUserInfo)
-	GE.POST("/user/disableUsers", v1.DisableUsers)
-	GE.POST("/user/deleteUsers", v1.DeleteUsers)
-	GE.POST("/user/setAdmin", v1.SetAdmin)
 	GE.POST("/user/sendSmsCode", v1.SendSmsCode)
 	GE.POST("/user/smsLogin", v1.SmsLogin)
-	GE.POST("/user/wsLogout", v1.WsLogout)
-	GE.POST("/group/createGroup", v1.CreateGroup)
-	GE.POST("/group/loadMyGroup", v1.LoadMyGroup)
-	GE.POST("/group/checkGroupAddMode", v1.CheckGroupAddMode)
-	GE.POST("/group/enterGroupDirectly", v1.EnterGroupDirectly)
-	GE.POST("/group/leaveGroup", v1.LeaveGroup)
-	GE.POST("/group/dismissGroup", v1.DismissGroup)
-	GE.POST("/group/getGroupInfo", v1.GetGroupInfo)
-	GE.POST("/group/getGroupInfoList", v1.GetGroupInfoList)
-	GE.POST("/group/deleteGroups", v1.DeleteGroups)
-	GE.POST("/group/setGroupsStatus", v1.SetGroupsStatus)
-	GE.POST("/group/updateGroupInfo", v1.UpdateGroupInfo)
-	GE.POST("/group/getGroupMemberList", v1.GetGroupMemberList)
-	GE.POST("/group/removeGroupMembers", v1.RemoveGroupMembers)
-	GE.POST("/session/openSession", v1.OpenSession)
-	GE.POST("/session/getUserSessionList", v1.GetUserSessionList)
-	GE.POST("/session/getGroupSessionList", v1.GetGroupSessionList)
-	GE.POST("/session/deleteSession", v1.DeleteSession)
-	GE.POST("/session/checkOpenSessionAllowed", v1.CheckOpenSessionAllowed)
-	GE.POST("/contact/getUserList", v1.GetUserList)
-	GE.POST("/contact/loadMyJoinedGroup", v1.LoadMyJoinedGroup)
-	GE.POST("/contact/getContactInfo", v1.GetContactInfo)
-	GE.POST("/contact/deleteContact", v1.DeleteContact)
-	GE.POST("/contact/applyContact", v1.ApplyContact)
-	GE.POST("/contact/getNewContactList", v1.GetNewContactList)
-	GE.POST("/contact/passContactApply", v1.PassContactApply)
-	GE.POST("/contact/blackContact", v1.BlackContact)
-	GE.POST("/contact/cancelBlackContact", v1.CancelBlackContact)
-	GE.POST("/contact/getAddGroupList", v1.GetAddGroupList)
-	GE.POST("/contact/refuseContactApply", v1.RefuseContactApply)
-	GE.POST("/contact/blackApply", v1.BlackApply)
-	GE.POST("/message/getMessageList", v1.GetMessageList)
-	GE.POST("/message/getGroupMessageList", v1.GetGroupMessageList)
-	GE.POST("/message/uploadAvatar", v1.UploadAvatar)
-	GE.POST("/message/uploadFile", v1.UploadFile)
-	GE.POST("/chatroom/getCurContactListInChatRoom", v1.GetCurContactListInChatRoom)
-	GE.GET("/wss", v1.WsLogin)
+	GE.POST("/user/refreshToken", v1.RefreshToken)
+	GE.POST("/user/resetPassword", v1.ResetPassword)
+	GE.POST("/invite/previewGroup", v1.PreviewGroupInvite)
 
+	// 以下接口都需要登录，用户身份从token中获取
+	auth := GE.Group("/", middleware.JwtAuth())
+	auth.POST("/user/updateUserInfo", v1.UpdateUserInfo)
+	auth.POST("/user/changePassword", v1.ChangePassword)
+	auth.POST("/user/getUserInfo", v1.GetUserInfo)
+	auth.POST("/user/wsLogout", v1.WsLogout)
+	auth.POST("/group/createGroup", v1.CreateGroup)
+	auth.POST("/group/loadMyGroup", v1.LoadMyGroup)
+	auth.POST("/group/checkGroupAddMode", v1.CheckGroupAddMode)
+	auth.POST("/group/enterGroupDirectly", v1.EnterGroupDirectly)
+	auth.POST("/group/leaveGroup", v1.LeaveGroup)
+	auth.POST("/group/dismissGroup", v1.DismissGroup)
+	auth.POST("/group/transferOwner", v1.TransferGroupOwner)
+	auth.POST("/group/getGroupInfo", v1.GetGroupInfo)
+	auth.POST("/group/updateGroupInfo", v1.UpdateGroupInfo)
+	auth.POST("/group/getGroupMemberList", v1.GetGroupMemberList)
+	auth.POST("/group/removeGroupMembers", v1.RemoveGroupMembers)
+	auth.POST("/group/setGroupNickname", v1.SetGroupNickname)
+	auth.POST("/group/setGroupAdmin", v1.SetGroupAdmin)
+	auth.POST("/group/muteGroupMember", v1.MuteGroupMember)
+	auth.POST("/group/muteAll", v1.MuteAll)
+	auth.POST("/group/passGroupApplies", v1.PassGroupApplies)
+	auth.POST("/group/refuseGroupApplies", v1.RefuseGroupApplies)
+	auth.POST("/group/createInvite", v1.CreateGroupInvite)
+	auth.POST("/group/getInviteList", v1.GetGroupInviteList)
+	auth.POST("/group/revokeInvite", v1.RevokeGroupInvite)
+	auth.POST("/invite/joinGroup", v1.JoinGroupByInvite)
+	auth.POST("/session/openSession", v1.OpenSession)
+	auth.POST("/session/getUserSessionList", v1.GetUserSessionList)
+	auth.POST("/session/getGroupSessionList", v1.GetGroupSessionList)
+	auth.POST("/session/deleteSession", v1.DeleteSession)
+	auth.POST("/session/pinSession", v1.PinSession)
+	auth.POST("/session/reorderPinnedSessions", v1.ReorderPinnedSessions)
+	auth.POST("/session/muteSession", v1.MuteSession)
+	auth.POST("/session/archiveSession", v1.ArchiveSession)
+	auth.POST("/session/checkOpenSessionAllowed", v1.CheckOpenSessionAllowed)
+	auth.POST("/contact/getUserList", v1.GetUserList)
+	auth.POST("/contact/loadMyJoinedGroup", v1.LoadMyJoinedGroup)
+	auth.POST("/contact/getContactInfo", v1.GetContactInfo)
+	auth.POST("/contact/deleteContact", v1.DeleteContact)
+	auth.POST("/contact/applyContact", v1.ApplyContact)
+	auth.POST("/contact/getNewContactList", v1.GetNewContactList)
+	auth.POST("/contact/passContactApply", v1.PassContactApply)
+	auth.POST("/contact/blackContact", v1.BlackContact)
+	auth.POST("/contact/cancelBlackContact", v1.CancelBlackContact)
+	auth.POST("/contact/getAddGroupList", v1.GetAddGroupList)
+	auth.POST("/contact/refuseContactApply", v1.RefuseContactApply)
+	auth.POST("/contact/blackApply", v1.BlackApply)
+	auth.POST("/message/getMessageList", v1.GetMessageList)
+	auth.POST("/message/getGroupMessageList", v1.GetGroupMessageList)
+	auth.POST("/message/getThreadMessageList", v1.GetThreadMessageList)
+	auth.POST("/message/getMentionList", v1.GetMentionList)
+	auth.POST("/message/searchMessages", v1.SearchMessages)
+	auth.POST("/message/getMessageListAfterSeq", v1.GetMessageListAfterSeq)
+	auth.POST("/message/sync", v1.SyncMessages)
+	auth.POST("/message/getGroupReadCounts", v1.GetGroupReadCounts)
+	auth.POST("/message/recallMessage", v1.RecallMessage)
+	auth.POST("/message/editMessage", v1.EditMessage)
+	auth.POST("/message/getMessageRevisions", v1.GetMessageRevisions)
+	auth.POST("/message/addReaction", v1.AddReaction)
+	auth.POST("/message/removeReaction", v1.RemoveReaction)
+	auth.POST("/message/forwardMessage", v1.ForwardMessage)
+	auth.POST("/message/getChatHistory", v1.GetChatHistory)
+	auth.POST("/message/uploadAvatar", v1.UploadAvatar)
+	auth.POST("/message/uploadFile", v1.UploadFile)
+	auth.POST("/message/uploadVoice", v1.UploadVoice)
+	auth.POST("/message/listenVoice", v1.ListenVoice)
+	auth.POST("/chatroom/getCurContactListInChatRoom", v1.GetCurContactListInChatRoom)
+	auth.GET("/wss", v1.WsLogin)
+
+	// 管理员接口
+	admin := auth.Group("/", middleware.AdminAuth())
+	admin.POST("/user/getUserInfoList", v1.GetUserInfoList)
+	admin.POST("/user/ableUsers", v1.AbleUsers)
+	admin.POST("/user/disableUsers", v1.DisableUsers)
+	admin.POST("/user/deleteUsers", v1.DeleteUsers)
+	admin.POST("/user/setAdmin", v1.SetAdmin)
+	admin.POST("/group/getGroupInfoList", v1.GetGroupInfoList)
+	admin.POST("/group/deleteGroups", v1.DeleteGroups)
+	admin.POST("/group/setGroupsStatus", v1.SetGroupsStatus)
 }
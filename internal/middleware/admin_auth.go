@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"kama_chat_server/internal/service/gorm"
+	"net/http"
+)
+
+// AdminAuth 只允许管理员访问，必须放在JwtAuth之后
+// 每次都查库而不是把is_admin写进token，这样撤销管理员能立即生效
+func AdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		message, ret := gorm.UserInfoService.CheckUserIsAdmin(GetUuid(c))
+		if ret == -1 {
+			c.AbortWithStatusJSON(http.StatusOK, gin.H{
+				"code":    500,
+				"message": message,
+			})
+			return
+		}
+		if ret != 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"code":    403,
+				"message": message,
+			})
+			return
+		}
+		c.Next()
+	}
+}
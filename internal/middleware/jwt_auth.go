@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	myjwt "kama_chat_server/internal/service/jwt"
+	"kama_chat_server/pkg/zlog"
+	"net/http"
+	"strings"
+)
+
+const uuidKey = "uuid" // gin上下文中保存登录用户uuid的key
+
+// JwtAuth 校验access token，并把登录用户的uuid放入上下文
+// 普通请求从Authorization: Bearer <token>中获取，浏览器的websocket无法自定义请求头，所以也支持?token=<token>
+func JwtAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := ""
+		authHeader := c.GetHeader("Authorization")
+		if strings.HasPrefix(authHeader, "Bearer ") {
+			tokenString = strings.TrimPrefix(authHeader, "Bearer ")
+		} else {
+			tokenString = c.Query("token")
+		}
+		if tokenString == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"code":    401,
+				"message": "未登录，请先登录",
+			})
+			return
+		}
+		claims, err := myjwt.ParseToken(tokenString, myjwt.ACCESS_TOKEN)
+		if err != nil {
+			zlog.Info(err.Error())
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"code":    401,
+				"message": "登录已失效，请重新登录",
+			})
+			return
+		}
+		c.Set(uuidKey, claims.Uuid)
+		c.Next()
+	}
+}
+
+// GetUuid 获取当前登录用户的uuid，只能在JwtAuth之后调用
+func GetUuid(c *gin.Context) string {
+	return c.GetString(uuidKey)
+}
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// DataMigration 已经执行过的数据迁移，每个迁移只执行一次
+type DataMigration struct {
+	Name      string    `gorm:"column:name;primaryKey;type:varchar(64);comment:迁移名称"`
+	CreatedAt time.Time `gorm:"column:created_at;not null;comment:执行完成时间"`
+}
+
+func (DataMigration) TableName() string {
+	return "data_migration"
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// GroupInvite 群聊邀请链接，持有token的用户可以预览群聊并加入
+type GroupInvite struct {
+	Id          int64        `gorm:"column:id;primaryKey;comment:自增id"`
+	Token       string       `gorm:"column:token;uniqueIndex;type:varchar(32);not null;comment:邀请token"`
+	GroupId     string       `gorm:"column:group_id;index;type:char(20);not null;comment:群聊uuid"`
+	CreatorId   string       `gorm:"column:creator_id;type:char(20);not null;comment:创建者uuid"`
+	ExpireAt    sql.NullTime `gorm:"column:expire_at;type:datetime;comment:过期时间，为空表示不过期"`
+	MaxUses     int          `gorm:"column:max_uses;default:0;comment:最多使用次数，0表示不限"`
+	UsedCount   int          `gorm:"column:used_count;default:0;comment:已使用次数"`
+	BypassAudit bool         `gorm:"column:bypass_audit;default:false;comment:通过邀请加入需要审核的群聊时不用审核"`
+	RevokedAt   sql.NullTime `gorm:"column:revoked_at;type:datetime;comment:撤销时间"`
+	CreatedAt   time.Time    `gorm:"column:created_at;type:datetime;not null;comment:创建时间"`
+}
+
+func (GroupInvite) TableName() string {
+	return "group_invite"
+}
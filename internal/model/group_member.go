@@ -0,0 +1,24 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// GroupMember 群成员，一个成员一行，退群或被移出时删除
+type GroupMember struct {
+	Id        int64     `gorm:"column:id;primaryKey;comment:自增id"`
+	GroupId   string    `gorm:"column:group_id;uniqueIndex:idx_group_user,priority:1;type:char(20);not null;comment:群聊uuid"`
+	UserId    string    `gorm:"column:user_id;uniqueIndex:idx_group_user,priority:2;index;type:char(20);not null;comment:成员uuid"`
+	Role      int8      `gorm:"column:role;default:0;comment:角色，0.成员，1.管理员，2.群主"`
+	Nickname  string    `gorm:"column:nickname;type:varchar(20);comment:群昵称，为空时显示用户昵称"`
+	InviterId string    `gorm:"column:inviter_id;type:char(20);comment:邀请或批准入群的用户uuid，主动进群时为空"`
+	JoinedAt  time.Time `gorm:"column:joined_at;type:datetime;not null;comment:入群时间"`
+	// MutedUntil 禁言以此为准，到期后自动失效，不需要再修改
+	MutedUntil sql.NullTime `gorm:"column:muted_until;type:datetime;comment:禁言到期时间，为空表示没有禁言"`
+	UpdatedAt  time.Time    `gorm:"column:updated_at;type:datetime;not null;comment:更新时间"`
+}
+
+func (GroupMember) TableName() string {
+	return "group_member"
+}
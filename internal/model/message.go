@@ -2,27 +2,42 @@ package model
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 )
 
 type Message struct {
-	Id         int64     `gorm:"column:id;primaryKey;comment:自增id"`
-	Uuid       string    `gorm:"column:uuid;uniqueIndex;type:char(20);not null;comment:消息uuid"`
-	SessionId  string    `gorm:"column:session_id;index;type:char(20);not null;comment:会话uuid"`
-	Type       int8      `gorm:"column:type;not null;comment:消息类型，0.文本，1.语音，2.文件，3.通话"` // 通话不用存消息内容或者url
-	Content    string    `gorm:"column:content;type:TEXT;comment:消息内容"`
-	Url        string    `gorm:"column:url;type:char(255);comment:消息url"`
-	SendId     string    `gorm:"column:send_id;index;type:char(20);not null;comment:发送者uuid"`
-	SendName   string    `gorm:"column:send_name;type:varchar(20);not null;comment:发送者昵称"`
-	SendAvatar string    `gorm:"column:send_avatar;type:varchar(255);not null;comment:发送者头像"`
-	ReceiveId  string    `gorm:"column:receive_id;index;type:char(20);not null;comment:接受者uuid"`
-	FileType   string    `gorm:"column:file_type;type:char(10);comment:文件类型"`
-	FileName   string    `gorm:"column:file_name;type:varchar(50);comment:文件名"`
-	FileSize   string    `gorm:"column:file_size;type:char(20);comment:文件大小"`
-	Status     int8      `gorm:"column:status;not null;comment:状态，0.未发送，1.已发送"`
-	CreatedAt  time.Time `gorm:"column:created_at;not null;comment:创建时间"`
-	SendAt     sql.NullTime `gorm:"column:send_at;comment:发送时间"`
-	AVdata     string    `gorm:"column:av_data;comment:通话传递数据"`
+	Id             int64           `gorm:"column:id;primaryKey;comment:自增id"`
+	Uuid           string          `gorm:"column:uuid;uniqueIndex;type:char(20);not null;comment:消息uuid"`
+	SessionId      string          `gorm:"column:session_id;index;type:char(20);not null;comment:会话uuid"`
+	Type           int8            `gorm:"column:type;not null;comment:消息类型，0.文本，1.语音，2.文件，3.通话"` // 通话不用存消息内容或者url
+	Content        string          `gorm:"column:content;type:TEXT;comment:消息内容"`
+	Url            string          `gorm:"column:url;type:char(255);comment:消息url"`
+	SendId         string          `gorm:"column:send_id;index;type:char(20);not null;comment:发送者uuid"`
+	SendName       string          `gorm:"column:send_name;type:varchar(20);not null;comment:发送者昵称"`
+	SendAvatar     string          `gorm:"column:send_avatar;type:varchar(255);not null;comment:发送者头像"`
+	ReceiveId      string          `gorm:"column:receive_id;index;type:char(20);not null;comment:接受者uuid"`
+	FileType       string          `gorm:"column:file_type;type:char(10);comment:文件类型"`
+	FileName       string          `gorm:"column:file_name;type:varchar(50);comment:文件名"`
+	FileSize       string          `gorm:"column:file_size;type:char(20);comment:文件大小"`
+	Status         int8            `gorm:"column:status;not null;comment:状态，0.未发送，1.已发送，2.已送达，3.已读"`
+	CreatedAt      time.Time       `gorm:"column:created_at;not null;comment:创建时间"`
+	SendAt         sql.NullTime    `gorm:"column:send_at;comment:发送时间"`
+	AVdata         string          `gorm:"column:av_data;comment:通话传递数据"`
+	ConversationId string          `gorm:"column:conversation_id;index:idx_conversation_seq,priority:1;type:varchar(50);not null;default:'';comment:会话标识，单聊为双方uuid排序后拼接，群聊为群聊uuid"`
+	Seq            int64           `gorm:"column:seq;index:idx_conversation_seq,priority:2;not null;default:0;comment:会话内递增序号"`
+	RecalledAt     sql.NullTime    `gorm:"column:recalled_at;comment:撤回时间，原内容保留用于审计"`
+	RecalledBy     string          `gorm:"column:recalled_by;type:char(20);not null;default:'';comment:撤回操作人uuid"`
+	EditedAt       sql.NullTime    `gorm:"column:edited_at;comment:最后编辑时间，历史版本在message_revision表"`
+	ReplyTo        string          `gorm:"column:reply_to;type:char(20);not null;default:'';comment:引用的消息uuid"`
+	ReplySendId    string          `gorm:"column:reply_send_id;type:char(20);not null;default:'';comment:引用消息的发送者uuid"`
+	ReplySendName  string          `gorm:"column:reply_send_name;type:varchar(20);not null;default:'';comment:引用消息的发送者昵称"`
+	ReplySnippet   string          `gorm:"column:reply_snippet;type:varchar(255);not null;default:'';comment:引用消息的内容摘要，引用时的快照"`
+	Mentions       json.RawMessage `gorm:"column:mentions;type:json;comment:@的成员uuid列表"`
+	MentionAll     bool            `gorm:"column:mention_all;not null;default:false;comment:是否@所有人"`
+	Duration       int             `gorm:"column:duration;not null;default:0;comment:语音时长，单位秒"`
+	Waveform       json.RawMessage `gorm:"column:waveform;type:json;comment:语音波形，用于客户端绘制"`
+	ThreadId       string          `gorm:"column:thread_id;index;type:char(20);not null;default:'';comment:所属话题的根消息uuid"`
 }
 
 func (Message) TableName() string {
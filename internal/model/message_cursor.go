@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// MessageCursor 用户在某个会话中的消息进度
+type MessageCursor struct {
+	Id             int64     `gorm:"column:id;primaryKey;comment:自增id"`
+	UserId         string    `gorm:"column:user_id;uniqueIndex:idx_user_conversation,priority:1;type:char(20);not null;comment:用户uuid"`
+	ConversationId string    `gorm:"column:conversation_id;uniqueIndex:idx_user_conversation,priority:2;type:varchar(50);not null;comment:会话标识"`
+	AckSeq         int64     `gorm:"column:ack_seq;not null;default:0;comment:已确认收到的最大序号"`
+	ReadSeq        int64     `gorm:"column:read_seq;not null;default:0;comment:已读的最大序号"`
+	UpdatedAt      time.Time `gorm:"column:updated_at;type:datetime;not null;comment:更新时间"`
+}
+
+func (MessageCursor) TableName() string {
+	return "message_cursor"
+}
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// MessageMention 群聊消息中@到的用户，@所有人时给每个成员各记一条
+type MessageMention struct {
+	Id          int64     `gorm:"column:id;primaryKey;comment:自增id"`
+	MessageUuid string    `gorm:"column:message_uuid;index;type:char(20);not null;comment:消息uuid"`
+	UserId      string    `gorm:"column:user_id;index:idx_user_group_seq,priority:1;type:char(20);not null;comment:被@的用户uuid"`
+	GroupId     string    `gorm:"column:group_id;index:idx_user_group_seq,priority:2;type:char(20);not null;comment:群聊uuid"`
+	Seq         int64     `gorm:"column:seq;index:idx_user_group_seq,priority:3;not null;comment:消息在群聊中的序号，和已读进度比较判断是否已读"`
+	CreatedAt   time.Time `gorm:"column:created_at;not null;comment:创建时间"`
+}
+
+func (MessageMention) TableName() string {
+	return "message_mention"
+}
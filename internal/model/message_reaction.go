@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// MessageReaction 用户对消息的表情回应，同一用户对同一消息的同一表情只能有一条
+type MessageReaction struct {
+	Id             int64     `gorm:"column:id;primaryKey;comment:自增id"`
+	MessageUuid    string    `gorm:"column:message_uuid;uniqueIndex:idx_message_user_emoji,priority:1;type:char(20);not null;comment:消息uuid"`
+	UserId         string    `gorm:"column:user_id;uniqueIndex:idx_message_user_emoji,priority:2;type:char(20);not null;comment:回应用户uuid"`
+	Emoji          string    `gorm:"column:emoji;uniqueIndex:idx_message_user_emoji,priority:3;type:varchar(16);not null;comment:表情"`
+	ConversationId string    `gorm:"column:conversation_id;type:varchar(50);not null;comment:会话标识"`
+	CreatedAt      time.Time `gorm:"column:created_at;not null;comment:创建时间"`
+}
+
+func (MessageReaction) TableName() string {
+	return "message_reaction"
+}
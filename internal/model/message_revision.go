@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// MessageRevision 消息被编辑前的内容，每编辑一次记录一条
+type MessageRevision struct {
+	Id          int64     `gorm:"column:id;primaryKey;comment:自增id"`
+	MessageUuid string    `gorm:"column:message_uuid;index;type:char(20);not null;comment:消息uuid"`
+	Content     string    `gorm:"column:content;type:TEXT;comment:编辑前的消息内容"`
+	EditorId    string    `gorm:"column:editor_id;type:char(20);not null;comment:编辑人uuid"`
+	CreatedAt   time.Time `gorm:"column:created_at;not null;comment:编辑时间"`
+}
+
+func (MessageRevision) TableName() string {
+	return "message_revision"
+}
@@ -14,7 +14,11 @@ type Session struct {
 	ReceiveName   string         `gorm:"column:receive_name;type:varchar(20);not null;comment:名称"`
 	Avatar        string         `gorm:"column:avatar;type:char(255);default:default_avatar.png;not null;comment:头像"`
 	LastMessage   string         `gorm:"column:last_message;type:TEXT;comment:最新的消息"`
-	LastMessageAt sql.NullTime      `gorm:"column:last_message_at;type:datetime;comment:最近接收时间"`
+	LastMessageAt sql.NullTime   `gorm:"column:last_message_at;type:datetime;comment:最近接收时间"`
+	PinOrder      int64          `gorm:"column:pin_order;not null;default:0;comment:置顶顺序，0为不置顶，越大越靠前"`
+	Muted         bool           `gorm:"column:muted;not null;default:false;comment:是否免打扰"`
+	MutedUntil    sql.NullTime   `gorm:"column:muted_until;type:datetime;comment:免打扰截止时间，为空表示一直免打扰"`
+	Archived      bool           `gorm:"column:archived;not null;default:false;comment:是否归档"`
 	CreatedAt     time.Time      `gorm:"column:created_at;Index;type:datetime;comment:创建时间"`
 	DeletedAt     gorm.DeletedAt `gorm:"column:deleted_at;Index;type:datetime;comment:删除时间"`
 }
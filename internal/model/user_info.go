@@ -15,7 +15,7 @@ type UserInfo struct {
 	Avatar        string         `gorm:"column:avatar;type:char(255);default:https://cube.elemecdn.com/0/88/03b0d39583f48206768a7534e55bcpng.png;not null;comment:头像"`
 	Gender        int8           `gorm:"column:gender;comment:性别，0.男，1.女"`
 	Signature     string         `gorm:"column:signature;type:varchar(100);comment:个性签名"`
-	Password      string         `gorm:"column:password;type:char(18);not null;comment:密码"`
+	Password      string         `gorm:"column:password;type:varchar(100);not null;comment:密码，bcrypt哈希"`
 	Birthday      string         `gorm:"column:birthday;type:char(8);comment:生日"`
 	CreatedAt     time.Time      `gorm:"column:created_at;index;type:datetime;not null;comment:创建时间"`
 	DeletedAt     gorm.DeletedAt `gorm:"column:deleted_at;type:datetime;comment:删除时间"`
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// VoiceListen 语音消息的收听记录，每个接收者听过一次就记一条
+type VoiceListen struct {
+	Id          int64     `gorm:"column:id;primaryKey;comment:自增id"`
+	MessageUuid string    `gorm:"column:message_uuid;uniqueIndex:idx_message_user,priority:1;type:char(20);not null;comment:消息uuid"`
+	UserId      string    `gorm:"column:user_id;uniqueIndex:idx_message_user,priority:2;type:char(20);not null;comment:收听用户uuid"`
+	CreatedAt   time.Time `gorm:"column:created_at;not null;comment:第一次收听时间"`
+}
+
+func (VoiceListen) TableName() string {
+	return "voice_listen"
+}
@@ -0,0 +1,23 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// VoiceUpload 用户可以发送的语音文件，上传时记录，转发时给转发者再记一条
+// 发送语音消息时以这里保存的时长和波形为准
+type VoiceUpload struct {
+	Id        int64           `gorm:"column:id;primaryKey;comment:自增id"`
+	FileName  string          `gorm:"column:file_name;uniqueIndex:idx_file_user,priority:1;type:varchar(32);not null;comment:保存的文件名"`
+	UserId    string          `gorm:"column:user_id;uniqueIndex:idx_file_user,priority:2;type:char(20);not null;comment:可以发送该语音的用户uuid，上传者或转发者"`
+	FileSize  string          `gorm:"column:file_size;type:char(20);not null;comment:文件大小"`
+	FileType  string          `gorm:"column:file_type;type:char(10);not null;comment:文件扩展名"`
+	Duration  int             `gorm:"column:duration;not null;comment:语音时长，单位秒"`
+	Waveform  json.RawMessage `gorm:"column:waveform;type:json;comment:语音波形"`
+	CreatedAt time.Time       `gorm:"column:created_at;not null;comment:记录时间"`
+}
+
+func (VoiceUpload) TableName() string {
+	return "voice_upload"
+}
@@ -0,0 +1,109 @@
+package ack
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	RetryInterval = time.Second      // 检查重发的间隔
+	BaseBackoff   = 2 * time.Second  // 第一次重发前的等待时间，之后每次翻倍
+	MaxBackoff    = 30 * time.Second // 单次等待时间上限
+	MaxAttempts   = 5                // 最多发送次数，超过后放弃，客户端重连后按seq同步
+)
+
+// Pending 已经写给客户端但还没有被确认的消息
+type Pending struct {
+	MessageUuid    string
+	ConversationId string
+	Seq            int64
+	Payload        []byte
+	Attempts       int
+	NextRetry      time.Time
+}
+
+// Tracker 记录一个连接上未确认的消息，按指数退避重发
+// 确认是累计的，确认某个会话的seq表示该会话中seq及之前的消息都已收到
+type Tracker struct {
+	mutex   sync.Mutex
+	pending map[string]*Pending
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		pending: make(map[string]*Pending),
+	}
+}
+
+// Track 记录一次发送，同一条消息重发时只增加次数
+func (t *Tracker) Track(messageUuid string, conversationId string, seq int64, payload []byte, now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	p, ok := t.pending[messageUuid]
+	if !ok {
+		p = &Pending{
+			MessageUuid:    messageUuid,
+			ConversationId: conversationId,
+			Seq:            seq,
+			Payload:        payload,
+		}
+		t.pending[messageUuid] = p
+	}
+	p.Attempts++
+	p.NextRetry = now.Add(backoff(p.Attempts))
+}
+
+// Ack 确认会话中seq及之前的消息，返回被确认的消息数
+func (t *Tracker) Ack(conversationId string, seq int64) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	count := 0
+	for messageUuid, p := range t.pending {
+		if p.ConversationId == conversationId && p.Seq <= seq {
+			delete(t.pending, messageUuid)
+			count++
+		}
+	}
+	return count
+}
+
+// Due 返回到了重发时间的消息，已经达到最多发送次数的直接丢弃
+func (t *Tracker) Due(now time.Time) []Pending {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var due []Pending
+	for messageUuid, p := range t.pending {
+		if now.Before(p.NextRetry) {
+			continue
+		}
+		if p.Attempts >= MaxAttempts {
+			delete(t.pending, messageUuid)
+			continue
+		}
+		due = append(due, *p)
+	}
+	// 同一会话内按seq顺序重发
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].ConversationId != due[j].ConversationId {
+			return due[i].ConversationId < due[j].ConversationId
+		}
+		return due[i].Seq < due[j].Seq
+	})
+	return due
+}
+
+// Len 未确认的消息数
+func (t *Tracker) Len() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.pending)
+}
+
+func backoff(attempts int) time.Duration {
+	wait := BaseBackoff << (attempts - 1)
+	if wait > MaxBackoff || wait <= 0 {
+		return MaxBackoff
+	}
+	return wait
+}
@@ -3,32 +3,41 @@ package chat
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"github.com/segmentio/kafka-go"
 	"kama_chat_server/internal/config"
 	"kama_chat_server/internal/dao"
 	"kama_chat_server/internal/dto/request"
 	"kama_chat_server/internal/model"
-	myKafka "kama_chat_server/internal/service/kafka"
+	"kama_chat_server/internal/service/chat/ack"
+	"kama_chat_server/internal/service/gorm"
 	"kama_chat_server/pkg/constants"
 	"kama_chat_server/pkg/enum/message/message_status_enum"
+	"kama_chat_server/pkg/enum/message/message_type_enum"
+	"kama_chat_server/pkg/enum/user_info/online_status_enum"
 	"kama_chat_server/pkg/zlog"
 	"log"
 	"net/http"
-	"strconv"
+	"sync"
+	"time"
 )
 
 type MessageBack struct {
-	Message []byte
-	Uuid    string
+	Message        []byte
+	Uuid           string
+	ConversationId string
+	Seq            int64 // 为0的不需要客户端确认
+	Close          bool  // 发送后断开连接，用于登出
 }
 
 type Client struct {
-	Conn     *websocket.Conn
-	Uuid     string
-	SendTo   chan []byte       // 给server端
-	SendBack chan *MessageBack // 给前端
+	Conn      *websocket.Conn
+	Uuid      string
+	SendBack  chan *MessageBack // 给前端
+	tracker   *ack.Tracker      // 已发送未确认的消息
+	closeOnce sync.Once
 }
 
 var upgrader = websocket.Upgrader{
@@ -52,101 +61,140 @@ func (c *Client) Read() {
 		_, jsonMessage, err := c.Conn.ReadMessage() // 阻塞状态
 		if err != nil {
 			zlog.Error(err.Error())
+			// 连接异常断开也要清理，否则会一直留在在线列表中
+			if err := c.close(); err != nil {
+				zlog.Error(err.Error())
+			}
 			return // 直接断开websocket
 		} else {
+			if c.handleEvent(jsonMessage) {
+				continue
+			}
 			var message = request.ChatMessageRequest{}
 			if err := json.Unmarshal(jsonMessage, &message); err != nil {
 				zlog.Error(err.Error())
+				continue
 			}
-			log.Println("接受到消息为: ", jsonMessage)
-			if messageMode == "channel" {
-				// 如果server的转发channel没满，先把sendto中的给transmit
-				for len(ChatServer.Transmit) < constants.CHANNEL_SIZE && len(c.SendTo) > 0 {
-					sendToMessage := <-c.SendTo
-					ChatServer.SendMessageToTransmit(sendToMessage)
-				}
-				// 如果server没满，sendto空了，直接给server的transmit
-				if len(ChatServer.Transmit) < constants.CHANNEL_SIZE {
-					ChatServer.SendMessageToTransmit(jsonMessage)
-				} else if len(c.SendTo) < constants.CHANNEL_SIZE {
-					// 如果server满了，直接塞sendto
-					c.SendTo <- jsonMessage
-				} else {
-					// 否则考虑加宽channel size，或者使用kafka
-					if err := c.Conn.WriteMessage(websocket.TextMessage, []byte("由于目前同一时间过多用户发送消息，消息发送失败，请稍后重试")); err != nil {
-						zlog.Error(err.Error())
-					}
-				}
-			} else {
-				if err := myKafka.KafkaService.ChatWriter.WriteMessages(ctx, kafka.Message{
-					Key:   []byte(strconv.Itoa(config.GetConfig().KafkaConfig.Partition)),
-					Value: jsonMessage,
-				}); err != nil {
+			// 聊天记录卡片引用的消息需要校验权限，只能通过转发接口发送
+			if message.Type == message_type_enum.ChatHistory {
+				zlog.Error(fmt.Sprintf("用户%s通过websocket发送聊天记录卡片", c.Uuid))
+				continue
+			}
+			// 系统通知只能由服务端生成
+			if message.Type == message_type_enum.System {
+				zlog.Error(fmt.Sprintf("用户%s通过websocket发送系统通知", c.Uuid))
+				continue
+			}
+			// 发送者以连接建立时token中的身份为准，不信任前端传来的send_id
+			if message.SendId != c.Uuid {
+				message.SendId = c.Uuid
+				if jsonMessage, err = json.Marshal(message); err != nil {
 					zlog.Error(err.Error())
+					continue
+				}
+			}
+			log.Println("接受到消息为: ", jsonMessage)
+			if err := ChatServer.Publish(jsonMessage); err != nil {
+				zlog.Error(err.Error())
+				// 只有Write协程写连接，提示也放进发送通道
+				if errors.Is(err, errTransportBusy) {
+					ChatServer.deliverToClient(c, &MessageBack{Message: []byte(errTransportBusy.Error())})
 				}
-				zlog.Info("已发送消息：" + string(jsonMessage))
 			}
 		}
 	}
 }
 
-// 从send通道读取消息发送给websocket
+// 从send通道读取消息发送给websocket，并定时重发未确认的消息
 func (c *Client) Write() {
 	zlog.Info("ws write goroutine start")
-	for messageBack := range c.SendBack { // 阻塞状态
-		// 通过 WebSocket 发送消息
-		err := c.Conn.WriteMessage(websocket.TextMessage, messageBack.Message)
-		if err != nil {
-			zlog.Error(err.Error())
-			return // 直接断开websocket
-		}
-		// log.Println("已发送消息：", messageBack.Message)
-		// 说明顺利发送，修改状态为已发送
-		if res := dao.GormDB.Model(&model.Message{}).Where("uuid = ?", messageBack.Uuid).Update("status", message_status_enum.Sent); res.Error != nil {
-			zlog.Error(res.Error.Error())
+	ticker := time.NewTicker(ack.RetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case messageBack, ok := <-c.SendBack: // 阻塞状态
+			if !ok {
+				return
+			}
+			// 通过 WebSocket 发送消息
+			if err := c.Conn.WriteMessage(websocket.TextMessage, messageBack.Message); err != nil {
+				zlog.Error(err.Error())
+				return // 直接断开websocket
+			}
+			if messageBack.Close {
+				if err := c.close(); err != nil {
+					zlog.Error(err.Error())
+				}
+				return
+			}
+			if messageBack.Uuid == "" {
+				continue
+			}
+			// 说明顺利发送，修改状态为已发送，已送达的不回退
+			if res := dao.GormDB.Model(&model.Message{}).Where("uuid = ? AND status = ?", messageBack.Uuid, message_status_enum.Unsent).Update("status", message_status_enum.Sent); res.Error != nil {
+				zlog.Error(res.Error.Error())
+			}
+			if messageBack.Seq > 0 {
+				c.tracker.Track(messageBack.Uuid, messageBack.ConversationId, messageBack.Seq, messageBack.Message, time.Now())
+			}
+		case now := <-ticker.C:
+			for _, pending := range c.tracker.Due(now) {
+				if err := c.Conn.WriteMessage(websocket.TextMessage, pending.Payload); err != nil {
+					zlog.Error(err.Error())
+					return
+				}
+				c.tracker.Track(pending.MessageUuid, pending.ConversationId, pending.Seq, pending.Payload, now)
+			}
 		}
 	}
 }
 
 // NewClientInit 当接受到前端有登录消息时，会调用该函数
 func NewClientInit(c *gin.Context, clientId string) {
-	kafkaConfig := config.GetConfig().KafkaConfig
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		zlog.Error(err.Error())
+		return
 	}
 	client := &Client{
 		Conn:     conn,
 		Uuid:     clientId,
-		SendTo:   make(chan []byte, constants.CHANNEL_SIZE),
 		SendBack: make(chan *MessageBack, constants.CHANNEL_SIZE),
+		tracker:  ack.NewTracker(),
 	}
-	if kafkaConfig.MessageMode == "channel" {
-		ChatServer.SendClientToLogin(client)
-	} else {
-		KafkaChatServer.SendClientToLogin(client)
-	}
+	ChatServer.SendClientToLogin(client)
 	go client.Read()
 	go client.Write()
 	zlog.Info("ws连接成功")
 }
 
-// ClientLogout 当接受到前端有登出消息时，会调用该函数
-func ClientLogout(clientId string) (string, int) {
-	kafkaConfig := config.GetConfig().KafkaConfig
-	client := ChatServer.Clients[clientId]
-	if client != nil {
-		if kafkaConfig.MessageMode == "channel" {
-			ChatServer.SendClientToLogout(client)
-		} else {
-			KafkaChatServer.SendClientToLogout(client)
+// close 断开连接并清理，主动登出和连接异常断开都会走到这里，只执行一次
+func (c *Client) close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		// 先同步移出在线列表，保证之后不会再有消息投递到即将关闭的SendBack
+		ChatServer.removeClientIfSame(c)
+		err = c.Conn.Close()
+		close(c.SendBack)
+		// 记录离线时间，下次登录时从这里开始同步
+		if message, ret := gorm.UserInfoService.SetLastOfflineAt(c.Uuid, time.Now()); ret != 0 {
+			zlog.Error(message)
 		}
-		if err := client.Conn.Close(); err != nil {
+		// 已经重连到本机或其他节点的不广播离线
+		if node, err := lookupPresence(c.Uuid); err != nil {
 			zlog.Error(err.Error())
-			return constants.SYSTEM_ERROR, -1
+		} else if node == "" {
+			broadcastPresence(c.Uuid, online_status_enum.Offline)
 		}
-		close(client.SendTo)
-		close(client.SendBack)
+	})
+	return err
+}
+
+// ClientLogout 当接受到前端有登出消息时，会调用该函数，连接由Write协程发送登出提示后断开
+func ClientLogout(clientId string) (string, int) {
+	client, ok := ChatServer.GetClient(clientId)
+	if ok {
+		ChatServer.SendClientToLogout(client)
 	}
 	return "退出成功", 0
 }
@@ -0,0 +1,637 @@
+package dispatcher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kama_chat_server/internal/dto/request"
+	"kama_chat_server/internal/dto/respond"
+	"kama_chat_server/internal/model"
+	"kama_chat_server/pkg/enum/message/message_status_enum"
+	"kama_chat_server/pkg/enum/message/message_type_enum"
+	"kama_chat_server/pkg/enum/message/ws_event_enum"
+	"kama_chat_server/pkg/util/conversation"
+	"kama_chat_server/pkg/util/random"
+	"strings"
+	"time"
+)
+
+const (
+	previewLength  = 50  // 会话列表中文本消息预览的最大长度
+	waveformLength = 100 // 语音波形最多的采样点数
+)
+
+// voicePrefix 上传接口保存的语音地址前缀
+const voicePrefix = "/static/voices/"
+
+// Store 消息持久化和成员查询
+type Store interface {
+	SaveMessage(message *model.Message) error
+	GetGroupMembers(groupId string) ([]string, error)
+	// NextSeq 分配会话内的下一个序号，必须单调递增
+	NextSeq(conversationId string) (int64, error)
+	// UpdateSessions 更新会话双方或所有群成员会话的最新消息
+	UpdateSessions(message *model.Message, preview string) error
+	// GetMessage 按uuid查询消息，不存在时返回错误
+	GetMessage(uuid string) (*model.Message, error)
+	// IsGroupOwner 用户是否为群主，只有群主可以@所有人
+	IsGroupOwner(groupId string, userId string) (bool, error)
+	// SaveMentions 记录群聊消息@到的成员
+	SaveMentions(message *model.Message, userIds []string) error
+	// GetMutedUsers 在userIds中找出对会话对象开启了免打扰的用户
+	GetMutedUsers(receiveId string, userIds []string) (map[string]bool, error)
+	// GetMuteState 查询成员在群聊中的禁言状态
+	GetMuteState(groupId string, userId string) (MuteState, error)
+	// GetVoiceUpload 查询用户可以发送的语音文件，不存在时返回错误
+	GetVoiceUpload(fileName string, userId string) (*model.VoiceUpload, error)
+}
+
+// MuteState 成员在群聊中的禁言状态
+type MuteState struct {
+	// MutedUntil 个人禁言的到期时间，零值表示没有禁言
+	MutedUntil time.Time
+	// MuteAll 群聊开启了全员禁言
+	MuteAll bool
+	// Exempt 群主和管理员不受全员禁言限制
+	Exempt bool
+}
+
+// Cache 聊天记录缓存，对应key不存在时不用写入，等下次查询时再从数据库加载
+type Cache interface {
+	AppendMessage(key string, rsp interface{}) error
+}
+
+// OutboundMessage 投递给客户端的一条消息，Seq为0的不需要客户端确认，也不会重发
+type OutboundMessage struct {
+	MessageUuid    string
+	ConversationId string
+	Seq            int64
+	Payload        []byte
+}
+
+// Delivery 把消息投递给在线用户，不在线的直接跳过，消息已经存表，登录后再拉取
+type Delivery interface {
+	Deliver(uuid string, message OutboundMessage)
+}
+
+// Dispatcher 统一的消息处理流水线，channel和kafka模式只负责消息的接入，持久化、扇出和缓存的规则都在这里
+type Dispatcher struct {
+	store    Store
+	cache    Cache
+	delivery Delivery
+	now      func() time.Time
+	newUuid  func() string
+}
+
+func NewDispatcher(store Store, cache Cache, delivery Delivery) *Dispatcher {
+	return &Dispatcher{
+		store:    store,
+		cache:    cache,
+		delivery: delivery,
+		now:      time.Now,
+		newUuid: func() string {
+			return fmt.Sprintf("M%s", random.GetNowAndLenRandomString(11))
+		},
+	}
+}
+
+// rejectError 消息校验不通过，reason会通过发送失败事件告诉发送者
+type rejectError struct {
+	reason     string
+	mutedUntil string
+	err        error
+}
+
+func (e *rejectError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rejectError) Unwrap() error {
+	return e.err
+}
+
+// reject 生成校验不通过的错误，reason是给发送者看的原因
+func reject(reason string, err error) error {
+	return &rejectError{reason: reason, err: err}
+}
+
+// Dispatch 处理一条客户端发来的聊天消息，校验不通过时给发送者推送发送失败事件
+func (d *Dispatcher) Dispatch(data []byte) error {
+	var chatMessageReq request.ChatMessageRequest
+	if err := json.Unmarshal(data, &chatMessageReq); err != nil {
+		return err
+	}
+	if chatMessageReq.ReceiveId == "" || chatMessageReq.SendId == "" {
+		return errors.New("消息缺少发送者或接收者")
+	}
+	err := d.dispatch(chatMessageReq)
+	var rejected *rejectError
+	if errors.As(err, &rejected) {
+		payload, marshalErr := json.Marshal(respond.SendFailedEventRespond{
+			Event:      ws_event_enum.SendFailed,
+			ReceiveId:  chatMessageReq.ReceiveId,
+			Reason:     rejected.reason,
+			MutedUntil: rejected.mutedUntil,
+		})
+		if marshalErr != nil {
+			return errors.Join(err, marshalErr)
+		}
+		d.delivery.Deliver(chatMessageReq.SendId, OutboundMessage{Payload: payload})
+	}
+	return err
+}
+
+// dispatch 按消息类型处理
+func (d *Dispatcher) dispatch(chatMessageReq request.ChatMessageRequest) error {
+	switch chatMessageReq.Type {
+	case message_type_enum.Text, message_type_enum.File, message_type_enum.ChatHistory, message_type_enum.System:
+		return d.dispatchChat(chatMessageReq)
+	case message_type_enum.Voice:
+		if err := d.checkVoice(&chatMessageReq); err != nil {
+			return err
+		}
+		return d.dispatchChat(chatMessageReq)
+	case message_type_enum.AudioOrVideo:
+		return d.dispatchAV(chatMessageReq)
+	default:
+		return fmt.Errorf("不支持的消息类型：%d", chatMessageReq.Type)
+	}
+}
+
+// newMessage 根据消息类型生成要存表的message
+func (d *Dispatcher) newMessage(chatMessageReq request.ChatMessageRequest) model.Message {
+	message := model.Message{
+		Uuid:           d.newUuid(),
+		SessionId:      chatMessageReq.SessionId,
+		Type:           chatMessageReq.Type,
+		SendId:         chatMessageReq.SendId,
+		SendName:       chatMessageReq.SendName,
+		SendAvatar:     NormalizePath(chatMessageReq.SendAvatar), // 对SendAvatar去除前面/static之前的所有内容，防止ip前缀引入
+		ReceiveId:      chatMessageReq.ReceiveId,
+		Status:         message_status_enum.Unsent,
+		CreatedAt:      d.now(),
+		ConversationId: conversation.GetConversationId(chatMessageReq.SendId, chatMessageReq.ReceiveId),
+	}
+	switch chatMessageReq.Type {
+	case message_type_enum.Text:
+		message.Content = chatMessageReq.Content
+		message.FileSize = "0B"
+	case message_type_enum.File:
+		message.Url = chatMessageReq.Url
+		message.FileSize = chatMessageReq.FileSize
+		message.FileType = chatMessageReq.FileType
+		message.FileName = chatMessageReq.FileName
+	case message_type_enum.AudioOrVideo:
+		message.AVdata = chatMessageReq.AVdata
+	case message_type_enum.Voice:
+		message.Url = chatMessageReq.Url
+		message.FileSize = chatMessageReq.FileSize
+		message.FileType = chatMessageReq.FileType
+		message.Duration = chatMessageReq.Duration
+		if len(chatMessageReq.Waveform) > 0 {
+			// checkVoice已经换成上传记录中的波形，这里不会失败
+			message.Waveform, _ = json.Marshal(chatMessageReq.Waveform)
+		}
+	case message_type_enum.ChatHistory, message_type_enum.System:
+		message.Content = chatMessageReq.Content
+	}
+	return message
+}
+
+// quote 校验被引用的消息并记录摘要，群聊中的回复归到被引用消息所在的话题
+func (d *Dispatcher) quote(message *model.Message, replyTo string) error {
+	if replyTo == "" {
+		return nil
+	}
+	quoted, err := d.store.GetMessage(replyTo)
+	if err != nil {
+		return reject("引用的消息不存在", fmt.Errorf("查询引用的消息%s失败：%w", replyTo, err))
+	}
+	if quoted.ConversationId != message.ConversationId {
+		return reject("引用的消息不存在", fmt.Errorf("引用的消息%s不在会话%s中", replyTo, message.ConversationId))
+	}
+	if quoted.RecalledAt.Valid {
+		return reject("引用的消息已撤回", fmt.Errorf("引用的消息%s已撤回", replyTo))
+	}
+	message.ReplyTo = quoted.Uuid
+	message.ReplySendId = quoted.SendId
+	message.ReplySendName = quoted.SendName
+	message.ReplySnippet = Preview(quoted)
+	if message.ReceiveId[0] == 'G' {
+		message.ThreadId = quoted.ThreadId
+		if message.ThreadId == "" {
+			message.ThreadId = quoted.Uuid
+		}
+	}
+	return nil
+}
+
+// mention 校验群聊消息中的@，返回需要提醒的成员，不包括发送者自己
+func (d *Dispatcher) mention(message *model.Message, chatMessageReq request.ChatMessageRequest, members []string) ([]string, error) {
+	if chatMessageReq.MentionAll {
+		ok, err := d.store.IsGroupOwner(message.ReceiveId, message.SendId)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, reject("只有群主可以@所有人", fmt.Errorf("用户%s不能在群聊%s中@所有人", message.SendId, message.ReceiveId))
+		}
+	}
+	var mentions []string
+	for _, uuid := range chatMessageReq.Mentions {
+		if !contains(members, uuid) {
+			return nil, reject("@的成员不在群聊中", fmt.Errorf("@的用户%s不在群聊%s中", uuid, message.ReceiveId))
+		}
+		if uuid != message.SendId && !contains(mentions, uuid) {
+			mentions = append(mentions, uuid)
+		}
+	}
+	if len(mentions) > 0 {
+		mentionsBytes, err := json.Marshal(mentions)
+		if err != nil {
+			return nil, err
+		}
+		message.Mentions = mentionsBytes
+	}
+	message.MentionAll = chatMessageReq.MentionAll
+	if !message.MentionAll {
+		return mentions, nil
+	}
+	notified := make([]string, 0, len(members))
+	for _, member := range members {
+		if member != message.SendId {
+			notified = append(notified, member)
+		}
+	}
+	return notified, nil
+}
+
+// notifyMentions 记录@并单独提醒被@的成员，聊天消息本身对所有成员都一样，开启免打扰的成员只记录不提醒
+func (d *Dispatcher) notifyMentions(message *model.Message, notified []string) error {
+	if len(notified) == 0 {
+		return nil
+	}
+	if err := d.store.SaveMentions(message, notified); err != nil {
+		return err
+	}
+	muted, err := d.store.GetMutedUsers(message.ReceiveId, notified)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(respond.MentionEventRespond{
+		Event:       ws_event_enum.Mention,
+		GroupId:     message.ReceiveId,
+		MessageUuid: message.Uuid,
+		SendId:      message.SendId,
+		SendName:    message.SendName,
+		Preview:     Preview(message),
+		MentionAll:  message.MentionAll,
+	})
+	if err != nil {
+		return err
+	}
+	for _, uuid := range notified {
+		if !muted[uuid] {
+			d.delivery.Deliver(uuid, OutboundMessage{Payload: payload})
+		}
+	}
+	return nil
+}
+
+// checkVoice 语音必须是发送者上传或转发过的文件，时长和波形换成上传时校验并保存的值，不使用客户端传来的
+func (d *Dispatcher) checkVoice(chatMessageReq *request.ChatMessageRequest) error {
+	fileName, ok := VoiceFileName(chatMessageReq.Url)
+	if !ok {
+		return reject("语音地址不合法", fmt.Errorf("语音地址不合法：%s", chatMessageReq.Url))
+	}
+	upload, err := d.store.GetVoiceUpload(fileName, chatMessageReq.SendId)
+	if err != nil {
+		return reject("语音文件不存在，请重新上传", fmt.Errorf("用户%s不能发送语音%s：%w", chatMessageReq.SendId, fileName, err))
+	}
+	var waveform []int
+	if len(upload.Waveform) > 0 {
+		if err := json.Unmarshal(upload.Waveform, &waveform); err != nil {
+			return err
+		}
+	}
+	chatMessageReq.Url = voicePrefix + upload.FileName
+	chatMessageReq.FileSize = upload.FileSize
+	chatMessageReq.FileType = upload.FileType
+	chatMessageReq.Duration = upload.Duration
+	chatMessageReq.Waveform = waveform
+	return nil
+}
+
+// VoiceFileName 从语音地址中取出保存的文件名，不是上传接口保存的语音时返回false
+func VoiceFileName(url string) (string, bool) {
+	path := NormalizePath(url)
+	if !strings.HasPrefix(path, voicePrefix) {
+		return "", false
+	}
+	fileName := strings.TrimPrefix(path, voicePrefix)
+	if fileName == "" || strings.ContainsAny(fileName, "/\\") {
+		return "", false
+	}
+	return fileName, true
+}
+
+// CheckWaveform 校验语音波形，最多waveformLength个采样点，每个采样值在0到255之间
+func CheckWaveform(waveform []int) error {
+	if len(waveform) > waveformLength {
+		return fmt.Errorf("语音波形最多%d个采样点", waveformLength)
+	}
+	for _, sample := range waveform {
+		if sample < 0 || sample > 255 {
+			return fmt.Errorf("语音波形采样值不合法：%d", sample)
+		}
+	}
+	return nil
+}
+
+// checkMuted 被禁言的成员不能在群里发言
+func (d *Dispatcher) checkMuted(message *model.Message) error {
+	state, err := d.store.GetMuteState(message.ReceiveId, message.SendId)
+	if err != nil {
+		return err
+	}
+	rejected := &rejectError{}
+	switch {
+	case state.MutedUntil.After(d.now()):
+		rejected.reason = "你已被禁言"
+		rejected.mutedUntil = state.MutedUntil.Format("2006-01-02 15:04:05")
+	case state.MuteAll && !state.Exempt:
+		rejected.reason = "群聊已开启全员禁言"
+	default:
+		return nil
+	}
+	rejected.err = fmt.Errorf("用户%s在群聊%s中被禁言：%s", message.SendId, message.ReceiveId, rejected.reason)
+	return rejected
+}
+
+// save 分配会话序号后存表
+func (d *Dispatcher) save(message *model.Message) error {
+	seq, err := d.store.NextSeq(message.ConversationId)
+	if err != nil {
+		return err
+	}
+	message.Seq = seq
+	return d.store.SaveMessage(message)
+}
+
+// dispatchChat 文本和文件消息：存表，扇出给接收者并回显给发送者，追加到聊天记录缓存
+func (d *Dispatcher) dispatchChat(chatMessageReq request.ChatMessageRequest) error {
+	message := d.newMessage(chatMessageReq)
+	if err := d.quote(&message, chatMessageReq.ReplyTo); err != nil {
+		return err
+	}
+	if chatMessageReq.ReceiveId[0] == 'G' {
+		members, err := d.store.GetGroupMembers(message.ReceiveId)
+		if err != nil {
+			return err
+		}
+		// 系统通知由群聊自己发出，不是成员发言，不校验成员身份，也不受禁言限制
+		if message.Type != message_type_enum.System {
+			if !contains(members, message.SendId) {
+				return fmt.Errorf("用户%s不在群聊%s中", message.SendId, message.ReceiveId)
+			}
+			if err := d.checkMuted(&message); err != nil {
+				return err
+			}
+		}
+		notified, err := d.mention(&message, chatMessageReq, members)
+		if err != nil {
+			return err
+		}
+		if err := d.save(&message); err != nil {
+			return err
+		}
+		messageRsp := respond.GetGroupMessageListRespond{
+			Uuid:           message.Uuid,
+			ConversationId: message.ConversationId,
+			Seq:            message.Seq,
+			SendId:         message.SendId,
+			SendName:       message.SendName,
+			SendAvatar:     chatMessageReq.SendAvatar,
+			ReceiveId:      message.ReceiveId,
+			Type:           message.Type,
+			Content:        message.Content,
+			Url:            message.Url,
+			FileSize:       message.FileSize,
+			FileName:       message.FileName,
+			FileType:       message.FileType,
+			CreatedAt:      message.CreatedAt.Format("2006-01-02 15:04:05"),
+			Quote:          Quote(&message),
+			ThreadId:       message.ThreadId,
+			Mentions:       Mentions(&message),
+			MentionAll:     message.MentionAll,
+			Duration:       message.Duration,
+			Waveform:       chatMessageReq.Waveform,
+		}
+		payload, err := json.Marshal(messageRsp)
+		if err != nil {
+			return err
+		}
+		outbound := OutboundMessage{
+			MessageUuid:    message.Uuid,
+			ConversationId: message.ConversationId,
+			Seq:            message.Seq,
+			Payload:        payload,
+		}
+		// 群成员包括发送者自己，所以也会回显给发送者，系统通知的发送者是群聊自己，不需要回显
+		for _, member := range members {
+			d.delivery.Deliver(member, outbound)
+		}
+		cacheErr := d.cache.AppendMessage("group_messagelist_"+message.ReceiveId, messageRsp)
+		return errors.Join(cacheErr, d.notifyMentions(&message, notified), d.touchSessions(&message, members))
+	}
+
+	if err := d.save(&message); err != nil {
+		return err
+	}
+	messageRsp := respond.GetMessageListRespond{
+		Uuid:           message.Uuid,
+		ConversationId: message.ConversationId,
+		Seq:            message.Seq,
+		SendId:         message.SendId,
+		SendName:       message.SendName,
+		SendAvatar:     chatMessageReq.SendAvatar,
+		ReceiveId:      message.ReceiveId,
+		Type:           message.Type,
+		Content:        message.Content,
+		Url:            message.Url,
+		FileSize:       message.FileSize,
+		FileName:       message.FileName,
+		FileType:       message.FileType,
+		CreatedAt:      message.CreatedAt.Format("2006-01-02 15:04:05"),
+		Quote:          Quote(&message),
+		Duration:       message.Duration,
+		Waveform:       chatMessageReq.Waveform,
+	}
+	payload, err := json.Marshal(messageRsp)
+	if err != nil {
+		return err
+	}
+	outbound := OutboundMessage{
+		MessageUuid:    message.Uuid,
+		ConversationId: message.ConversationId,
+		Seq:            message.Seq,
+		Payload:        payload,
+	}
+	d.delivery.Deliver(message.ReceiveId, outbound)
+	// 前后端的req和rsp结构不同，前端存储message的messageList不能存req，只能存rsp，所以由后端回显，前端不回显
+	d.delivery.Deliver(message.SendId, outbound)
+	// 双方各自的聊天记录缓存都要追加
+	cacheErr := errors.Join(
+		d.cache.AppendMessage("message_list_"+message.SendId+"_"+message.ReceiveId, messageRsp),
+		d.cache.AppendMessage("message_list_"+message.ReceiveId+"_"+message.SendId, messageRsp),
+	)
+	return errors.Join(cacheErr, d.touchSessions(&message, []string{message.SendId, message.ReceiveId}))
+}
+
+// touchSessions 更新会话的最新消息，并通知参与者刷新会话列表
+func (d *Dispatcher) touchSessions(message *model.Message, participants []string) error {
+	preview := Preview(message)
+	if err := d.store.UpdateSessions(message, preview); err != nil {
+		return err
+	}
+	for _, uuid := range participants {
+		// 单聊中每个人看到的会话对象是对方
+		contactId := message.ReceiveId
+		if message.ReceiveId[0] != 'G' && uuid == message.ReceiveId {
+			contactId = message.SendId
+		}
+		payload, err := json.Marshal(respond.SessionUpdateRespond{
+			Event:         ws_event_enum.SessionUpdate,
+			ContactId:     contactId,
+			LastMessage:   preview,
+			LastMessageAt: message.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+		if err != nil {
+			return err
+		}
+		d.delivery.Deliver(uuid, OutboundMessage{Payload: payload})
+	}
+	return nil
+}
+
+// dispatchAV 通话信令：只有发起、接听、拒绝通话需要存表，只转发给对方，不回显，不进缓存
+func (d *Dispatcher) dispatchAV(chatMessageReq request.ChatMessageRequest) error {
+	if chatMessageReq.ReceiveId[0] != 'U' {
+		return nil
+	}
+	var avData request.AVData
+	if err := json.Unmarshal([]byte(chatMessageReq.AVdata), &avData); err != nil {
+		return err
+	}
+	message := d.newMessage(chatMessageReq)
+	if avData.MessageId == "PROXY" && (avData.Type == "start_call" || avData.Type == "receive_call" || avData.Type == "reject_call") {
+		if err := d.save(&message); err != nil {
+			return err
+		}
+		if err := d.touchSessions(&message, []string{message.SendId, message.ReceiveId}); err != nil {
+			return err
+		}
+	}
+	messageRsp := respond.AVMessageRespond{
+		SendId:     message.SendId,
+		SendName:   message.SendName,
+		SendAvatar: message.SendAvatar,
+		ReceiveId:  message.ReceiveId,
+		Type:       message.Type,
+		Content:    message.Content,
+		Url:        message.Url,
+		FileSize:   message.FileSize,
+		FileName:   message.FileName,
+		FileType:   message.FileType,
+		CreatedAt:  message.CreatedAt.Format("2006-01-02 15:04:05"),
+		AVdata:     message.AVdata,
+	}
+	payload, err := json.Marshal(messageRsp)
+	if err != nil {
+		return err
+	}
+	// 通话这不能回显，发回去的话就会出现两个start_call
+	// 通话信令过期就没有意义了，不需要确认和重发，所以不带Seq
+	d.delivery.Deliver(message.ReceiveId, OutboundMessage{
+		MessageUuid: message.Uuid,
+		Payload:     payload,
+	})
+	return nil
+}
+
+// Preview 会话列表中最新消息的预览，文本截断，其他类型显示类型名
+func Preview(message *model.Message) string {
+	switch message.Type {
+	case message_type_enum.Text, message_type_enum.System:
+		content := []rune(message.Content)
+		if len(content) > previewLength {
+			return string(content[:previewLength]) + "..."
+		}
+		return message.Content
+	case message_type_enum.Voice:
+		return "[语音]"
+	case message_type_enum.File:
+		return "[文件]"
+	case message_type_enum.AudioOrVideo:
+		return "[通话]"
+	case message_type_enum.ChatHistory:
+		return "[聊天记录]"
+	default:
+		return "[消息]"
+	}
+}
+
+// Quote 消息引用的摘要，没有引用时返回nil
+func Quote(message *model.Message) *respond.QuoteRespond {
+	if message.ReplyTo == "" {
+		return nil
+	}
+	return &respond.QuoteRespond{
+		Uuid:     message.ReplyTo,
+		SendId:   message.ReplySendId,
+		SendName: message.ReplySendName,
+		Snippet:  message.ReplySnippet,
+	}
+}
+
+// Mentions 消息中@的成员，不包括@所有人
+func Mentions(message *model.Message) []string {
+	if len(message.Mentions) == 0 {
+		return nil
+	}
+	var mentions []string
+	if err := json.Unmarshal(message.Mentions, &mentions); err != nil {
+		return nil
+	}
+	return mentions
+}
+
+// Waveform 语音消息的波形
+func Waveform(message *model.Message) []int {
+	if len(message.Waveform) == 0 {
+		return nil
+	}
+	var waveform []int
+	if err := json.Unmarshal(message.Waveform, &waveform); err != nil {
+		return nil
+	}
+	return waveform
+}
+
+// NormalizePath 将https://127.0.0.1:8000/static/xxx 转为 /static/xxx，不是本站静态资源的原样返回
+func NormalizePath(path string) string {
+	staticIndex := strings.Index(path, "/static/")
+	if staticIndex < 0 {
+		return path
+	}
+	return path[staticIndex:]
+}
+
+func contains(list []string, target string) bool {
+	for _, item := range list {
+		if item == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kama_chat_server/internal/dto/request"
+	"kama_chat_server/internal/dto/respond"
+	"kama_chat_server/internal/service/chat/dispatcher"
+	"kama_chat_server/internal/service/gorm"
+	"kama_chat_server/pkg/enum/message/ws_event_enum"
+	"kama_chat_server/pkg/enum/user_info/online_status_enum"
+	"kama_chat_server/pkg/zlog"
+	"time"
+)
+
+// websocket上除聊天消息外的事件，都是临时的，不存message表，投递时不需要客户端确认
+
+// handleEvent 处理带event字段的非聊天消息，返回false说明是聊天消息
+func (c *Client) handleEvent(jsonMessage []byte) bool {
+	var event request.WsEventRequest
+	if err := json.Unmarshal(jsonMessage, &event); err != nil || event.Event == "" {
+		return false
+	}
+	switch event.Event {
+	case ws_event_enum.Ack:
+		var ackReq request.WsAckRequest
+		if err := json.Unmarshal(jsonMessage, &ackReq); err != nil {
+			zlog.Error(err.Error())
+			return true
+		}
+		c.tracker.Ack(ackReq.ConversationId, ackReq.Seq)
+		if message, ret := gorm.MessageService.AckMessages(c.Uuid, ackReq.ConversationId, ackReq.Seq); ret != 0 {
+			zlog.Error(message)
+		}
+	case ws_event_enum.Read:
+		var readReq request.WsReadRequest
+		if err := json.Unmarshal(jsonMessage, &readReq); err != nil {
+			zlog.Error(err.Error())
+			return true
+		}
+		c.read(readReq)
+	case ws_event_enum.TypingStart, ws_event_enum.TypingStop:
+		var typingReq request.WsTypingRequest
+		if err := json.Unmarshal(jsonMessage, &typingReq); err != nil {
+			zlog.Error(err.Error())
+			return true
+		}
+		c.typing(typingReq)
+	case ws_event_enum.Presence:
+		var presenceReq request.WsPresenceRequest
+		if err := json.Unmarshal(jsonMessage, &presenceReq); err != nil {
+			zlog.Error(err.Error())
+			return true
+		}
+		// 离线只能由断开连接触发
+		if presenceReq.Status != online_status_enum.Online && presenceReq.Status != online_status_enum.Away {
+			zlog.Error(fmt.Sprintf("不支持的在线状态：%s", presenceReq.Status))
+			return true
+		}
+		broadcastPresence(c.Uuid, presenceReq.Status)
+	default:
+		zlog.Error(fmt.Sprintf("未知的事件类型：%s", event.Event))
+	}
+	return true
+}
+
+// deliverEvent 把事件投递给用户
+func deliverEvent(uuid string, event interface{}) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		zlog.Error(err.Error())
+		return
+	}
+	ChatServer.Deliver(uuid, dispatcher.OutboundMessage{Payload: payload})
+}
+
+// DeliverToUser 把事件投递给单个用户
+func DeliverToUser(uuid string, event interface{}) {
+	deliverEvent(uuid, event)
+}
+
+// DeliverToConversation 把事件投递给会话中的所有用户，单聊是双方，群聊是全部成员
+func DeliverToConversation(sendId string, receiveId string, event interface{}) {
+	if receiveId[0] == 'G' {
+		members, err := gorm.GroupInfoService.GetGroupMemberIds(receiveId)
+		if err != nil {
+			zlog.Error(err.Error())
+			return
+		}
+		for _, member := range members {
+			deliverEvent(member, event)
+		}
+		return
+	}
+	deliverEvent(receiveId, event)
+	deliverEvent(sendId, event)
+}
+
+// DeliverToGroupManagers 把事件投递给群主和管理员
+func DeliverToGroupManagers(groupId string, event interface{}) {
+	managers, err := gorm.GroupInfoService.GetGroupManagerIds(groupId)
+	if err != nil {
+		zlog.Error(err.Error())
+		return
+	}
+	for _, manager := range managers {
+		deliverEvent(manager, event)
+	}
+}
+
+// PublishMessages 把服务端生成的聊天消息交给消息流水线，和客户端发来的消息走同样的处理
+func PublishMessages(chatMessageReqs []request.ChatMessageRequest) error {
+	var errs []error
+	for _, chatMessageReq := range chatMessageReqs {
+		jsonMessage, err := json.Marshal(chatMessageReq)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := ChatServer.Publish(jsonMessage); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// read 更新已读进度，单聊时通知对方，群聊的已读人数由对方按需查询
+func (c *Client) read(readReq request.WsReadRequest) {
+	message, readMessage, ret := gorm.MessageService.ReadMessages(c.Uuid, readReq.ConversationId, readReq.MessageUuid)
+	if ret != 0 {
+		zlog.Error(message)
+		return
+	}
+	if readMessage.ConversationId[0] == 'G' {
+		return
+	}
+	peerId := readMessage.SendId
+	if peerId == c.Uuid {
+		peerId = readMessage.ReceiveId
+	}
+	deliverEvent(peerId, respond.ReadEventRespond{
+		Event:          ws_event_enum.Read,
+		ConversationId: readMessage.ConversationId,
+		ReaderId:       c.Uuid,
+		MessageUuid:    readMessage.Uuid,
+		Seq:            readMessage.Seq,
+	})
+}
+
+// typing 输入状态转发给单聊对方或群里的其他成员
+func (c *Client) typing(typingReq request.WsTypingRequest) {
+	if typingReq.ReceiveId == "" {
+		return
+	}
+	event := respond.TypingEventRespond{
+		Event:     typingReq.Event,
+		SendId:    c.Uuid,
+		ReceiveId: typingReq.ReceiveId,
+	}
+	if typingReq.ReceiveId[0] == 'G' {
+		members, err := gorm.GroupInfoService.GetGroupMemberIds(typingReq.ReceiveId)
+		if err != nil {
+			zlog.Error(err.Error())
+			return
+		}
+		if !contains(members, c.Uuid) {
+			zlog.Error(fmt.Sprintf("用户%s不在群聊%s中", c.Uuid, typingReq.ReceiveId))
+			return
+		}
+		for _, member := range members {
+			if member != c.Uuid {
+				deliverEvent(member, event)
+			}
+		}
+		return
+	}
+	// 对方把自己删除或拉黑后不再转发
+	ok, err := gorm.UserContactService.IsNormalContact(typingReq.ReceiveId, c.Uuid)
+	if err != nil {
+		zlog.Error(err.Error())
+		return
+	}
+	if ok {
+		deliverEvent(typingReq.ReceiveId, event)
+	}
+}
+
+// broadcastPresence 把在线状态变化广播给联系人
+func broadcastPresence(uuid string, status string) {
+	audience, err := gorm.UserContactService.GetContactAudience(uuid)
+	if err != nil {
+		zlog.Error(err.Error())
+		return
+	}
+	event := respond.PresenceEventRespond{
+		Event:    ws_event_enum.Presence,
+		UserId:   uuid,
+		Status:   status,
+		ChangeAt: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	for _, userId := range audience {
+		deliverEvent(userId, event)
+	}
+}
+
+func contains(list []string, target string) bool {
+	for _, item := range list {
+		if item == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"io"
+	"kama_chat_server/internal/config"
+	myKafka "kama_chat_server/internal/service/kafka"
+	"kama_chat_server/pkg/zlog"
+	"strconv"
+)
+
+// kafkaTransport kafka模式，读写的reader和writer由kafka.KafkaService统一初始化和关闭
+type kafkaTransport struct {
+}
+
+func (t *kafkaTransport) Publish(data []byte) error {
+	return myKafka.KafkaService.ChatWriter.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(strconv.Itoa(config.GetConfig().KafkaConfig.Partition)),
+		Value: data,
+	})
+}
+
+func (t *kafkaTransport) Consume(handler func(data []byte)) {
+	for {
+		kafkaMessage, err := myKafka.KafkaService.ChatReader.ReadMessage(ctx)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				// reader已关闭
+				return
+			}
+			zlog.Error(err.Error())
+			continue
+		}
+		zlog.Info(fmt.Sprintf("topic=%s, partition=%d, offset=%d, key=%s, value=%s", kafkaMessage.Topic, kafkaMessage.Partition, kafkaMessage.Offset, kafkaMessage.Key, kafkaMessage.Value))
+		handler(kafkaMessage.Value)
+	}
+}
+
+func (t *kafkaTransport) Close() {
+}
@@ -0,0 +1,96 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"kama_chat_server/internal/config"
+	myredis "kama_chat_server/internal/service/redis"
+	"kama_chat_server/pkg/constants"
+	"kama_chat_server/pkg/zlog"
+	"os"
+	"time"
+)
+
+// 多实例部署时，用户的websocket连接只会落在其中一个节点上
+// redis中记录 用户 -> 节点 的在线状态，投递时本机不在线就查出所在节点，通过redis pub/sub转发给该节点
+
+// nodeId 当前实例的唯一标识
+var nodeId = getNodeId()
+
+func getNodeId() string {
+	mainConfig := config.GetConfig().MainConfig
+	if mainConfig.NodeId != "" {
+		return mainConfig.NodeId
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = mainConfig.Host
+	}
+	return fmt.Sprintf("%s:%d", hostname, mainConfig.Port)
+}
+
+// remoteDelivery 跨节点转发的投递请求
+type remoteDelivery struct {
+	Uuid           string `json:"uuid"`
+	MessageUuid    string `json:"message_uuid"`
+	ConversationId string `json:"conversation_id"`
+	Seq            int64  `json:"seq"`
+	Payload        []byte `json:"payload"`
+}
+
+func presenceKey(uuid string) string {
+	return "online_node_" + uuid
+}
+
+func nodeChannel(node string) string {
+	return "chat_node_" + node
+}
+
+// registerPresence 记录用户连接在当前节点，需要定时刷新，节点宕机后自动过期
+func registerPresence(uuid string) {
+	if err := myredis.SetKeyEx(presenceKey(uuid), nodeId, time.Second*constants.PRESENCE_TTL); err != nil {
+		zlog.Error(err.Error())
+	}
+}
+
+// unregisterPresence 删除用户在当前节点的在线状态，用户已经重连到其他节点的不删
+func unregisterPresence(uuid string) {
+	if err := myredis.DelKeyIfValueEquals(presenceKey(uuid), nodeId); err != nil {
+		zlog.Error(err.Error())
+	}
+}
+
+// lookupPresence 查询用户所在节点，不在线返回空字符串
+func lookupPresence(uuid string) (string, error) {
+	node, err := myredis.GetKeyNilIsErr(presenceKey(uuid))
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", nil
+		}
+		return "", err
+	}
+	return node, nil
+}
+
+// publishToNode 把投递请求转发给用户所在节点
+func publishToNode(node string, delivery remoteDelivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return myredis.Publish(nodeChannel(node), string(data))
+}
+
+// subscribeNode 订阅当前节点的频道，收到的投递请求交给handler，订阅关闭后返回
+func subscribeNode(pubSub *redis.PubSub, handler func(delivery remoteDelivery)) {
+	for msg := range pubSub.Channel() {
+		var delivery remoteDelivery
+		if err := json.Unmarshal([]byte(msg.Payload), &delivery); err != nil {
+			zlog.Error(err.Error())
+			continue
+		}
+		handler(delivery)
+	}
+}
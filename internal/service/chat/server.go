@@ -2,32 +2,28 @@ package chat
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
-	"github.com/gorilla/websocket"
-	"kama_chat_server/internal/dao"
-	"kama_chat_server/internal/dto/request"
 	"kama_chat_server/internal/dto/respond"
-	"kama_chat_server/internal/model"
+	"kama_chat_server/internal/service/chat/dispatcher"
+	"kama_chat_server/internal/service/gorm"
 	myredis "kama_chat_server/internal/service/redis"
 	"kama_chat_server/pkg/constants"
-	"kama_chat_server/pkg/enum/message/message_status_enum"
-	"kama_chat_server/pkg/enum/message/message_type_enum"
-	"kama_chat_server/pkg/util/random"
+	"kama_chat_server/pkg/enum/message/ws_event_enum"
+	"kama_chat_server/pkg/enum/user_info/online_status_enum"
 	"kama_chat_server/pkg/zlog"
-	"log"
-	"strings"
 	"sync"
 	"time"
 )
 
 type Server struct {
-	Clients  map[string]*Client
-	mutex    *sync.Mutex
-	Transmit chan []byte  // 转发通道
-	Login    chan *Client // 登录通道
-	Logout   chan *Client // 退出登录通道
+	Clients    map[string]*Client
+	mutex      *sync.Mutex
+	Login      chan *Client // 登录通道
+	Logout     chan *Client // 退出登录通道
+	transport  Transport    // 消息接入，channel或kafka
+	dispatcher *dispatcher.Dispatcher
+	pubSub     *redis.PubSub // 订阅其他节点转发过来的投递请求
 }
 
 var ChatServer *Server
@@ -35,474 +31,242 @@ var ChatServer *Server
 func init() {
 	if ChatServer == nil {
 		ChatServer = &Server{
-			Clients:  make(map[string]*Client),
-			mutex:    &sync.Mutex{},
-			Transmit: make(chan []byte, constants.CHANNEL_SIZE),
-			Login:    make(chan *Client, constants.CHANNEL_SIZE),
-			Logout:   make(chan *Client, constants.CHANNEL_SIZE),
+			Clients:   make(map[string]*Client),
+			mutex:     &sync.Mutex{},
+			Login:     make(chan *Client, constants.CHANNEL_SIZE),
+			Logout:    make(chan *Client, constants.CHANNEL_SIZE),
+			transport: newTransport(messageMode),
 		}
+		ChatServer.dispatcher = dispatcher.NewDispatcher(&gormStore{}, &redisCache{}, ChatServer)
 	}
 }
 
-// 将https://127.0.0.1:8000/static/xxx 转为 /static/xxx
-func normalizePath(path string) string {
-	// 查找 "/static/" 的位置
-	if path == "https://cube.elemecdn.com/0/88/03b0d39583f48206768a7534e55bcpng.png" {
-		return path
-	}
-	staticIndex := strings.Index(path, "/static/")
-	if staticIndex < 0 {
-		log.Println(path)
-		zlog.Error("路径不合法")
-	}
-	// 返回从 "/static/" 开始的部分
-	return path[staticIndex:]
-}
-
 // Start 启动函数，Server端用主进程起，Client端可以用协程起
 func (s *Server) Start() {
 	defer func() {
-		close(s.Transmit)
-		close(s.Logout)
-		close(s.Login)
+		if r := recover(); r != nil {
+			zlog.Error(fmt.Sprintf("chat server panic: %v", r))
+		}
 	}()
+
+	// 消费transport中的聊天消息，统一交给dispatcher处理
+	go s.transport.Consume(s.handle)
+
+	// 接收其他节点转发过来的投递请求
+	s.pubSub = myredis.Subscribe(nodeChannel(nodeId))
+	go subscribeNode(s.pubSub, func(delivery remoteDelivery) {
+		s.deliverLocal(delivery.Uuid, dispatcher.OutboundMessage{
+			MessageUuid:    delivery.MessageUuid,
+			ConversationId: delivery.ConversationId,
+			Seq:            delivery.Seq,
+			Payload:        delivery.Payload,
+		})
+	})
+
+	// 在线状态有过期时间，定时刷新本机在线用户
+	ticker := time.NewTicker(time.Second * constants.PRESENCE_TTL / 3)
+	defer ticker.Stop()
+
 	for {
 		select {
-		case client := <-s.Login:
-			{
-				s.mutex.Lock()
-				s.Clients[client.Uuid] = client
-				s.mutex.Unlock()
-				zlog.Debug(fmt.Sprintf("欢迎来到kama聊天服务器，亲爱的用户%s\n", client.Uuid))
-				err := client.Conn.WriteMessage(websocket.TextMessage, []byte("欢迎来到kama聊天服务器"))
-				if err != nil {
-					zlog.Error(err.Error())
-				}
+		case client, ok := <-s.Login:
+			if !ok {
+				return
 			}
+			s.mutex.Lock()
+			s.Clients[client.Uuid] = client
+			s.mutex.Unlock()
+			registerPresence(client.Uuid)
+			go s.online(client.Uuid)
+			zlog.Debug(fmt.Sprintf("欢迎来到kama聊天服务器，亲爱的用户%s\n", client.Uuid))
+			s.deliverToClient(client, &MessageBack{Message: []byte("欢迎来到kama聊天服务器")})
 
-		case client := <-s.Logout:
-			{
-				s.mutex.Lock()
-				delete(s.Clients, client.Uuid)
-				s.mutex.Unlock()
-				zlog.Info(fmt.Sprintf("用户%s退出登录\n", client.Uuid))
-				if err := client.Conn.WriteMessage(websocket.TextMessage, []byte("已退出登录")); err != nil {
+		case client, ok := <-s.Logout:
+			if !ok {
+				return
+			}
+			zlog.Info(fmt.Sprintf("用户%s退出登录\n", client.Uuid))
+			// 由Write协程发送登出提示后断开，已经断开或发送缓冲已满时直接断开
+			if !s.deliverToClient(client, &MessageBack{Message: []byte("已退出登录"), Close: true}) {
+				if err := client.close(); err != nil {
 					zlog.Error(err.Error())
 				}
 			}
 
-		case data := <-s.Transmit:
-			{
-				var chatMessageReq request.ChatMessageRequest
-				if err := json.Unmarshal(data, &chatMessageReq); err != nil {
-					zlog.Error(err.Error())
-				}
-				// log.Println("原消息为：", data, "反序列化后为：", chatMessageReq)
-				if chatMessageReq.Type == message_type_enum.Text {
-					// 存message
-					message := model.Message{
-						Uuid:       fmt.Sprintf("M%s", random.GetNowAndLenRandomString(11)),
-						SessionId:  chatMessageReq.SessionId,
-						Type:       chatMessageReq.Type,
-						Content:    chatMessageReq.Content,
-						Url:        "",
-						SendId:     chatMessageReq.SendId,
-						SendName:   chatMessageReq.SendName,
-						SendAvatar: chatMessageReq.SendAvatar,
-						ReceiveId:  chatMessageReq.ReceiveId,
-						FileSize:   "0B",
-						FileType:   "",
-						FileName:   "",
-						Status:     message_status_enum.Unsent,
-						CreatedAt:  time.Now(),
-						AVdata:     "",
-					}
-					// 对SendAvatar去除前面/static之前的所有内容，防止ip前缀引入
-					message.SendAvatar = normalizePath(message.SendAvatar)
-					if res := dao.GormDB.Create(&message); res.Error != nil {
-						zlog.Error(res.Error.Error())
-					}
-					if message.ReceiveId[0] == 'U' { // 发送给User
-						// 如果能找到ReceiveId，说明在线，可以发送，否则存表后跳过
-						// 因为在线的时候是通过websocket更新消息记录的，离线后通过存表，登录时只调用一次数据库操作
-						// 切换chat对象后，前端的messageList也会改变，获取messageList从第二次就是从redis中获取
-						messageRsp := respond.GetMessageListRespond{
-							SendId:     message.SendId,
-							SendName:   message.SendName,
-							SendAvatar: chatMessageReq.SendAvatar,
-							ReceiveId:  message.ReceiveId,
-							Type:       message.Type,
-							Content:    message.Content,
-							Url:        message.Url,
-							FileSize:   message.FileSize,
-							FileName:   message.FileName,
-							FileType:   message.FileType,
-							CreatedAt:  message.CreatedAt.Format("2006-01-02 15:04:05"),
-						}
-						jsonMessage, err := json.Marshal(messageRsp)
-						if err != nil {
-							zlog.Error(err.Error())
-						}
-						log.Println("返回的消息为：", messageRsp, "序列化后为：", jsonMessage)
-						var messageBack = &MessageBack{
-							Message: jsonMessage,
-							Uuid:    message.Uuid,
-						}
-						s.mutex.Lock()
-						if receiveClient, ok := s.Clients[message.ReceiveId]; ok {
-							//messageBack.Message = jsonMessage
-							//messageBack.Uuid = message.Uuid
-							receiveClient.SendBack <- messageBack // 向client.Send发送
-						}
-						// 因为send_id肯定在线，所以这里在后端进行在线回显message，其实优化的话前端可以直接回显
-						// 问题在于前后端的req和rsp结构不同，前端存储message的messageList不能存req，只能存rsp
-						// 所以这里后端进行回显，前端不回显
-						sendClient := s.Clients[message.SendId]
-						sendClient.SendBack <- messageBack
-						s.mutex.Unlock()
+		case <-ticker.C:
+			for _, uuid := range s.localUuids() {
+				registerPresence(uuid)
+			}
+		}
+	}
+}
 
-						// redis
-						var rspString string
-						rspString, err = myredis.GetKeyNilIsErr("message_list_" + message.SendId + "_" + message.ReceiveId)
-						if err == nil {
-							var rsp []respond.GetMessageListRespond
-							if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
-								zlog.Error(err.Error())
-							}
-							rsp = append(rsp, messageRsp)
-							rspByte, err := json.Marshal(rsp)
-							if err != nil {
-								zlog.Error(err.Error())
-							}
-							if err := myredis.SetKeyEx("message_list_"+message.SendId+"_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
-								zlog.Error(err.Error())
-							}
-						} else {
-							if !errors.Is(err, redis.Nil) {
-								zlog.Error(err.Error())
-							}
-						}
+// handle 处理一条聊天消息，单条消息出错不影响后续消息
+func (s *Server) handle(data []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			zlog.Error(fmt.Sprintf("dispatch panic: %v", r))
+		}
+	}()
+	if err := s.dispatcher.Dispatch(data); err != nil {
+		zlog.Error(err.Error())
+	}
+}
 
-					} else if message.ReceiveId[0] == 'G' { // 发送给Group
-						messageRsp := respond.GetGroupMessageListRespond{
-							SendId:     message.SendId,
-							SendName:   message.SendName,
-							SendAvatar: chatMessageReq.SendAvatar,
-							ReceiveId:  message.ReceiveId,
-							Type:       message.Type,
-							Content:    message.Content,
-							Url:        message.Url,
-							FileSize:   message.FileSize,
-							FileName:   message.FileName,
-							FileType:   message.FileType,
-							CreatedAt:  message.CreatedAt.Format("2006-01-02 15:04:05"),
-						}
-						jsonMessage, err := json.Marshal(messageRsp)
-						if err != nil {
-							zlog.Error(err.Error())
-						}
-						log.Println("返回的消息为：", messageRsp, "序列化后为：", jsonMessage)
-						var messageBack = &MessageBack{
-							Message: jsonMessage,
-							Uuid:    message.Uuid,
-						}
-						var group model.GroupInfo
-						if res := dao.GormDB.Where("uuid = ?", message.ReceiveId).First(&group); res.Error != nil {
-							zlog.Error(res.Error.Error())
-						}
-						var members []string
-						if err := json.Unmarshal(group.Members, &members); err != nil {
-							zlog.Error(err.Error())
-						}
-						s.mutex.Lock()
-						for _, member := range members {
-							if member != message.SendId {
-								if receiveClient, ok := s.Clients[member]; ok {
-									receiveClient.SendBack <- messageBack
-								}
-							} else {
-								sendClient := s.Clients[message.SendId]
-								sendClient.SendBack <- messageBack
-							}
-						}
-						s.mutex.Unlock()
+// Deliver 投递给在线用户，连接在其他节点的转发给该节点，不在线的直接跳过
+func (s *Server) Deliver(uuid string, message dispatcher.OutboundMessage) {
+	if s.deliverLocal(uuid, message) {
+		return
+	}
+	node, err := lookupPresence(uuid)
+	if err != nil {
+		zlog.Error(err.Error())
+		return
+	}
+	if node == "" || node == nodeId {
+		return
+	}
+	if err := publishToNode(node, remoteDelivery{
+		Uuid:           uuid,
+		MessageUuid:    message.MessageUuid,
+		ConversationId: message.ConversationId,
+		Seq:            message.Seq,
+		Payload:        message.Payload,
+	}); err != nil {
+		zlog.Error(err.Error())
+	}
+}
+
+// deliverLocal 投递给本机在线的用户，用户不在本机返回false
+func (s *Server) deliverLocal(uuid string, message dispatcher.OutboundMessage) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	client, ok := s.Clients[uuid]
+	if !ok {
+		return false
+	}
+	if !enqueue(client, &MessageBack{
+		Message:        message.Payload,
+		Uuid:           message.MessageUuid,
+		ConversationId: message.ConversationId,
+		Seq:            message.Seq,
+	}) {
+		// 客户端写得太慢，不阻塞其他用户的投递，消息已经存表，可以重新拉取
+		zlog.Error(fmt.Sprintf("用户%s的发送缓冲已满，消息%s未投递", uuid, message.MessageUuid))
+	}
+	return true
+}
 
-						// redis
-						var rspString string
-						rspString, err = myredis.GetKeyNilIsErr("group_messagelist_" + message.ReceiveId)
-						if err == nil {
-							var rsp []respond.GetGroupMessageListRespond
-							if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
-								zlog.Error(err.Error())
-							}
-							rsp = append(rsp, messageRsp)
-							rspByte, err := json.Marshal(rsp)
-							if err != nil {
-								zlog.Error(err.Error())
-							}
-							if err := myredis.SetKeyEx("group_messagelist_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
-								zlog.Error(err.Error())
-							}
-						} else {
-							if !errors.Is(err, redis.Nil) {
-								zlog.Error(err.Error())
-							}
-						}
-					}
-				} else if chatMessageReq.Type == message_type_enum.File {
-					// 存message
-					message := model.Message{
-						Uuid:       fmt.Sprintf("M%s", random.GetNowAndLenRandomString(11)),
-						SessionId:  chatMessageReq.SessionId,
-						Type:       chatMessageReq.Type,
-						Content:    "",
-						Url:        chatMessageReq.Url,
-						SendId:     chatMessageReq.SendId,
-						SendName:   chatMessageReq.SendName,
-						SendAvatar: chatMessageReq.SendAvatar,
-						ReceiveId:  chatMessageReq.ReceiveId,
-						FileSize:   chatMessageReq.FileSize,
-						FileType:   chatMessageReq.FileType,
-						FileName:   chatMessageReq.FileName,
-						Status:     message_status_enum.Unsent,
-						CreatedAt:  time.Now(),
-						AVdata:     "",
-					}
-					// 对SendAvatar去除前面/static之前的所有内容，防止ip前缀引入
-					message.SendAvatar = normalizePath(message.SendAvatar)
-					if res := dao.GormDB.Create(&message); res.Error != nil {
-						zlog.Error(res.Error.Error())
-					}
-					if message.ReceiveId[0] == 'U' { // 发送给User
-						// 如果能找到ReceiveId，说明在线，可以发送，否则存表后跳过
-						// 因为在线的时候是通过websocket更新消息记录的，离线后通过存表，登录时只调用一次数据库操作
-						// 切换chat对象后，前端的messageList也会改变，获取messageList从第二次就是从redis中获取
-						messageRsp := respond.GetMessageListRespond{
-							SendId:     message.SendId,
-							SendName:   message.SendName,
-							SendAvatar: chatMessageReq.SendAvatar,
-							ReceiveId:  message.ReceiveId,
-							Type:       message.Type,
-							Content:    message.Content,
-							Url:        message.Url,
-							FileSize:   message.FileSize,
-							FileName:   message.FileName,
-							FileType:   message.FileType,
-							CreatedAt:  message.CreatedAt.Format("2006-01-02 15:04:05"),
-						}
-						jsonMessage, err := json.Marshal(messageRsp)
-						if err != nil {
-							zlog.Error(err.Error())
-						}
-						log.Println("返回的消息为：", messageRsp, "序列化后为：", jsonMessage)
-						var messageBack = &MessageBack{
-							Message: jsonMessage,
-							Uuid:    message.Uuid,
-						}
-						s.mutex.Lock()
-						if receiveClient, ok := s.Clients[message.ReceiveId]; ok {
-							//messageBack.Message = jsonMessage
-							//messageBack.Uuid = message.Uuid
-							receiveClient.SendBack <- messageBack // 向client.Send发送
-						}
-						// 因为send_id肯定在线，所以这里在后端进行在线回显message，其实优化的话前端可以直接回显
-						// 问题在于前后端的req和rsp结构不同，前端存储message的messageList不能存req，只能存rsp
-						// 所以这里后端进行回显，前端不回显
-						sendClient := s.Clients[message.SendId]
-						sendClient.SendBack <- messageBack
-						s.mutex.Unlock()
+// deliverToClient 放进这个连接的发送通道，连接已经断开或发送缓冲已满时返回false
+// 持锁判断连接仍在在线列表中，close先移出在线列表再关闭SendBack，所以不会写入已关闭的通道
+func (s *Server) deliverToClient(client *Client, messageBack *MessageBack) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if current, ok := s.Clients[client.Uuid]; !ok || current != client {
+		return false
+	}
+	return enqueue(client, messageBack)
+}
 
-						// redis
-						var rspString string
-						rspString, err = myredis.GetKeyNilIsErr("message_list_" + message.SendId + "_" + message.ReceiveId)
-						if err == nil {
-							var rsp []respond.GetMessageListRespond
-							if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
-								zlog.Error(err.Error())
-							}
-							rsp = append(rsp, messageRsp)
-							rspByte, err := json.Marshal(rsp)
-							if err != nil {
-								zlog.Error(err.Error())
-							}
-							if err := myredis.SetKeyEx("message_list_"+message.SendId+"_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
-								zlog.Error(err.Error())
-							}
-						} else {
-							if !errors.Is(err, redis.Nil) {
-								zlog.Error(err.Error())
-							}
-						}
-					} else {
-						messageRsp := respond.GetGroupMessageListRespond{
-							SendId:     message.SendId,
-							SendName:   message.SendName,
-							SendAvatar: chatMessageReq.SendAvatar,
-							ReceiveId:  message.ReceiveId,
-							Type:       message.Type,
-							Content:    message.Content,
-							Url:        message.Url,
-							FileSize:   message.FileSize,
-							FileName:   message.FileName,
-							FileType:   message.FileType,
-							CreatedAt:  message.CreatedAt.Format("2006-01-02 15:04:05"),
-						}
-						jsonMessage, err := json.Marshal(messageRsp)
-						if err != nil {
-							zlog.Error(err.Error())
-						}
-						log.Println("返回的消息为：", messageRsp, "序列化后为：", jsonMessage)
-						var messageBack = &MessageBack{
-							Message: jsonMessage,
-							Uuid:    message.Uuid,
-						}
-						var group model.GroupInfo
-						if res := dao.GormDB.Where("uuid = ?", message.ReceiveId).First(&group); res.Error != nil {
-							zlog.Error(res.Error.Error())
-						}
-						var members []string
-						if err := json.Unmarshal(group.Members, &members); err != nil {
-							zlog.Error(err.Error())
-						}
-						s.mutex.Lock()
-						for _, member := range members {
-							if member != message.SendId {
-								if receiveClient, ok := s.Clients[member]; ok {
-									receiveClient.SendBack <- messageBack
-								}
-							} else {
-								sendClient := s.Clients[message.SendId]
-								sendClient.SendBack <- messageBack
-							}
-						}
-						s.mutex.Unlock()
+// enqueue 不阻塞地放进发送通道，调用方需要持有s.mutex
+func enqueue(client *Client, messageBack *MessageBack) bool {
+	select {
+	case client.SendBack <- messageBack:
+		return true
+	default:
+		return false
+	}
+}
 
-						// redis
-						var rspString string
-						rspString, err = myredis.GetKeyNilIsErr("group_messagelist_" + message.ReceiveId)
-						if err == nil {
-							var rsp []respond.GetGroupMessageListRespond
-							if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
-								zlog.Error(err.Error())
-							}
-							rsp = append(rsp, messageRsp)
-							rspByte, err := json.Marshal(rsp)
-							if err != nil {
-								zlog.Error(err.Error())
-							}
-							if err := myredis.SetKeyEx("group_messagelist_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
-								zlog.Error(err.Error())
-							}
-						} else {
-							if !errors.Is(err, redis.Nil) {
-								zlog.Error(err.Error())
-							}
-						}
-					}
-				} else if chatMessageReq.Type == message_type_enum.AudioOrVideo {
-					var avData request.AVData
-					if err := json.Unmarshal([]byte(chatMessageReq.AVdata), &avData); err != nil {
-						zlog.Error(err.Error())
-					}
-					//log.Println(avData)
-					message := model.Message{
-						Uuid:       fmt.Sprintf("M%s", random.GetNowAndLenRandomString(11)),
-						SessionId:  chatMessageReq.SessionId,
-						Type:       chatMessageReq.Type,
-						Content:    "",
-						Url:        "",
-						SendId:     chatMessageReq.SendId,
-						SendName:   chatMessageReq.SendName,
-						SendAvatar: chatMessageReq.SendAvatar,
-						ReceiveId:  chatMessageReq.ReceiveId,
-						FileSize:   "",
-						FileType:   "",
-						FileName:   "",
-						Status:     message_status_enum.Unsent,
-						CreatedAt:  time.Now(),
-						AVdata:     chatMessageReq.AVdata,
-					}
-					if avData.MessageId == "PROXY" && (avData.Type == "start_call" || avData.Type == "receive_call" || avData.Type == "reject_call") {
-						// 存message
-						// 对SendAvatar去除前面/static之前的所有内容，防止ip前缀引入
-						message.SendAvatar = normalizePath(message.SendAvatar)
-						if res := dao.GormDB.Create(&message); res.Error != nil {
-							zlog.Error(res.Error.Error())
-						}
-					}
+// online 用户上线：记录上线时间，广播在线状态，推送未送达消息概况
+func (s *Server) online(uuid string) {
+	if message, ret := gorm.UserInfoService.SetLastOnlineAt(uuid, time.Now()); ret != 0 {
+		zlog.Error(message)
+	}
+	broadcastPresence(uuid, online_status_enum.Online)
+	s.pushPendingSummary(uuid)
+}
 
-					if chatMessageReq.ReceiveId[0] == 'U' { // 发送给User
-						// 如果能找到ReceiveId，说明在线，可以发送，否则存表后跳过
-						// 因为在线的时候是通过websocket更新消息记录的，离线后通过存表，登录时只调用一次数据库操作
-						// 切换chat对象后，前端的messageList也会改变，获取messageList从第二次就是从redis中获取
-						messageRsp := respond.AVMessageRespond{
-							SendId:     message.SendId,
-							SendName:   message.SendName,
-							SendAvatar: message.SendAvatar,
-							ReceiveId:  message.ReceiveId,
-							Type:       message.Type,
-							Content:    message.Content,
-							Url:        message.Url,
-							FileSize:   message.FileSize,
-							FileName:   message.FileName,
-							FileType:   message.FileType,
-							CreatedAt:  message.CreatedAt.Format("2006-01-02 15:04:05"),
-							AVdata:     message.AVdata,
-						}
-						jsonMessage, err := json.Marshal(messageRsp)
-						if err != nil {
-							zlog.Error(err.Error())
-						}
-						// log.Println("返回的消息为：", messageRsp, "序列化后为：", jsonMessage)
-						log.Println("返回的消息为：", messageRsp)
-						var messageBack = &MessageBack{
-							Message: jsonMessage,
-							Uuid:    message.Uuid,
-						}
-						s.mutex.Lock()
-						if receiveClient, ok := s.Clients[message.ReceiveId]; ok {
-							//messageBack.Message = jsonMessage
-							//messageBack.Uuid = message.Uuid
-							receiveClient.SendBack <- messageBack // 向client.Send发送
-						}
-						// 通话这不能回显，发回去的话就会出现两个start_call。
-						//sendClient := s.Clients[message.SendId]
-						//sendClient.SendBack <- messageBack
-						s.mutex.Unlock()
-					}
-				}
+// pushPendingSummary 登录后推送每个会话未送达消息的概况，客户端据此按会话补齐消息
+func (s *Server) pushPendingSummary(uuid string) {
+	message, sessions, ret := gorm.MessageService.GetPendingSummary(uuid)
+	if ret != 0 {
+		zlog.Error(message)
+		return
+	}
+	payload, err := json.Marshal(respond.PendingSummaryRespond{
+		Event:    ws_event_enum.PendingSummary,
+		Sessions: sessions,
+	})
+	if err != nil {
+		zlog.Error(err.Error())
+		return
+	}
+	s.deliverLocal(uuid, dispatcher.OutboundMessage{Payload: payload})
+}
 
-			}
-		}
+// localUuids 本机在线的用户
+func (s *Server) localUuids() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	uuids := make([]string, 0, len(s.Clients))
+	for uuid := range s.Clients {
+		uuids = append(uuids, uuid)
 	}
+	return uuids
+}
+
+// Publish 把客户端发来的消息交给transport
+func (s *Server) Publish(data []byte) error {
+	return s.transport.Publish(data)
 }
 
 func (s *Server) Close() {
 	close(s.Login)
 	close(s.Logout)
-	close(s.Transmit)
+	s.transport.Close()
+	if s.pubSub != nil {
+		if err := s.pubSub.Close(); err != nil {
+			zlog.Error(err.Error())
+		}
+	}
+	for _, uuid := range s.localUuids() {
+		unregisterPresence(uuid)
+	}
 }
 
 func (s *Server) SendClientToLogin(client *Client) {
-	s.mutex.Lock()
 	s.Login <- client
-	s.mutex.Unlock()
 }
 
 func (s *Server) SendClientToLogout(client *Client) {
-	s.mutex.Lock()
 	s.Logout <- client
-	s.mutex.Unlock()
 }
 
-func (s *Server) SendMessageToTransmit(message []byte) {
+// removeClientIfSame 只移除这个连接，用户已经重连的新连接不受影响
+func (s *Server) removeClientIfSame(client *Client) {
 	s.mutex.Lock()
-	s.Transmit <- message
+	current, ok := s.Clients[client.Uuid]
+	if !ok || current != client {
+		s.mutex.Unlock()
+		return
+	}
+	delete(s.Clients, client.Uuid)
 	s.mutex.Unlock()
+	unregisterPresence(client.Uuid)
 }
 
 func (s *Server) RemoveClient(uuid string) {
 	s.mutex.Lock()
 	delete(s.Clients, uuid)
 	s.mutex.Unlock()
+	unregisterPresence(uuid)
+}
+
+// GetClient 获取本机在线的客户端
+func (s *Server) GetClient(uuid string) (*Client, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	client, ok := s.Clients[uuid]
+	return client, ok
 }
@@ -0,0 +1,125 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"kama_chat_server/internal/dao"
+	"kama_chat_server/internal/model"
+	"kama_chat_server/internal/service/chat/dispatcher"
+	"kama_chat_server/internal/service/gorm"
+	myredis "kama_chat_server/internal/service/redis"
+	"kama_chat_server/pkg/constants"
+	"strconv"
+	"time"
+)
+
+// gormStore 消息流水线的持久化实现
+type gormStore struct {
+}
+
+func (g *gormStore) SaveMessage(message *model.Message) error {
+	if err := dao.GormDB.Create(message).Error; err != nil {
+		return err
+	}
+	gorm.SearchService.IndexMessage(message)
+	return nil
+}
+
+func (g *gormStore) GetGroupMembers(groupId string) ([]string, error) {
+	return gorm.GroupInfoService.GetGroupMemberIds(groupId)
+}
+
+func (g *gormStore) GetMessage(uuid string) (*model.Message, error) {
+	var message model.Message
+	if res := dao.GormDB.Where("uuid = ?", uuid).First(&message); res.Error != nil {
+		return nil, res.Error
+	}
+	return &message, nil
+}
+
+func (g *gormStore) GetVoiceUpload(fileName string, userId string) (*model.VoiceUpload, error) {
+	var upload model.VoiceUpload
+	if res := dao.GormDB.Where("file_name = ? AND user_id = ?", fileName, userId).First(&upload); res.Error != nil {
+		return nil, res.Error
+	}
+	return &upload, nil
+}
+
+func (g *gormStore) IsGroupOwner(groupId string, userId string) (bool, error) {
+	return gorm.GroupInfoService.IsGroupOwner(groupId, userId)
+}
+
+func (g *gormStore) GetMuteState(groupId string, userId string) (dispatcher.MuteState, error) {
+	return gorm.GroupInfoService.GetMuteState(groupId, userId)
+}
+
+func (g *gormStore) SaveMentions(message *model.Message, userIds []string) error {
+	mentionList := make([]model.MessageMention, 0, len(userIds))
+	for _, userId := range userIds {
+		mentionList = append(mentionList, model.MessageMention{
+			MessageUuid: message.Uuid,
+			UserId:      userId,
+			GroupId:     message.ReceiveId,
+			Seq:         message.Seq,
+			CreatedAt:   message.CreatedAt,
+		})
+	}
+	return dao.GormDB.Create(&mentionList).Error
+}
+
+func (g *gormStore) GetMutedUsers(receiveId string, userIds []string) (map[string]bool, error) {
+	return gorm.SessionService.GetMutedUsers(receiveId, userIds)
+}
+
+// NextSeq 会话序号保存在redis中原子自增，key不存在时先用数据库中的最大序号初始化
+func (g *gormStore) NextSeq(conversationId string) (int64, error) {
+	key := "message_seq_" + conversationId
+	if _, err := myredis.GetKeyNilIsErr(key); err != nil {
+		if !errors.Is(err, redis.Nil) {
+			return 0, err
+		}
+		var maxSeq int64
+		if res := dao.GormDB.Model(&model.Message{}).Where("conversation_id = ?", conversationId).Select("COALESCE(MAX(seq), 0)").Scan(&maxSeq); res.Error != nil {
+			return 0, res.Error
+		}
+		// 多个节点同时初始化时只有一个能写入，之后的自增都基于同一个值
+		if _, err := myredis.SetKeyNX(key, strconv.FormatInt(maxSeq, 10), 0); err != nil {
+			return 0, err
+		}
+	}
+	return myredis.Incr(key)
+}
+
+// ResetSeqCounters 删除redis中所有会话的序号计数器，之后NextSeq会用数据库中的最大序号重新初始化
+// 数据库中的序号被迁移改动之后调用，需要在消息流水线启动之前执行
+func ResetSeqCounters() error {
+	return myredis.DelKeysWithPrefix("message_seq_")
+}
+
+// UpdateSessions 单聊更新双方的会话，群聊更新所有成员的群聊会话
+func (g *gormStore) UpdateSessions(message *model.Message, preview string) error {
+	query := dao.GormDB.Model(&model.Session{})
+	if message.ReceiveId[0] == 'G' {
+		query = query.Where("receive_id = ?", message.ReceiveId)
+	} else {
+		query = query.Where("(send_id = ? AND receive_id = ?) OR (send_id = ? AND receive_id = ?)", message.SendId, message.ReceiveId, message.ReceiveId, message.SendId)
+	}
+	return query.Updates(map[string]interface{}{
+		"last_message":    preview,
+		"last_message_at": message.CreatedAt,
+	}).Error
+}
+
+// redisCache 消息流水线的聊天记录缓存实现
+type redisCache struct {
+}
+
+// AppendMessage 把新消息放到最近聊天记录窗口的头部，窗口只保留最近的constants.CACHE_WINDOW条，没有缓存时跳过
+func (r *redisCache) AppendMessage(key string, rsp interface{}) error {
+	item, err := json.Marshal(rsp)
+	if err != nil {
+		return err
+	}
+	return myredis.PushToWindow(key, string(item), constants.CACHE_WINDOW, time.Minute*constants.REDIS_TIMEOUT)
+}
@@ -0,0 +1,53 @@
+package chat
+
+import (
+	"errors"
+	"kama_chat_server/pkg/constants"
+)
+
+// Transport 消息传输层，只负责把客户端发来的消息接入，处理统一交给dispatcher
+// 新增消息中间件时只需要实现这个接口
+type Transport interface {
+	// Publish 接入一条客户端消息
+	Publish(data []byte) error
+	// Consume 阻塞地消费消息并交给handler，transport关闭后返回
+	Consume(handler func(data []byte))
+	Close()
+}
+
+var errTransportBusy = errors.New("由于目前同一时间过多用户发送消息，消息发送失败，请稍后重试")
+
+// newTransport 根据messageMode选择传输层
+func newTransport(messageMode string) Transport {
+	if messageMode == "kafka" {
+		return &kafkaTransport{}
+	}
+	return &channelTransport{
+		transmit: make(chan []byte, constants.CHANNEL_SIZE),
+	}
+}
+
+// channelTransport 单机模式，用进程内的channel转发
+type channelTransport struct {
+	transmit chan []byte // 转发通道
+}
+
+func (t *channelTransport) Publish(data []byte) error {
+	select {
+	case t.transmit <- data:
+		return nil
+	default:
+		// 通道满了不阻塞读协程，考虑加宽channel size，或者使用kafka
+		return errTransportBusy
+	}
+}
+
+func (t *channelTransport) Consume(handler func(data []byte)) {
+	for data := range t.transmit {
+		handler(data)
+	}
+}
+
+func (t *channelTransport) Close() {
+	close(t.transmit)
+}
@@ -0,0 +1,258 @@
+package gorm
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"kama_chat_server/internal/dao"
+	"kama_chat_server/internal/dto/request"
+	"kama_chat_server/internal/dto/respond"
+	"kama_chat_server/internal/model"
+	myredis "kama_chat_server/internal/service/redis"
+	"kama_chat_server/pkg/constants"
+	"kama_chat_server/pkg/enum/contact/contact_type_enum"
+	"kama_chat_server/pkg/enum/contact_apply/contact_apply_status_enum"
+	"kama_chat_server/pkg/enum/group_info/add_mode_enum"
+	"kama_chat_server/pkg/enum/group_info/group_status_enum"
+	"kama_chat_server/pkg/enum/message/ws_event_enum"
+	"kama_chat_server/pkg/util/random"
+	"kama_chat_server/pkg/zlog"
+	"time"
+	"unicode/utf8"
+)
+
+// groupApplyService 需要审核的群聊的加群申请，好友申请仍由userContactService处理
+type groupApplyService struct {
+}
+
+var GroupApplyService = new(groupApplyService)
+
+// ApplyGroup 申请加入需要审核的群聊，返回推送给群主和管理员的事件
+// 被拉黑后不能再申请，被拒绝后要等冷却时间，审核中不能重复申请
+func (g *groupApplyService) ApplyGroup(req request.ApplyContactRequest) (string, *respond.GroupApplyEventRespond, int) {
+	if utf8.RuneCountInString(req.Message) > 100 {
+		return "申请信息不能超过100个字", nil, -2
+	}
+	var group model.GroupInfo
+	if res := dao.GormDB.First(&group, "uuid = ?", req.ContactId); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return "群聊不存在", nil, -2
+		}
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if group.Status == group_status_enum.DISABLE {
+		return "群聊已被禁用", nil, -2
+	}
+	if group.AddMode != add_mode_enum.AUDIT {
+		return "该群聊不需要审核，可以直接加入", nil, -2
+	}
+	isMember, err := GroupInfoService.IsGroupMember(group.Uuid, req.OwnerId)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if isMember {
+		return "已经在群聊中", nil, -2
+	}
+	var user model.UserInfo
+	if res := dao.GormDB.First(&user, "uuid = ?", req.OwnerId); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+
+	now := time.Now()
+	var contactApply model.ContactApply
+	res := dao.GormDB.Where("user_id = ? AND contact_id = ?", req.OwnerId, group.Uuid).First(&contactApply)
+	if res.Error != nil && !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if res.Error != nil {
+		contactApply = model.ContactApply{
+			Uuid:        fmt.Sprintf("A%s", random.GetNowAndLenRandomString(11)),
+			UserId:      req.OwnerId,
+			ContactId:   group.Uuid,
+			ContactType: contact_type_enum.GROUP,
+			Status:      contact_apply_status_enum.PENDING,
+			Message:     req.Message,
+			LastApplyAt: now,
+		}
+		if res := dao.GormDB.Create(&contactApply); res.Error != nil {
+			zlog.Error(res.Error.Error())
+			return constants.SYSTEM_ERROR, nil, -1
+		}
+	} else {
+		switch contactApply.Status {
+		case contact_apply_status_enum.BLACK:
+			return "你已被该群聊拉黑，无法申请", nil, -2
+		case contact_apply_status_enum.PENDING:
+			return "申请正在审核中，请耐心等待", nil, -2
+		case contact_apply_status_enum.REFUSE:
+			if contactApply.HandledAt.Valid && now.Sub(contactApply.HandledAt.Time) < constants.APPLY_COOLDOWN*time.Second {
+				return fmt.Sprintf("申请被拒绝后%d小时内不能再次申请", constants.APPLY_COOLDOWN/3600), nil, -2
+			}
+		}
+		// 条件中带上旧状态，避免和审核同时发生时覆盖审核结果
+		res := dao.GormDB.Model(&model.ContactApply{}).Where("id = ? AND status = ?", contactApply.Id, contactApply.Status).Updates(map[string]interface{}{
+			"status":        contact_apply_status_enum.PENDING,
+			"message":       req.Message,
+			"last_apply_at": now,
+			"handled_by":    "",
+			"handled_at":    sql.NullTime{},
+			"reason":        "",
+		})
+		if res.Error != nil {
+			zlog.Error(res.Error.Error())
+			return constants.SYSTEM_ERROR, nil, -1
+		}
+		if res.RowsAffected == 0 {
+			return "申请状态已变化，请刷新后重试", nil, -2
+		}
+	}
+	return "申请成功", &respond.GroupApplyEventRespond{
+		Event:    ws_event_enum.GroupApply,
+		GroupId:  group.Uuid,
+		UserId:   user.Uuid,
+		Nickname: user.Nickname,
+		Avatar:   user.Avatar,
+		Message:  req.Message,
+		ApplyAt:  now.Format("2006-01-02 15:04:05"),
+	}, 0
+}
+
+// checkHandleApply 校验批量处理的参数和操作者权限
+func (g *groupApplyService) checkHandleApply(operatorId string, req request.HandleGroupApplyRequest) (*model.GroupInfo, string, int) {
+	if len(req.UserIds) == 0 {
+		return nil, "请选择要处理的申请", -2
+	}
+	if utf8.RuneCountInString(req.Reason) > 100 {
+		return nil, "处理理由不能超过100个字", -2
+	}
+	if _, message, ret := GroupInfoService.checkGroupManager(req.GroupId, operatorId); ret != 0 {
+		return nil, message, ret
+	}
+	var group model.GroupInfo
+	if res := dao.GormDB.First(&group, "uuid = ?", req.GroupId); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return nil, "群聊不存在", -2
+		}
+		zlog.Error(res.Error.Error())
+		return nil, constants.SYSTEM_ERROR, -1
+	}
+	return &group, "", 0
+}
+
+// PassGroupApplies 批量通过加群申请，返回推送给申请人和群主、管理员的事件
+// 多个管理员同时处理同一条申请时只有一个生效，已经处理过的直接跳过
+// 每条申请单独提交，中途出错时已经入群的照常返回事件，剩下的可以再次处理
+func (g *groupApplyService) PassGroupApplies(operatorId string, req request.HandleGroupApplyRequest) (string, []respond.GroupApplyResultEventRespond, int) {
+	group, message, ret := g.checkHandleApply(operatorId, req)
+	if ret != 0 {
+		return message, nil, ret
+	}
+	if group.Status == group_status_enum.DISABLE {
+		return "群聊已被禁用", nil, -2
+	}
+	var events []respond.GroupApplyResultEventRespond
+	var failed bool
+	for _, userId := range req.UserIds {
+		now := time.Now()
+		err := dao.GormDB.Transaction(func(tx *gorm.DB) error {
+			res := tx.Model(&model.ContactApply{}).
+				Where("contact_id = ? AND user_id = ? AND status = ?", group.Uuid, userId, contact_apply_status_enum.PENDING).
+				Updates(map[string]interface{}{
+					"status":     contact_apply_status_enum.AGREE,
+					"handled_by": operatorId,
+					"handled_at": now,
+					"reason":     req.Reason,
+				})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			// 已经在群里时只更新申请状态
+			_, err := joinGroup(tx, group.Uuid, userId, operatorId)
+			return err
+		})
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			zlog.Error(err.Error())
+			failed = true
+			break
+		}
+		if err := myredis.DelKeysWithPattern("my_joined_group_list_" + userId); err != nil {
+			zlog.Error(err.Error())
+		}
+		if err := myredis.DelKeysWithPattern("group_session_list_" + userId); err != nil {
+			zlog.Error(err.Error())
+		}
+		events = append(events, respond.GroupApplyResultEventRespond{
+			Event:     ws_event_enum.GroupApplyResult,
+			GroupId:   group.Uuid,
+			GroupName: group.Name,
+			UserId:    userId,
+			Approved:  true,
+			Reason:    req.Reason,
+			HandledBy: operatorId,
+		})
+	}
+	return handleApplyResult("通过", events, failed)
+}
+
+// RefuseGroupApplies 批量拒绝加群申请，被拒绝的用户在冷却时间后才能再次申请
+func (g *groupApplyService) RefuseGroupApplies(operatorId string, req request.HandleGroupApplyRequest) (string, []respond.GroupApplyResultEventRespond, int) {
+	group, message, ret := g.checkHandleApply(operatorId, req)
+	if ret != 0 {
+		return message, nil, ret
+	}
+	var events []respond.GroupApplyResultEventRespond
+	var failed bool
+	for _, userId := range req.UserIds {
+		res := dao.GormDB.Model(&model.ContactApply{}).
+			Where("contact_id = ? AND user_id = ? AND status = ?", group.Uuid, userId, contact_apply_status_enum.PENDING).
+			Updates(map[string]interface{}{
+				"status":     contact_apply_status_enum.REFUSE,
+				"handled_by": operatorId,
+				"handled_at": time.Now(),
+				"reason":     req.Reason,
+			})
+		if res.Error != nil {
+			zlog.Error(res.Error.Error())
+			failed = true
+			break
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		events = append(events, respond.GroupApplyResultEventRespond{
+			Event:     ws_event_enum.GroupApplyResult,
+			GroupId:   group.Uuid,
+			GroupName: group.Name,
+			UserId:    userId,
+			Approved:  false,
+			Reason:    req.Reason,
+			HandledBy: operatorId,
+		})
+	}
+	return handleApplyResult("拒绝", events, failed)
+}
+
+// handleApplyResult 汇总批量处理的结果，中途出错但已经处理了一部分时也算成功，保证这部分申请人能收到通知
+func handleApplyResult(action string, events []respond.GroupApplyResultEventRespond, failed bool) (string, []respond.GroupApplyResultEventRespond, int) {
+	if len(events) == 0 {
+		if failed {
+			return constants.SYSTEM_ERROR, nil, -1
+		}
+		return "没有待处理的申请", nil, -2
+	}
+	if failed {
+		return fmt.Sprintf("已%s%d条加群申请，其余申请处理失败，请稍后重试", action, len(events)), events, 0
+	}
+	return fmt.Sprintf("已%s%d条加群申请", action, len(events)), events, 0
+}
@@ -1,24 +1,33 @@
 package gorm
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"kama_chat_server/internal/dao"
 	"kama_chat_server/internal/dto/request"
 	"kama_chat_server/internal/dto/respond"
 	"kama_chat_server/internal/model"
+	"kama_chat_server/internal/service/chat/dispatcher"
 	myredis "kama_chat_server/internal/service/redis"
 	"kama_chat_server/pkg/constants"
 	"kama_chat_server/pkg/enum/contact/contact_status_enum"
 	"kama_chat_server/pkg/enum/contact/contact_type_enum"
+	"kama_chat_server/pkg/enum/contact_apply/contact_apply_status_enum"
+	"kama_chat_server/pkg/enum/group_info/add_mode_enum"
 	"kama_chat_server/pkg/enum/group_info/group_status_enum"
+	"kama_chat_server/pkg/enum/group_member/member_role_enum"
+	"kama_chat_server/pkg/enum/message/message_type_enum"
+	"kama_chat_server/pkg/enum/message/ws_event_enum"
 	"kama_chat_server/pkg/util/random"
 	"kama_chat_server/pkg/zlog"
 	"log"
 	"time"
+	"unicode/utf8"
 )
 
 type groupInfoService struct {
@@ -67,6 +76,52 @@ var GroupInfoService = new(groupInfoService)
 //	return nil
 //}
 
+// checkGroupOwner 校验操作者是否为群主，是则返回群聊
+func (g *groupInfoService) checkGroupOwner(groupId string, userId string) (*model.GroupInfo, string, int) {
+	var group model.GroupInfo
+	if res := dao.GormDB.First(&group, "uuid = ?", groupId); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			zlog.Info("群聊不存在")
+			return nil, "群聊不存在", -2
+		}
+		zlog.Error(res.Error.Error())
+		return nil, constants.SYSTEM_ERROR, -1
+	}
+	if group.OwnerId != userId {
+		message := "没有权限，只有群主可以操作"
+		zlog.Info(message)
+		return nil, message, -3
+	}
+	return &group, "", 0
+}
+
+// getGroupMember 查询群成员，不在群里时返回nil
+func getGroupMember(groupId string, userId string) (*model.GroupMember, error) {
+	var member model.GroupMember
+	if res := dao.GormDB.Where("group_id = ? AND user_id = ?", groupId, userId).First(&member); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, res.Error
+	}
+	return &member, nil
+}
+
+// checkGroupManager 校验操作者是否为群主或管理员，是则返回操作者的成员记录
+func (g *groupInfoService) checkGroupManager(groupId string, userId string) (*model.GroupMember, string, int) {
+	member, err := getGroupMember(groupId, userId)
+	if err != nil {
+		zlog.Error(err.Error())
+		return nil, constants.SYSTEM_ERROR, -1
+	}
+	if member == nil || member.Role < member_role_enum.ADMIN {
+		message := "没有权限，只有群主或管理员可以操作"
+		zlog.Info(message)
+		return nil, message, -3
+	}
+	return member, "", 0
+}
+
 // CreateGroup 创建群聊
 func (g *groupInfoService) CreateGroup(groupReq request.CreateGroupRequest) (string, int) {
 	group := model.GroupInfo{
@@ -81,32 +136,33 @@ func (g *groupInfoService) CreateGroup(groupReq request.CreateGroupRequest) (str
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-	var members []string
-	members = append(members, groupReq.OwnerId)
-	var err error
-	group.Members, err = json.Marshal(members)
+	err := dao.GormDB.Transaction(func(tx *gorm.DB) error {
+		if res := tx.Create(&group); res.Error != nil {
+			return res.Error
+		}
+		if _, err := addGroupMember(tx, model.GroupMember{
+			GroupId:  group.Uuid,
+			UserId:   groupReq.OwnerId,
+			Role:     member_role_enum.OWNER,
+			JoinedAt: group.CreatedAt,
+		}); err != nil {
+			return err
+		}
+		// 添加联系人
+		contact := model.UserContact{
+			UserId:      groupReq.OwnerId,
+			ContactId:   group.Uuid,
+			ContactType: contact_type_enum.GROUP,
+			Status:      contact_status_enum.NORMAL,
+			CreatedAt:   time.Now(),
+			UpdateAt:    time.Now(),
+		}
+		return tx.Create(&contact).Error
+	})
 	if err != nil {
 		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
-	if res := dao.GormDB.Create(&group); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-
-	// 添加联系人
-	contact := model.UserContact{
-		UserId:      groupReq.OwnerId,
-		ContactId:   group.Uuid,
-		ContactType: contact_type_enum.GROUP,
-		Status:      contact_status_enum.NORMAL,
-		CreatedAt:   time.Now(),
-		UpdateAt:    time.Now(),
-	}
-	if res := dao.GormDB.Create(&contact); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
 	if err := myredis.DelKeysWithPattern("contact_mygroup_list_" + groupReq.OwnerId); err != nil {
 		zlog.Error(err.Error())
 	}
@@ -192,6 +248,7 @@ func (g *groupInfoService) GetGroupInfo(groupId string) (string, *respond.GetGro
 				MemberCnt: group.MemberCnt,
 				OwnerId:   group.OwnerId,
 				AddMode:   group.AddMode,
+				MuteAll:   group.MuteAll,
 				Status:    group.Status,
 			}
 			if group.DeletedAt.Valid {
@@ -252,56 +309,68 @@ func (g *groupInfoService) GetGroupInfoList() (string, []respond.GetGroupListRes
 //
 //}
 
-// LeaveGroup 退群
-func (g *groupInfoService) LeaveGroup(userId string, groupId string) (string, int) {
-	// 从群聊中清除该用户
-	var group model.GroupInfo
-	if res := dao.GormDB.First(&group, "uuid = ?", groupId); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	var members []string
-	if err := json.Unmarshal(group.Members, &members); err != nil {
-		zlog.Error(err.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	for i, member := range members {
-		if member == userId {
-			members = append(members[:i], members[i+1:]...)
-			break
-		}
-	}
-	if data, err := json.Marshal(members); err != nil {
-		zlog.Error(err.Error())
-		return constants.SYSTEM_ERROR, -1
-	} else {
-		group.Members = data
-	}
-	group.MemberCnt -= 1
-	if res := dao.GormDB.Save(&group); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	// 删除会话
+// errLastMember 群主是群里最后一个成员，没有可以转让的人
+var errLastMember = errors.New("群主是最后一个成员")
+
+// LeaveGroup 退群，群主退群时自动转让给最早入群的管理员，没有管理员时转让给最早入群的成员
+// 发生转让时返回推送给新群主的事件
+func (g *groupInfoService) LeaveGroup(userId string, groupId string) (string, *respond.OwnerTransferEventRespond, int) {
 	var deletedAt gorm.DeletedAt
 	deletedAt.Time = time.Now()
 	deletedAt.Valid = true
-	if res := dao.GormDB.Model(&model.Session{}).Where("send_id = ? AND receive_id = ?", userId, groupId).Update("deleted_at", deletedAt); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	// 删除联系人
-	if res := dao.GormDB.Model(&model.UserContact{}).Where("user_id = ? AND contact_id = ?", userId, groupId).Updates(map[string]interface{}{
-		"deleted_at": deletedAt,
-		"status":     contact_status_enum.QUIT_GROUP, // 退群
-	}); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	// 删除申请记录，后面还可以加
-	if res := dao.GormDB.Model(&model.ContactApply{}).Where("contact_id = ? AND user_id = ?", groupId, userId).Update("deleted_at", deletedAt); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
+	var group model.GroupInfo
+	var newOwnerId string
+	err := dao.GormDB.Transaction(func(tx *gorm.DB) error {
+		// 锁住群聊，避免和转让群主同时发生
+		if res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&group, "uuid = ?", groupId); res.Error != nil {
+			return res.Error
+		}
+		if group.OwnerId == userId {
+			var successor model.GroupMember
+			res := tx.Where("group_id = ? AND user_id <> ?", groupId, userId).Order("role DESC, joined_at ASC, id ASC").Limit(1).Find(&successor)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return errLastMember
+			}
+			if err := transferOwner(tx, groupId, userId, successor.UserId); err != nil {
+				return err
+			}
+			newOwnerId = successor.UserId
+		}
+		// 从群聊中清除该用户
+		removed, err := removeGroupMembers(tx, groupId, []string{userId})
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		// 删除会话
+		if res := tx.Model(&model.Session{}).Where("send_id = ? AND receive_id = ?", userId, groupId).Update("deleted_at", deletedAt); res.Error != nil {
+			return res.Error
+		}
+		// 删除联系人
+		if res := tx.Model(&model.UserContact{}).Where("user_id = ? AND contact_id = ?", userId, groupId).Updates(map[string]interface{}{
+			"deleted_at": deletedAt,
+			"status":     contact_status_enum.QUIT_GROUP, // 退群
+		}); res.Error != nil {
+			return res.Error
+		}
+		// 删除申请记录，后面还可以加，拉黑和拒绝的记录保留，否则退群就能绕过拉黑和再次申请的冷却
+		return tx.Model(&model.ContactApply{}).Where("contact_id = ? AND user_id = ? AND status NOT IN ?", groupId, userId,
+			[]int8{contact_apply_status_enum.REFUSE, contact_apply_status_enum.BLACK}).Update("deleted_at", deletedAt).Error
+	})
+	if err != nil {
+		if errors.Is(err, errLastMember) {
+			return "你是群里最后一个成员，请直接解散群聊", nil, -2
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "不在该群聊中", nil, -2
+		}
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
 	}
 	//if err := myredis.DelKeysWithPattern("group_info_" + groupId); err != nil {
 	//	zlog.Error(err.Error())
@@ -312,17 +381,60 @@ func (g *groupInfoService) LeaveGroup(userId string, groupId string) (string, in
 	if err := myredis.DelKeysWithPattern("group_session_list_" + userId); err != nil {
 		zlog.Error(err.Error())
 	}
-	if err := myredis.DelKeysWithPattern("my_joined_group_list_ " + userId); err != nil {
+	if err := myredis.DelKeysWithPattern("my_joined_group_list_" + userId); err != nil {
 		zlog.Error(err.Error())
 	}
 	//if err := myredis.DelKeysWithPattern("session_" + userId + "_" + groupId); err != nil {
 	//	zlog.Error(err.Error())
 	//}
-	return "退群成功", 0
+	if newOwnerId == "" {
+		return "退群成功", nil, 0
+	}
+	deleteMyGroupCache(userId, newOwnerId)
+	return "退群成功，群主已转让", &respond.OwnerTransferEventRespond{
+		Event:      ws_event_enum.OwnerTransfer,
+		GroupId:    groupId,
+		GroupName:  group.Name,
+		OldOwnerId: userId,
+		NewOwnerId: newOwnerId,
+		Auto:       true,
+	}, 0
+}
+
+// TransferGroupOwner 群主把群聊转让给其他成员，原群主变为普通成员，返回推送给新群主的事件
+func (g *groupInfoService) TransferGroupOwner(ownerId string, req request.TransferGroupOwnerRequest) (string, *respond.OwnerTransferEventRespond, int) {
+	group, message, ret := g.checkGroupOwner(req.GroupId, ownerId)
+	if ret != 0 {
+		return message, nil, ret
+	}
+	if req.NewOwnerId == ownerId {
+		return "不能转让给自己", nil, -2
+	}
+	err := dao.GormDB.Transaction(func(tx *gorm.DB) error {
+		return transferOwner(tx, req.GroupId, ownerId, req.NewOwnerId)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "该用户不在群聊中或群主已变更", nil, -2
+		}
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	deleteMyGroupCache(ownerId, req.NewOwnerId)
+	return "转让群主成功", &respond.OwnerTransferEventRespond{
+		Event:      ws_event_enum.OwnerTransfer,
+		GroupId:    group.Uuid,
+		GroupName:  group.Name,
+		OldOwnerId: ownerId,
+		NewOwnerId: req.NewOwnerId,
+	}, 0
 }
 
 // DismissGroup 解散群聊
 func (g *groupInfoService) DismissGroup(ownerId, groupId string) (string, int) {
+	if _, message, ret := g.checkGroupOwner(groupId, ownerId); ret != 0 {
+		return message, ret
+	}
 	var deletedAt gorm.DeletedAt
 	deletedAt.Time = time.Now()
 	deletedAt.Valid = true
@@ -334,6 +446,11 @@ func (g *groupInfoService) DismissGroup(ownerId, groupId string) (string, int) {
 		zlog.Error(res.Error.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
+	// 解散后不再给成员扇出消息
+	if res := dao.GormDB.Where("group_id = ?", groupId).Delete(&model.GroupMember{}); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
 
 	var sessionList []model.Session
 	if res := dao.GormDB.Model(&model.Session{}).Where("receive_id = ?", groupId).Find(&sessionList); res.Error != nil {
@@ -406,6 +523,10 @@ func (g *groupInfoService) DeleteGroups(uuidList []string) (string, int) {
 			zlog.Error(res.Error.Error())
 			return constants.SYSTEM_ERROR, -1
 		}
+		if res := dao.GormDB.Where("group_id = ?", uuid).Delete(&model.GroupMember{}); res.Error != nil {
+			zlog.Error(res.Error.Error())
+			return constants.SYSTEM_ERROR, -1
+		}
 		// 删除会话
 		var sessionList []model.Session
 		if res := dao.GormDB.Model(&model.Session{}).Where("receive_id = ?", uuid).Find(&sessionList); res.Error != nil {
@@ -496,36 +617,33 @@ func (g *groupInfoService) CheckGroupAddMode(groupId string) (string, int8, int)
 func (g *groupInfoService) EnterGroupDirectly(ownerId, contactId string) (string, int) {
 	var group model.GroupInfo
 	if res := dao.GormDB.First(&group, "uuid = ?", ownerId); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return "群聊不存在", -2
+		}
 		zlog.Error(res.Error.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
-	var members []string
-	if err := json.Unmarshal(group.Members, &members); err != nil {
-		zlog.Error(err.Error())
-		return constants.SYSTEM_ERROR, -1
+	if group.Status == group_status_enum.DISABLE {
+		return "群聊已被禁用", -2
 	}
-	members = append(members, contactId)
-	if data, err := json.Marshal(members); err != nil {
-		zlog.Error(err.Error())
-		return constants.SYSTEM_ERROR, -1
-	} else {
-		group.Members = data
-	}
-	group.MemberCnt += 1
-	if res := dao.GormDB.Save(&group); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	newContact := model.UserContact{
-		UserId:      contactId,
-		ContactId:   ownerId,
-		ContactType: contact_type_enum.GROUP,    // 用户
-		Status:      contact_status_enum.NORMAL, // 正常
-		CreatedAt:   time.Now(),
-		UpdateAt:    time.Now(),
+	if group.AddMode == add_mode_enum.AUDIT {
+		return "该群聊需要申请，审核通过后才能加入", -2
 	}
-	if res := dao.GormDB.Create(&newContact); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	err := dao.GormDB.Transaction(func(tx *gorm.DB) error {
+		joined, err := joinGroup(tx, ownerId, contactId, "")
+		if err != nil {
+			return err
+		}
+		if !joined {
+			return gorm.ErrDuplicatedKey
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return "已经在群聊中", -2
+		}
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	//if err := myredis.DelKeysWithPattern("group_info_" + contactId); err != nil {
@@ -534,10 +652,10 @@ func (g *groupInfoService) EnterGroupDirectly(ownerId, contactId string) (string
 	//if err := myredis.DelKeysWithPattern("groupmember_list_" + contactId); err != nil {
 	//	zlog.Error(err.Error())
 	//}
-	if err := myredis.DelKeysWithPattern("group_session_list_" + ownerId); err != nil {
+	if err := myredis.DelKeysWithPattern("group_session_list_" + contactId); err != nil {
 		zlog.Error(err.Error())
 	}
-	if err := myredis.DelKeysWithPattern("my_joined_group_list_" + ownerId); err != nil {
+	if err := myredis.DelKeysWithPattern("my_joined_group_list_" + contactId); err != nil {
 		zlog.Error(err.Error())
 	}
 	//if err := myredis.DelKeysWithPattern("session_" + ownerId + "_" + contactId); err != nil {
@@ -580,10 +698,9 @@ func (g *groupInfoService) SetGroupsStatus(uuidList []string, status int8) (stri
 
 // UpdateGroupInfo 更新群聊消息
 func (g *groupInfoService) UpdateGroupInfo(req request.UpdateGroupInfoRequest) (string, int) {
-	var group model.GroupInfo
-	if res := dao.GormDB.First(&group, "uuid = ?", req.Uuid); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
+	group, message, ret := g.checkGroupOwner(req.Uuid, req.OwnerId)
+	if ret != 0 {
+		return message, ret
 	}
 	if req.Name != "" {
 		group.Name = req.Name
@@ -597,7 +714,7 @@ func (g *groupInfoService) UpdateGroupInfo(req request.UpdateGroupInfoRequest) (
 	if req.Avatar != "" {
 		group.Avatar = req.Avatar
 	}
-	if res := dao.GormDB.Save(&group); res.Error != nil {
+	if res := dao.GormDB.Save(group); res.Error != nil {
 		zlog.Error(res.Error.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
@@ -631,28 +748,43 @@ func (g *groupInfoService) GetGroupMemberList(groupId string) (string, []respond
 	rspString, err := myredis.GetKeyNilIsErr("group_memberlist_" + groupId)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			var group model.GroupInfo
-			if res := dao.GormDB.First(&group, "uuid = ?", groupId); res.Error != nil {
+			// 群主和管理员在前，其余按入群先后
+			var memberList []model.GroupMember
+			if res := dao.GormDB.Where("group_id = ?", groupId).Order("role DESC, joined_at ASC, id ASC").Find(&memberList); res.Error != nil {
 				zlog.Error(res.Error.Error())
 				return constants.SYSTEM_ERROR, nil, -1
 			}
-			var members []string
-			if err := json.Unmarshal(group.Members, &members); err != nil {
-				zlog.Error(err.Error())
-				return constants.SYSTEM_ERROR, nil, -1
+			userIds := make([]string, 0, len(memberList))
+			for _, member := range memberList {
+				userIds = append(userIds, member.UserId)
 			}
-			var rspList []respond.GetGroupMemberListRespond
-			for _, member := range members {
-				var user model.UserInfo
-				if res := dao.GormDB.First(&user, "uuid = ?", member); res.Error != nil {
+			var userList []model.UserInfo
+			if len(userIds) > 0 {
+				if res := dao.GormDB.Where("uuid IN ?", userIds).Find(&userList); res.Error != nil {
 					zlog.Error(res.Error.Error())
 					return constants.SYSTEM_ERROR, nil, -1
 				}
-				rspList = append(rspList, respond.GetGroupMemberListRespond{
-					UserId:   user.Uuid,
-					Nickname: user.Nickname,
-					Avatar:   user.Avatar,
-				})
+			}
+			users := make(map[string]model.UserInfo, len(userList))
+			for _, user := range userList {
+				users[user.Uuid] = user
+			}
+			var rspList []respond.GetGroupMemberListRespond
+			now := time.Now()
+			for _, member := range memberList {
+				user := users[member.UserId]
+				rsp := respond.GetGroupMemberListRespond{
+					UserId:        member.UserId,
+					Nickname:      user.Nickname,
+					Avatar:        user.Avatar,
+					GroupNickname: member.Nickname,
+					Role:          member.Role,
+					JoinedAt:      member.JoinedAt.Format("2006-01-02 15:04:05"),
+				}
+				if member.MutedUntil.Valid && member.MutedUntil.Time.After(now) {
+					rsp.MutedUntil = member.MutedUntil.Time.Format("2006-01-02 15:04:05")
+				}
+				rspList = append(rspList, rsp)
 			}
 			//rspString, err := json.Marshal(rspList)
 			//if err != nil {
@@ -674,52 +806,95 @@ func (g *groupInfoService) GetGroupMemberList(groupId string) (string, []respond
 	return "获取群聊成员列表成功", rsp, 0
 }
 
-// RemoveGroupMembers 移除群聊成员
+// 移除、禁言群成员和设置管理员时在事务中校验角色不通过的原因
+var (
+	errNotManager    = errors.New("操作者不是群主或管理员")
+	errRemoveOwner   = errors.New("不能移除群主")
+	errRemoveManager = errors.New("管理员只能移除普通成员")
+	errNotOwner      = errors.New("操作者不是群主")
+	errNotMember     = errors.New("目标用户不在群聊中")
+	errChangeOwner   = errors.New("不能修改群主的角色")
+	errMuteHigher    = errors.New("只能禁言角色比自己低的成员")
+)
+
+// lockGroupMembers 在事务中锁住群里这些用户的成员记录，返回按用户id索引的记录，不在群里的用户不会出现在结果中
+// 和设置管理员、禁言、移除成员、转让群主同时发生时，后到的事务会等前一个提交后读到最新的角色
+func lockGroupMembers(tx *gorm.DB, groupId string, userIds []string) (map[string]*model.GroupMember, error) {
+	var memberList []model.GroupMember
+	if res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("group_id = ? AND user_id IN ?", groupId, userIds).Find(&memberList); res.Error != nil {
+		return nil, res.Error
+	}
+	members := make(map[string]*model.GroupMember, len(memberList))
+	for i := range memberList {
+		members[memberList[i].UserId] = &memberList[i]
+	}
+	return members, nil
+}
+
+// RemoveGroupMembers 移除群聊成员，群主可以移除管理员和普通成员，管理员只能移除普通成员
+// req.OwnerId 是操作者
 func (g *groupInfoService) RemoveGroupMembers(req request.RemoveGroupMembersRequest) (string, int) {
-	var group model.GroupInfo
-	if res := dao.GormDB.First(&group, "uuid = ?", req.GroupId); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
+	if _, message, ret := g.checkGroupManager(req.GroupId, req.OwnerId); ret != 0 {
+		return message, ret
 	}
-	var members []string
-	if err := json.Unmarshal(group.Members, &members); err != nil {
-		zlog.Error(err.Error())
-		return constants.SYSTEM_ERROR, -1
+	if len(req.UuidList) == 0 {
+		return "请选择要移除的成员", -2
 	}
 	var deletedAt gorm.DeletedAt
 	deletedAt.Time = time.Now()
 	deletedAt.Valid = true
-	log.Println(req.UuidList, req.OwnerId)
-	for _, uuid := range req.UuidList {
-		if req.OwnerId == uuid {
-			return "不能移除群主", -2
+	err := dao.GormDB.Transaction(func(tx *gorm.DB) error {
+		// 在事务中锁住操作者和被移除成员的记录再校验角色，避免和设置管理员、转让群主同时发生
+		members, err := lockGroupMembers(tx, req.GroupId, append([]string{req.OwnerId}, req.UuidList...))
+		if err != nil {
+			return err
+		}
+		operator := members[req.OwnerId]
+		if operator == nil || operator.Role < member_role_enum.ADMIN {
+			return errNotManager
 		}
-		// 从members中找到uuid，移除
-		for i, member := range members {
-			if member == uuid {
-				members = append(members[:i], members[i+1:]...)
+		for _, uuid := range req.UuidList {
+			target := members[uuid]
+			if target == nil {
+				continue
+			}
+			if target.Role == member_role_enum.OWNER {
+				return errRemoveOwner
+			}
+			if target.Role >= operator.Role {
+				return errRemoveManager
 			}
 		}
-		group.MemberCnt -= 1
-		// 删除会话
-		if res := dao.GormDB.Model(&model.Session{}).Where("send_id = ? AND receive_id = ?", uuid, req.GroupId).Update("deleted_at", deletedAt); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
+		if _, err := removeGroupMembers(tx, req.GroupId, req.UuidList); err != nil {
+			return err
 		}
-		// 删除联系人
-		if res := dao.GormDB.Model(&model.UserContact{}).Where("user_id = ? AND contact_id = ?", uuid, req.GroupId).Update("deleted_at", deletedAt); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
+		for _, uuid := range req.UuidList {
+			// 删除会话
+			if res := tx.Model(&model.Session{}).Where("send_id = ? AND receive_id = ?", uuid, req.GroupId).Update("deleted_at", deletedAt); res.Error != nil {
+				return res.Error
+			}
+			// 删除联系人
+			if res := tx.Model(&model.UserContact{}).Where("user_id = ? AND contact_id = ?", uuid, req.GroupId).Update("deleted_at", deletedAt); res.Error != nil {
+				return res.Error
+			}
+			// 删除申请记录，拉黑和拒绝的记录保留
+			if res := tx.Model(&model.ContactApply{}).Where("user_id = ? AND contact_id = ? AND status NOT IN ?", uuid, req.GroupId,
+				[]int8{contact_apply_status_enum.REFUSE, contact_apply_status_enum.BLACK}).Update("deleted_at", deletedAt); res.Error != nil {
+				return res.Error
+			}
 		}
-		// 删除申请记录
-		if res := dao.GormDB.Model(&model.ContactApply{}).Where("user_id = ? AND contact_id = ?", uuid, req.GroupId).Update("deleted_at", deletedAt); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
+		return nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotManager):
+			return "没有权限，只有群主或管理员可以操作", -3
+		case errors.Is(err, errRemoveOwner):
+			return "不能移除群主", -2
+		case errors.Is(err, errRemoveManager):
+			return "没有权限，管理员只能移除普通成员", -3
 		}
-	}
-	group.Members, _ = json.Marshal(members)
-	if res := dao.GormDB.Save(&group); res.Error != nil {
-		zlog.Error(res.Error.Error())
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	//if err := myredis.DelKeysWithPattern("group_info_" + req.GroupId); err != nil {
@@ -736,3 +911,349 @@ func (g *groupInfoService) RemoveGroupMembers(req request.RemoveGroupMembersRequ
 	}
 	return "移除群聊成员成功", 0
 }
+
+// GetGroupMemberIds 获取群成员uuid，按入群先后排列
+// 迁移时保留了已解散群聊的成员记录，已解散的群聊返回空，不再扇出消息和事件
+func (g *groupInfoService) GetGroupMemberIds(groupId string) ([]string, error) {
+	var members []string
+	if res := dao.GormDB.Model(&model.GroupMember{}).
+		Joins("JOIN group_info ON group_info.uuid = group_member.group_id AND group_info.deleted_at IS NULL").
+		Where("group_member.group_id = ?", groupId).Order("group_member.id ASC").Pluck("group_member.user_id", &members); res.Error != nil {
+		return nil, res.Error
+	}
+	return members, nil
+}
+
+// GetGroupManagerIds 获取群主和管理员的uuid
+func (g *groupInfoService) GetGroupManagerIds(groupId string) ([]string, error) {
+	var managers []string
+	if res := dao.GormDB.Model(&model.GroupMember{}).Where("group_id = ? AND role >= ?", groupId, member_role_enum.ADMIN).Pluck("user_id", &managers); res.Error != nil {
+		return nil, res.Error
+	}
+	return managers, nil
+}
+
+// IsGroupMember 判断用户是否在群聊中
+func (g *groupInfoService) IsGroupMember(groupId string, userId string) (bool, error) {
+	var count int64
+	if res := dao.GormDB.Model(&model.GroupMember{}).Where("group_id = ? AND user_id = ?", groupId, userId).Count(&count); res.Error != nil {
+		return false, res.Error
+	}
+	return count > 0, nil
+}
+
+// IsGroupOwner 判断用户是否为群主，@所有人只有群主可以
+func (g *groupInfoService) IsGroupOwner(groupId string, userId string) (bool, error) {
+	member, err := getGroupMember(groupId, userId)
+	if err != nil {
+		return false, err
+	}
+	return member != nil && member.Role == member_role_enum.OWNER, nil
+}
+
+// IsGroupManager 判断用户是否可以管理群聊内容，例如撤回成员的消息，群主和管理员都可以
+func (g *groupInfoService) IsGroupManager(groupId string, userId string) (bool, error) {
+	member, err := getGroupMember(groupId, userId)
+	if err != nil {
+		return false, err
+	}
+	return member != nil && member.Role >= member_role_enum.ADMIN, nil
+}
+
+// GetMuteState 查询成员在群聊中的禁言状态，供消息流水线在发言前检查
+func (g *groupInfoService) GetMuteState(groupId string, userId string) (dispatcher.MuteState, error) {
+	var state dispatcher.MuteState
+	var group model.GroupInfo
+	if res := dao.GormDB.Select("mute_all").Where("uuid = ?", groupId).First(&group); res.Error != nil {
+		return state, res.Error
+	}
+	member, err := getGroupMember(groupId, userId)
+	if err != nil || member == nil {
+		return state, err
+	}
+	if member.MutedUntil.Valid {
+		state.MutedUntil = member.MutedUntil.Time
+	}
+	state.MuteAll = group.MuteAll
+	state.Exempt = member.Role >= member_role_enum.ADMIN
+	return state, nil
+}
+
+// SetGroupAdmin 群主设置或取消管理员
+func (g *groupInfoService) SetGroupAdmin(ownerId string, req request.SetGroupAdminRequest) (string, int) {
+	if _, message, ret := g.checkGroupOwner(req.GroupId, ownerId); ret != 0 {
+		return message, ret
+	}
+	if req.UserId == ownerId {
+		return "不能修改群主的角色", -2
+	}
+	role := member_role_enum.MEMBER
+	if req.IsAdmin {
+		role = member_role_enum.ADMIN
+	}
+	err := dao.GormDB.Transaction(func(tx *gorm.DB) error {
+		// 在事务中锁住双方的成员记录再校验，避免群主已经转让或者目标已经退群
+		members, err := lockGroupMembers(tx, req.GroupId, []string{ownerId, req.UserId})
+		if err != nil {
+			return err
+		}
+		if operator := members[ownerId]; operator == nil || operator.Role != member_role_enum.OWNER {
+			return errNotOwner
+		}
+		target := members[req.UserId]
+		if target == nil {
+			return errNotMember
+		}
+		if target.Role == member_role_enum.OWNER {
+			return errChangeOwner
+		}
+		return tx.Model(&model.GroupMember{}).Where("id = ?", target.Id).Updates(map[string]interface{}{
+			"role":       role,
+			"updated_at": time.Now(),
+		}).Error
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotOwner):
+			return "没有权限，只有群主可以操作", -3
+		case errors.Is(err, errNotMember):
+			return "该用户不在群聊中", -2
+		case errors.Is(err, errChangeOwner):
+			return "不能修改群主的角色", -2
+		}
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if req.IsAdmin {
+		return "已设为管理员", 0
+	}
+	return "已取消管理员", 0
+}
+
+// MuteGroupMember 禁言群成员，Duration为0时解除禁言，只能禁言角色比自己低的成员
+func (g *groupInfoService) MuteGroupMember(operatorId string, req request.MuteGroupMemberRequest) (string, int) {
+	if _, message, ret := g.checkGroupManager(req.GroupId, operatorId); ret != 0 {
+		return message, ret
+	}
+	if req.Duration < 0 || req.Duration > constants.MAX_MUTE_DURATION {
+		return "禁言时长不合法", -2
+	}
+	var mutedUntil sql.NullTime
+	if req.Duration > 0 {
+		mutedUntil = sql.NullTime{Time: time.Now().Add(time.Duration(req.Duration) * time.Second), Valid: true}
+	}
+	err := dao.GormDB.Transaction(func(tx *gorm.DB) error {
+		// 在事务中锁住双方的成员记录再比较角色，避免操作者刚被取消管理员或者目标刚被设为管理员
+		members, err := lockGroupMembers(tx, req.GroupId, []string{operatorId, req.UserId})
+		if err != nil {
+			return err
+		}
+		operator := members[operatorId]
+		if operator == nil || operator.Role < member_role_enum.ADMIN {
+			return errNotManager
+		}
+		target := members[req.UserId]
+		if target == nil {
+			return errNotMember
+		}
+		if target.Role >= operator.Role {
+			return errMuteHigher
+		}
+		return tx.Model(&model.GroupMember{}).Where("id = ?", target.Id).Updates(map[string]interface{}{
+			"muted_until": mutedUntil,
+			"updated_at":  time.Now(),
+		}).Error
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotManager):
+			return "没有权限，只有群主或管理员可以操作", -3
+		case errors.Is(err, errNotMember):
+			return "该用户不在群聊中", -2
+		case errors.Is(err, errMuteHigher):
+			return "没有权限禁言该成员", -3
+		}
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if req.Duration == 0 {
+		return "已解除禁言", 0
+	}
+	return "禁言成功", 0
+}
+
+// MuteAll 开启或关闭全员禁言，群主和管理员不受限制
+func (g *groupInfoService) MuteAll(operatorId string, req request.MuteAllRequest) (string, int) {
+	if _, message, ret := g.checkGroupManager(req.GroupId, operatorId); ret != 0 {
+		return message, ret
+	}
+	if res := dao.GormDB.Model(&model.GroupInfo{}).Where("uuid = ?", req.GroupId).Updates(map[string]interface{}{
+		"mute_all":   req.MuteAll,
+		"updated_at": time.Now(),
+	}); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if req.MuteAll {
+		return "已开启全员禁言", 0
+	}
+	return "已关闭全员禁言", 0
+}
+
+// SetGroupNickname 设置自己在群聊中的昵称，为空时恢复显示用户昵称
+func (g *groupInfoService) SetGroupNickname(userId string, req request.SetGroupNicknameRequest) (string, int) {
+	if utf8.RuneCountInString(req.Nickname) > 20 {
+		return "群昵称不能超过20个字", -2
+	}
+	res := dao.GormDB.Model(&model.GroupMember{}).Where("group_id = ? AND user_id = ?", req.GroupId, userId).Updates(map[string]interface{}{
+		"nickname":   req.Nickname,
+		"updated_at": time.Now(),
+	})
+	if res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if res.RowsAffected == 0 {
+		return "不在该群聊中", -2
+	}
+	return "设置成功", 0
+}
+
+// syncMemberCnt 按group_member表重新统计群人数，避免并发加减导致人数不准
+func syncMemberCnt(tx *gorm.DB, groupId string) error {
+	return tx.Exec("UPDATE group_info SET member_cnt = (SELECT COUNT(*) FROM group_member WHERE group_id = ?) WHERE uuid = ?", groupId, groupId).Error
+}
+
+// addGroupMember 在事务中添加群成员并更新群人数，已经在群里时返回false
+func addGroupMember(tx *gorm.DB, member model.GroupMember) (bool, error) {
+	now := time.Now()
+	if member.JoinedAt.IsZero() {
+		member.JoinedAt = now
+	}
+	member.UpdatedAt = now
+	res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&member)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return false, nil
+	}
+	return true, syncMemberCnt(tx, member.GroupId)
+}
+
+// joinGroup 在事务中把用户作为普通成员加入群聊，并创建群聊联系人，已经在群里时返回false
+func joinGroup(tx *gorm.DB, groupId string, userId string, inviterId string) (bool, error) {
+	now := time.Now()
+	added, err := addGroupMember(tx, model.GroupMember{
+		GroupId:   groupId,
+		UserId:    userId,
+		Role:      member_role_enum.MEMBER,
+		InviterId: inviterId,
+		JoinedAt:  now,
+	})
+	if err != nil || !added {
+		return false, err
+	}
+	// 群聊就只用创建一个UserContact，因为一个UserContact足以表达双方的状态
+	contact := model.UserContact{
+		UserId:      userId,
+		ContactId:   groupId,
+		ContactType: contact_type_enum.GROUP,
+		Status:      contact_status_enum.NORMAL,
+		CreatedAt:   now,
+		UpdateAt:    now,
+	}
+	return true, tx.Create(&contact).Error
+}
+
+// NewOwnerTransferNotice 生成群主转让的系统通知，以群聊自己的身份发到群聊中
+// 通知走消息流水线存表并分配序号，新群主不在线时也能在同步时收到
+func (g *groupInfoService) NewOwnerTransferNotice(event *respond.OwnerTransferEventRespond) (*request.ChatMessageRequest, error) {
+	// 原群主可能已经注销，也要取到昵称
+	var userList []model.UserInfo
+	if res := dao.GormDB.Unscoped().Where("uuid IN ?", []string{event.OldOwnerId, event.NewOwnerId}).Find(&userList); res.Error != nil {
+		return nil, res.Error
+	}
+	var oldOwner, newOwner model.UserInfo
+	for _, user := range userList {
+		switch user.Uuid {
+		case event.OldOwnerId:
+			oldOwner = user
+		case event.NewOwnerId:
+			newOwner = user
+		}
+	}
+	content := fmt.Sprintf("%s 将群主转让给了 %s", oldOwner.Nickname, newOwner.Nickname)
+	if event.Auto {
+		content = fmt.Sprintf("%s 退出了群聊，群主已自动转让给 %s", oldOwner.Nickname, newOwner.Nickname)
+	}
+	var group model.GroupInfo
+	if res := dao.GormDB.Select("uuid", "name", "avatar").First(&group, "uuid = ?", event.GroupId); res.Error != nil {
+		return nil, res.Error
+	}
+	// 系统通知以群聊自己的身份发出，不属于任何成员的会话，操作者只体现在内容里
+	return &request.ChatMessageRequest{
+		Type:       message_type_enum.System,
+		Content:    content,
+		SendId:     group.Uuid,
+		SendName:   group.Name,
+		SendAvatar: group.Avatar,
+		ReceiveId:  event.GroupId,
+	}, nil
+}
+
+// transferOwner 在事务中把群主从oldOwnerId转让给newOwnerId，原群主变为普通成员
+// 群主已经变更或新群主不在群里时返回gorm.ErrRecordNotFound
+func transferOwner(tx *gorm.DB, groupId string, oldOwnerId string, newOwnerId string) error {
+	now := time.Now()
+	res := tx.Model(&model.GroupInfo{}).Where("uuid = ? AND owner_id = ?", groupId, oldOwnerId).Updates(map[string]interface{}{
+		"owner_id":   newOwnerId,
+		"updated_at": now,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	// 群主不受禁言限制，转让时一并解除
+	res = tx.Model(&model.GroupMember{}).Where("group_id = ? AND user_id = ?", groupId, newOwnerId).Updates(map[string]interface{}{
+		"role":        member_role_enum.OWNER,
+		"muted_until": sql.NullTime{},
+		"updated_at":  now,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return tx.Model(&model.GroupMember{}).Where("group_id = ? AND user_id = ?", groupId, oldOwnerId).Updates(map[string]interface{}{
+		"role":       member_role_enum.MEMBER,
+		"updated_at": now,
+	}).Error
+}
+
+// deleteMyGroupCache 群主变更后清除新旧群主"我创建的群聊"缓存
+func deleteMyGroupCache(userIds ...string) {
+	for _, userId := range userIds {
+		if err := myredis.DelKeysWithPattern("contact_mygroup_list_" + userId); err != nil {
+			zlog.Error(err.Error())
+		}
+	}
+}
+
+// removeGroupMembers 在事务中删除群成员并更新群人数，返回实际删除的人数
+func removeGroupMembers(tx *gorm.DB, groupId string, userIds []string) (int64, error) {
+	if len(userIds) == 0 {
+		return 0, nil
+	}
+	res := tx.Where("group_id = ? AND user_id IN ?", groupId, userIds).Delete(&model.GroupMember{})
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return 0, nil
+	}
+	return res.RowsAffected, syncMemberCnt(tx, groupId)
+}
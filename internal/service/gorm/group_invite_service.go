@@ -0,0 +1,259 @@
+package gorm
+
+import (
+	"database/sql"
+	"errors"
+	"gorm.io/gorm"
+	"kama_chat_server/internal/dao"
+	"kama_chat_server/internal/dto/request"
+	"kama_chat_server/internal/dto/respond"
+	"kama_chat_server/internal/model"
+	myredis "kama_chat_server/internal/service/redis"
+	"kama_chat_server/pkg/constants"
+	"kama_chat_server/pkg/enum/contact_apply/contact_apply_status_enum"
+	"kama_chat_server/pkg/enum/group_info/add_mode_enum"
+	"kama_chat_server/pkg/enum/group_info/group_status_enum"
+	"kama_chat_server/pkg/enum/group_member/member_role_enum"
+	"kama_chat_server/pkg/util/random"
+	"kama_chat_server/pkg/zlog"
+	"time"
+)
+
+type groupInviteService struct {
+}
+
+var GroupInviteService = new(groupInviteService)
+
+// liveInviteCondition 邀请仍然有效：没有撤销、没有过期、还有剩余次数
+const liveInviteCondition = "revoked_at IS NULL AND (expire_at IS NULL OR expire_at > ?) AND (max_uses = 0 OR used_count < max_uses)"
+
+func toInviteRespond(invite *model.GroupInvite) respond.GroupInviteRespond {
+	rsp := respond.GroupInviteRespond{
+		Token:       invite.Token,
+		GroupId:     invite.GroupId,
+		CreatorId:   invite.CreatorId,
+		MaxUses:     invite.MaxUses,
+		UsedCount:   invite.UsedCount,
+		BypassAudit: invite.BypassAudit,
+		CreatedAt:   invite.CreatedAt.Format("2006-01-02 15:04:05"),
+		QrPayload:   constants.INVITE_QR_PREFIX + invite.Token,
+	}
+	if invite.ExpireAt.Valid {
+		rsp.ExpireAt = invite.ExpireAt.Time.Format("2006-01-02 15:04:05")
+	}
+	return rsp
+}
+
+// getLiveInvite 按token查询有效的邀请和对应的群聊，无效时返回提示
+func (g *groupInviteService) getLiveInvite(token string) (*model.GroupInvite, *model.GroupInfo, string, int) {
+	if token == "" {
+		return nil, nil, "邀请已失效", -2
+	}
+	var invite model.GroupInvite
+	if res := dao.GormDB.Where("token = ?", token).Where(liveInviteCondition, time.Now()).First(&invite); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return nil, nil, "邀请已失效", -2
+		}
+		zlog.Error(res.Error.Error())
+		return nil, nil, constants.SYSTEM_ERROR, -1
+	}
+	var group model.GroupInfo
+	if res := dao.GormDB.First(&group, "uuid = ?", invite.GroupId); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return nil, nil, "群聊不存在", -2
+		}
+		zlog.Error(res.Error.Error())
+		return nil, nil, constants.SYSTEM_ERROR, -1
+	}
+	if group.Status == group_status_enum.DISABLE {
+		return nil, nil, "群聊已被禁用", -2
+	}
+	// 创建者被取消管理员或者已经不在群里时，之前创建的邀请也随之失效
+	creator, err := getGroupMember(invite.GroupId, invite.CreatorId)
+	if err != nil {
+		zlog.Error(err.Error())
+		return nil, nil, constants.SYSTEM_ERROR, -1
+	}
+	if creator == nil || creator.Role < member_role_enum.ADMIN {
+		return nil, nil, "邀请已失效", -2
+	}
+	return &invite, &group, "", 0
+}
+
+// CreateInvite 群主或管理员创建邀请
+func (g *groupInviteService) CreateInvite(operatorId string, req request.CreateGroupInviteRequest) (string, *respond.GroupInviteRespond, int) {
+	if _, message, ret := GroupInfoService.checkGroupManager(req.GroupId, operatorId); ret != 0 {
+		return message, nil, ret
+	}
+	if req.ExpireIn < 0 {
+		return "有效期不合法", nil, -2
+	}
+	if req.MaxUses < 0 {
+		return "使用次数不合法", nil, -2
+	}
+	token, err := random.GetSecureToken(16)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	now := time.Now()
+	invite := model.GroupInvite{
+		Token:       token,
+		GroupId:     req.GroupId,
+		CreatorId:   operatorId,
+		MaxUses:     req.MaxUses,
+		BypassAudit: req.BypassAudit,
+		CreatedAt:   now,
+	}
+	if req.ExpireIn > 0 {
+		invite.ExpireAt = sql.NullTime{Time: now.Add(time.Duration(req.ExpireIn) * time.Second), Valid: true}
+	}
+	if res := dao.GormDB.Create(&invite); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	rsp := toInviteRespond(&invite)
+	return "创建邀请成功", &rsp, 0
+}
+
+// GetInviteList 获取群聊中仍然有效的邀请，群主和管理员可以查看
+func (g *groupInviteService) GetInviteList(operatorId string, groupId string) (string, []respond.GroupInviteRespond, int) {
+	if _, message, ret := GroupInfoService.checkGroupManager(groupId, operatorId); ret != 0 {
+		return message, nil, ret
+	}
+	// 创建者已经不是群主或管理员的邀请不再有效，不返回
+	var inviteList []model.GroupInvite
+	if res := dao.GormDB.Where("group_id = ?", groupId).Where(liveInviteCondition, time.Now()).
+		Where("creator_id IN (?)", dao.GormDB.Model(&model.GroupMember{}).Select("user_id").Where("group_id = ? AND role >= ?", groupId, member_role_enum.ADMIN)).
+		Order("id DESC").Find(&inviteList); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	rsp := make([]respond.GroupInviteRespond, 0, len(inviteList))
+	for i := range inviteList {
+		rsp = append(rsp, toInviteRespond(&inviteList[i]))
+	}
+	return "获取邀请列表成功", rsp, 0
+}
+
+// RevokeInvite 撤销邀请，群主可以撤销任意邀请，管理员只能撤销自己创建的
+func (g *groupInviteService) RevokeInvite(operatorId string, token string) (string, int) {
+	var invite model.GroupInvite
+	if res := dao.GormDB.Where("token = ?", token).First(&invite); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return "邀请不存在", -2
+		}
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	operator, message, ret := GroupInfoService.checkGroupManager(invite.GroupId, operatorId)
+	if ret != 0 {
+		return message, ret
+	}
+	if operator.Role != member_role_enum.OWNER && invite.CreatorId != operatorId {
+		return "没有权限，管理员只能撤销自己创建的邀请", -3
+	}
+	res := dao.GormDB.Model(&model.GroupInvite{}).Where("id = ? AND revoked_at IS NULL", invite.Id).Update("revoked_at", time.Now())
+	if res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if res.RowsAffected == 0 {
+		return "邀请已撤销", -2
+	}
+	return "撤销邀请成功", 0
+}
+
+// PreviewInvite 通过邀请预览群聊，不需要登录
+func (g *groupInviteService) PreviewInvite(token string) (string, *respond.InvitePreviewRespond, int) {
+	invite, group, message, ret := g.getLiveInvite(token)
+	if ret != 0 {
+		return message, nil, ret
+	}
+	rsp := &respond.InvitePreviewRespond{
+		GroupId:   group.Uuid,
+		Name:      group.Name,
+		Avatar:    group.Avatar,
+		Notice:    group.Notice,
+		MemberCnt: group.MemberCnt,
+		NeedAudit: group.AddMode == add_mode_enum.AUDIT && !invite.BypassAudit,
+	}
+	if invite.ExpireAt.Valid {
+		rsp.ExpireAt = invite.ExpireAt.Time.Format("2006-01-02 15:04:05")
+	}
+	return "获取群聊信息成功", rsp, 0
+}
+
+// JoinGroupByInvite 通过邀请加入群聊
+// 需要审核且邀请不能跳过审核时提交加群申请，返回推送给群主和管理员的申请事件，只有直接入群才计入使用次数
+func (g *groupInviteService) JoinGroupByInvite(userId string, req request.JoinGroupByInviteRequest) (string, *respond.JoinGroupByInviteRespond, *respond.GroupApplyEventRespond, int) {
+	invite, group, message, ret := g.getLiveInvite(req.Token)
+	if ret != 0 {
+		return message, nil, nil, ret
+	}
+	if group.AddMode == add_mode_enum.AUDIT && !invite.BypassAudit {
+		message, event, ret := GroupApplyService.ApplyGroup(request.ApplyContactRequest{
+			OwnerId:   userId,
+			ContactId: group.Uuid,
+			Message:   req.Message,
+		})
+		if ret != 0 {
+			return message, nil, nil, ret
+		}
+		return message, &respond.JoinGroupByInviteRespond{GroupId: group.Uuid}, event, 0
+	}
+	// 被拉黑的用户持有邀请也不能加入
+	var blackCount int64
+	if res := dao.GormDB.Model(&model.ContactApply{}).Where("user_id = ? AND contact_id = ? AND status = ?", userId, group.Uuid, contact_apply_status_enum.BLACK).Count(&blackCount); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, nil, -1
+	}
+	if blackCount > 0 {
+		return "你已被该群聊拉黑，无法加入", nil, nil, -2
+	}
+	err := dao.GormDB.Transaction(func(tx *gorm.DB) error {
+		// 条件更新使用次数，并发使用最后一个名额时只有一个成功
+		res := tx.Model(&model.GroupInvite{}).Where("id = ?", invite.Id).Where(liveInviteCondition, time.Now()).
+			Update("used_count", gorm.Expr("used_count + 1"))
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		// 锁住创建者的成员记录再校验一次，避免和取消管理员、移除成员同时发生
+		members, err := lockGroupMembers(tx, group.Uuid, []string{invite.CreatorId})
+		if err != nil {
+			return err
+		}
+		if creator := members[invite.CreatorId]; creator == nil || creator.Role < member_role_enum.ADMIN {
+			return gorm.ErrRecordNotFound
+		}
+		joined, err := joinGroup(tx, group.Uuid, userId, invite.CreatorId)
+		if err != nil {
+			return err
+		}
+		if !joined {
+			// 已经在群里时不占用次数
+			return gorm.ErrDuplicatedKey
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "邀请已失效", nil, nil, -2
+		}
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return "已经在群聊中", nil, nil, -2
+		}
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, nil, -1
+	}
+	if err := myredis.DelKeysWithPattern("group_session_list_" + userId); err != nil {
+		zlog.Error(err.Error())
+	}
+	if err := myredis.DelKeysWithPattern("my_joined_group_list_" + userId); err != nil {
+		zlog.Error(err.Error())
+	}
+	return "进群成功", &respond.JoinGroupByInviteRespond{GroupId: group.Uuid, Joined: true}, nil, 0
+}
@@ -1,21 +1,38 @@
 package gorm
 
 import (
+	"bytes"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"io"
 	"kama_chat_server/internal/config"
 	"kama_chat_server/internal/dao"
+	"kama_chat_server/internal/dto/request"
 	"kama_chat_server/internal/dto/respond"
 	"kama_chat_server/internal/model"
+	"kama_chat_server/internal/service/chat/dispatcher"
 	myredis "kama_chat_server/internal/service/redis"
 	"kama_chat_server/pkg/constants"
+	"kama_chat_server/pkg/enum/contact/contact_status_enum"
+	"kama_chat_server/pkg/enum/contact/contact_type_enum"
+	"kama_chat_server/pkg/enum/message/message_status_enum"
+	"kama_chat_server/pkg/enum/message/message_type_enum"
+	"kama_chat_server/pkg/enum/message/ws_event_enum"
+	"kama_chat_server/pkg/util/random"
 	"kama_chat_server/pkg/zlog"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type messageService struct {
@@ -23,99 +40,1418 @@ type messageService struct {
 
 var MessageService = new(messageService)
 
-// GetMessageList 获取聊天记录
-func (m *messageService) GetMessageList(userOneId, userTwoId string) (string, []respond.GetMessageListRespond, int) {
-	rspString, err := myredis.GetKeyNilIsErr("message_list_" + userOneId + "_" + userTwoId)
+func toMessageRespond(message model.Message) respond.GetMessageListRespond {
+	rsp := respond.GetMessageListRespond{
+		Uuid:           message.Uuid,
+		ConversationId: message.ConversationId,
+		Seq:            message.Seq,
+		SendId:         message.SendId,
+		SendName:       message.SendName,
+		SendAvatar:     message.SendAvatar,
+		ReceiveId:      message.ReceiveId,
+		Content:        message.Content,
+		Url:            message.Url,
+		Type:           message.Type,
+		FileType:       message.FileType,
+		FileName:       message.FileName,
+		FileSize:       message.FileSize,
+		CreatedAt:      message.CreatedAt.Format("2006-01-02 15:04:05"),
+		Quote:          dispatcher.Quote(&message),
+		ThreadId:       message.ThreadId,
+		Mentions:       dispatcher.Mentions(&message),
+		MentionAll:     message.MentionAll,
+		Duration:       message.Duration,
+		Waveform:       dispatcher.Waveform(&message),
+	}
+	if message.EditedAt.Valid {
+		rsp.EditedAt = message.EditedAt.Time.Format("2006-01-02 15:04:05")
+	}
+	if message.RecalledAt.Valid {
+		// 撤回的消息只保留元信息，原内容留在库里用于审计
+		rsp.Content, rsp.Url, rsp.FileType, rsp.FileName, rsp.FileSize, rsp.EditedAt = "", "", "", "", "", ""
+		rsp.Quote, rsp.Duration, rsp.Waveform = nil, 0, nil
+		rsp.Recalled = true
+	}
+	return rsp
+}
+
+func toGroupMessageRespond(message model.Message) respond.GetGroupMessageListRespond {
+	return respond.GetGroupMessageListRespond(toMessageRespond(message))
+}
+
+func getPageSize(pageSize int) int {
+	if pageSize <= 0 {
+		return constants.PAGE_SIZE
+	}
+	if pageSize > constants.MAX_PAGE_SIZE {
+		return constants.MAX_PAGE_SIZE
+	}
+	return pageSize
+}
+
+// pageMessages 在query的基础上按消息uuid游标分页，返回从新到旧的一页和是否还有更多
+// before取比游标更早的，after取比游标更新的，都不传取最新的
+func (m *messageService) pageMessages(query *gorm.DB, before string, after string, pageSize int) ([]model.Message, bool, string, int) {
+	if before != "" && after != "" {
+		return nil, false, "before和after不能同时指定", -2
+	}
+	anchorUuid := before + after
+	if anchorUuid != "" {
+		var anchor model.Message
+		if res := dao.GormDB.Select("id").Where("uuid = ?", anchorUuid).First(&anchor); res.Error != nil {
+			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+				return nil, false, "消息不存在", -2
+			}
+			zlog.Error(res.Error.Error())
+			return nil, false, constants.SYSTEM_ERROR, -1
+		}
+		if before != "" {
+			query = query.Where("id < ?", anchor.Id)
+		} else {
+			query = query.Where("id > ?", anchor.Id)
+		}
+	}
+	order := "id DESC"
+	if after != "" {
+		// 取游标之后紧挨着的一页，再翻转成从新到旧
+		order = "id ASC"
+	}
+	var messageList []model.Message
+	// 多取一条判断是否还有更多
+	if res := query.Order(order).Limit(pageSize + 1).Find(&messageList); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return nil, false, constants.SYSTEM_ERROR, -1
+	}
+	hasMore := len(messageList) > pageSize
+	if hasMore {
+		messageList = messageList[:pageSize]
+	}
+	if after != "" {
+		for i, j := 0, len(messageList)-1; i < j; i, j = i+1, j-1 {
+			messageList[i], messageList[j] = messageList[j], messageList[i]
+		}
+	}
+	return messageList, hasMore, "", 0
+}
+
+// getWindow 从缓存的最近聊天记录窗口中取最新的一页，窗口不够回答时返回false
+// 窗口从新到旧，不足constants.CACHE_WINDOW条说明已经是全部记录
+func getWindow(key string, pageSize int) ([]string, bool, bool) {
+	items, err := myredis.GetListRange(key, 0, -1)
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			zlog.Info(err.Error())
-			zlog.Info(fmt.Sprintf("%s %s", userTwoId, userTwoId))
-			var messageList []model.Message
-			if res := dao.GormDB.Where("(send_id = ? AND receive_id = ?) OR (send_id = ? AND receive_id = ?)", userOneId, userTwoId, userTwoId, userOneId).Order("created_at ASC").Find(&messageList); res.Error != nil {
-				zlog.Error(res.Error.Error())
-				return constants.SYSTEM_ERROR, nil, -1
+		if !errors.Is(err, redis.Nil) {
+			zlog.Error(err.Error())
+		}
+		return nil, false, false
+	}
+	if len(items) > pageSize {
+		return items[:pageSize], true, true
+	}
+	if len(items) < constants.CACHE_WINDOW {
+		return items, false, true
+	}
+	return nil, false, false
+}
+
+// setWindow 用数据库中最新的记录重建缓存窗口
+func setWindow(key string, rspList interface{}) {
+	rspBytes, err := json.Marshal(rspList)
+	if err != nil {
+		zlog.Error(err.Error())
+		return
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(rspBytes, &items); err != nil {
+		zlog.Error(err.Error())
+		return
+	}
+	values := make([]string, len(items))
+	for i, item := range items {
+		values[i] = string(item)
+	}
+	if err := myredis.SetList(key, values, time.Minute*constants.REDIS_TIMEOUT); err != nil {
+		zlog.Error(err.Error())
+	}
+}
+
+// GetMessageList 分页获取聊天记录，从新到旧，最新一页优先从缓存窗口获取
+func (m *messageService) GetMessageList(userOneId string, req request.GetMessageListRequest) (string, *respond.MessagePageRespond, int) {
+	userTwoId := req.UserTwoId
+	pageSize := getPageSize(req.PageSize)
+	key := "message_list_" + userOneId + "_" + userTwoId
+	firstPage := req.Before == "" && req.After == ""
+	if firstPage {
+		if items, hasMore, ok := getWindow(key, pageSize); ok {
+			rsp := &respond.MessagePageRespond{
+				Messages: make([]respond.GetMessageListRespond, 0, len(items)),
+				HasMore:  hasMore,
 			}
-			var rspList []respond.GetMessageListRespond
-			for _, message := range messageList {
-				rspList = append(rspList, respond.GetMessageListRespond{
-					SendId:     message.SendId,
-					SendName:   message.SendName,
-					SendAvatar: message.SendAvatar,
-					ReceiveId:  message.ReceiveId,
-					Content:    message.Content,
-					Url:        message.Url,
-					Type:       message.Type,
-					FileType:   message.FileType,
-					FileName:   message.FileName,
-					FileSize:   message.FileSize,
-					CreatedAt:  message.CreatedAt.Format("2006-01-02 15:04:05"),
-				})
+			for _, item := range items {
+				var messageRsp respond.GetMessageListRespond
+				if err := json.Unmarshal([]byte(item), &messageRsp); err != nil {
+					zlog.Error(err.Error())
+					continue
+				}
+				rsp.Messages = append(rsp.Messages, messageRsp)
 			}
-			//rspString, err := json.Marshal(rspList)
-			//if err != nil {
-			//	zlog.Error(err.Error())
-			//}
-			//if err := myredis.SetKeyEx("message_list_"+userOneId+"_"+userTwoId, string(rspString), time.Minute*constants.REDIS_TIMEOUT); err != nil {
-			//	zlog.Error(err.Error())
-			//}
-			return "获取聊天记录成功", rspList, 0
-		} else {
+			attachViewerState(userOneId, rsp.Messages)
+			return "获取聊天记录成功", rsp, 0
+		}
+	}
+
+	query := dao.GormDB.Where("(send_id = ? AND receive_id = ?) OR (send_id = ? AND receive_id = ?)", userOneId, userTwoId, userTwoId, userOneId)
+	loadSize := pageSize
+	if firstPage {
+		// 最新一页顺便把整个缓存窗口加载上
+		loadSize = constants.CACHE_WINDOW
+	}
+	messageList, hasMore, message, ret := m.pageMessages(query, req.Before, req.After, loadSize)
+	if ret != 0 {
+		return message, nil, ret
+	}
+	rspList := make([]respond.GetMessageListRespond, 0, len(messageList))
+	for _, message := range messageList {
+		rspList = append(rspList, toMessageRespond(message))
+	}
+	if firstPage {
+		setWindow(key, rspList)
+		if len(rspList) > pageSize {
+			rspList = rspList[:pageSize]
+			hasMore = true
+		}
+	}
+	attachViewerState(userOneId, rspList)
+	return "获取聊天记录成功", &respond.MessagePageRespond{
+		Messages: rspList,
+		HasMore:  hasMore,
+	}, 0
+}
+
+// GetGroupMessageList 分页获取群聊消息记录，从新到旧，最新一页优先从缓存窗口获取
+func (m *messageService) GetGroupMessageList(userId string, req request.GetGroupMessageListRequest) (string, *respond.GroupMessagePageRespond, int) {
+	groupId := req.GroupId
+	if message, ret := m.checkConversationMember(userId, groupId); ret != 0 {
+		return message, nil, ret
+	}
+	pageSize := getPageSize(req.PageSize)
+	key := "group_messagelist_" + groupId
+	firstPage := req.Before == "" && req.After == ""
+	if firstPage {
+		if items, hasMore, ok := getWindow(key, pageSize); ok {
+			rsp := &respond.GroupMessagePageRespond{
+				Messages: make([]respond.GetGroupMessageListRespond, 0, len(items)),
+				HasMore:  hasMore,
+			}
+			for _, item := range items {
+				var messageRsp respond.GetGroupMessageListRespond
+				if err := json.Unmarshal([]byte(item), &messageRsp); err != nil {
+					zlog.Error(err.Error())
+					continue
+				}
+				rsp.Messages = append(rsp.Messages, messageRsp)
+			}
+			attachGroupViewerState(userId, rsp.Messages)
+			return "获取聊天记录成功", rsp, 0
+		}
+	}
+
+	query := dao.GormDB.Where("receive_id = ?", groupId)
+	loadSize := pageSize
+	if firstPage {
+		loadSize = constants.CACHE_WINDOW
+	}
+	messageList, hasMore, message, ret := m.pageMessages(query, req.Before, req.After, loadSize)
+	if ret != 0 {
+		return message, nil, ret
+	}
+	rspList := make([]respond.GetGroupMessageListRespond, 0, len(messageList))
+	for _, message := range messageList {
+		rspList = append(rspList, toGroupMessageRespond(message))
+	}
+	if firstPage {
+		setWindow(key, rspList)
+		if len(rspList) > pageSize {
+			rspList = rspList[:pageSize]
+			hasMore = true
+		}
+	}
+	attachGroupViewerState(userId, rspList)
+	return "获取聊天记录成功", &respond.GroupMessagePageRespond{
+		Messages: rspList,
+		HasMore:  hasMore,
+	}, 0
+}
+
+// GetThreadMessageList 获取群聊话题中的回复，话题单独分页，不走聊天记录缓存
+func (m *messageService) GetThreadMessageList(userId string, req request.GetThreadMessageListRequest) (string, *respond.ThreadMessagePageRespond, int) {
+	var root model.Message
+	if res := dao.GormDB.Where("uuid = ?", req.RootUuid).First(&root); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return "消息不存在", nil, -2
+		}
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if root.ReceiveId[0] != 'G' {
+		return "只有群聊消息有话题", nil, -2
+	}
+	if message, ret := m.checkConversationMember(userId, root.ConversationId); ret != 0 {
+		return message, nil, ret
+	}
+	query := dao.GormDB.Where("thread_id = ?", root.Uuid)
+	messageList, hasMore, message, ret := m.pageMessages(query, req.Before, req.After, getPageSize(req.PageSize))
+	if ret != 0 {
+		return message, nil, ret
+	}
+	rspList := make([]respond.GetGroupMessageListRespond, 0, len(messageList)+1)
+	for _, message := range messageList {
+		rspList = append(rspList, toGroupMessageRespond(message))
+	}
+	// 根消息和回复一起查表情回应
+	rspList = append([]respond.GetGroupMessageListRespond{toGroupMessageRespond(root)}, rspList...)
+	attachGroupViewerState(userId, rspList)
+	return "获取话题成功", &respond.ThreadMessagePageRespond{
+		Root:     rspList[0],
+		Messages: rspList[1:],
+		HasMore:  hasMore,
+	}, 0
+}
+
+// checkConversationMember 检查用户是否属于该会话，单聊会话标识中包含双方uuid，群聊需要是群成员
+func (m *messageService) checkConversationMember(userId string, conversationId string) (string, int) {
+	if conversationId == "" {
+		return "会话不存在", -2
+	}
+	if conversationId[0] == 'G' {
+		var group model.GroupInfo
+		if res := dao.GormDB.Where("uuid = ?", conversationId).First(&group); res.Error != nil {
+			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+				return "群聊不存在", -2
+			}
+			zlog.Error(res.Error.Error())
+			return constants.SYSTEM_ERROR, -1
+		}
+		isMember, err := GroupInfoService.IsGroupMember(conversationId, userId)
+		if err != nil {
 			zlog.Error(err.Error())
+			return constants.SYSTEM_ERROR, -1
+		}
+		if !isMember {
+			return "不是该群聊成员", -3
+		}
+		return "", 0
+	}
+	for _, id := range strings.Split(conversationId, "_") {
+		if id == userId {
+			return "", 0
+		}
+	}
+	return "无权查看该会话", -3
+}
+
+// GetMessageListAfterSeq 获取会话中seq之后的消息，用于重连后从最后确认的位置补齐
+func (m *messageService) GetMessageListAfterSeq(userId string, req request.GetMessageListAfterSeqRequest) (string, []respond.GetMessageListRespond, int) {
+	if message, ret := m.checkConversationMember(userId, req.ConversationId); ret != 0 {
+		return message, nil, ret
+	}
+	limit := req.Limit
+	if limit <= 0 || limit > constants.SYNC_LIMIT {
+		limit = constants.SYNC_LIMIT
+	}
+	var messageList []model.Message
+	if res := dao.GormDB.Where("conversation_id = ? AND seq > ?", req.ConversationId, req.Seq).Order("seq ASC").Limit(limit).Find(&messageList); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	rspList := make([]respond.GetMessageListRespond, 0, len(messageList))
+	for _, message := range messageList {
+		rspList = append(rspList, toMessageRespond(message))
+	}
+	attachViewerState(userId, rspList)
+	return "获取聊天记录成功", rspList, 0
+}
+
+// getJoinedGroupIds 获取用户当前所在的群聊，退群和被踢出的不算
+func (m *messageService) getJoinedGroupIds(userId string) ([]string, error) {
+	var groupIds []string
+	if res := dao.GormDB.Model(&model.UserContact{}).
+		Where("user_id = ? AND contact_type = ? AND status NOT IN ?", userId, contact_type_enum.GROUP, []int8{contact_status_enum.QUIT_GROUP, contact_status_enum.KICK_OUT_GROUP}).
+		Pluck("contact_id", &groupIds); res.Error != nil {
+		return nil, res.Error
+	}
+	return groupIds, nil
+}
+
+// SyncMessages 获取用户所有会话中游标之后的消息，按消息自增id递增返回
+// 没有游标时从上次离线开始，同时带上还没有送达给自己的消息
+func (m *messageService) SyncMessages(userId string, req request.SyncMessageRequest) (string, *respond.SyncMessageRespond, int) {
+	limit := req.Limit
+	if limit <= 0 || limit > constants.SYNC_LIMIT {
+		limit = constants.SYNC_LIMIT
+	}
+	groupIds, err := m.getJoinedGroupIds(userId)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	scope := dao.GormDB.Where("send_id = ? OR receive_id = ?", userId, userId)
+	if len(groupIds) > 0 {
+		scope = scope.Or("receive_id IN ?", groupIds)
+	}
+	query := dao.GormDB.Where(scope)
+	if req.Cursor > 0 {
+		query = query.Where("id > ?", req.Cursor)
+	} else {
+		var user model.UserInfo
+		if res := dao.GormDB.Where("uuid = ?", userId).First(&user); res.Error != nil {
+			zlog.Error(res.Error.Error())
 			return constants.SYSTEM_ERROR, nil, -1
 		}
+		if user.LastOfflineAt.Valid {
+			query = query.Where("created_at > ? OR (receive_id = ? AND status < ?)", user.LastOfflineAt.Time, userId, message_status_enum.Delivered)
+		} else {
+			query = query.Where("receive_id = ? AND status < ?", userId, message_status_enum.Delivered)
+		}
+	}
+	var messageList []model.Message
+	// 多取一条判断是否还有更多
+	if res := query.Order("id ASC").Limit(limit + 1).Find(&messageList); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	rsp := &respond.SyncMessageRespond{
+		Messages:   make([]respond.GetMessageListRespond, 0, len(messageList)),
+		NextCursor: req.Cursor,
+	}
+	if len(messageList) > limit {
+		messageList = messageList[:limit]
+		rsp.HasMore = true
+	}
+	for _, message := range messageList {
+		rsp.Messages = append(rsp.Messages, toMessageRespond(message))
+		rsp.NextCursor = message.Id
 	}
-	var rsp []respond.GetMessageListRespond
-	if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
+	attachViewerState(userId, rsp.Messages)
+	return "同步消息成功", rsp, 0
+}
+
+// GetPendingSummary 获取用户每个会话中还没有送达的消息数
+// 单聊看消息的status，群聊消息有多个接收者，看自己的确认进度
+func (m *messageService) GetPendingSummary(userId string) (string, []respond.PendingSessionRespond, int) {
+	var sessionList []model.Session
+	if res := dao.GormDB.Where("send_id = ?", userId).Find(&sessionList); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	sessionIds := make(map[string]string, len(sessionList))
+	muted := make(map[string]bool)
+	now := time.Now()
+	for _, session := range sessionList {
+		sessionIds[session.ReceiveId] = session.Uuid
+		muted[session.ReceiveId] = isMuted(&session, now)
+	}
+
+	var userPending []struct {
+		SendId         string
+		ConversationId string
+		PendingCount   int64
+		LastSeq        int64
+	}
+	if res := dao.GormDB.Model(&model.Message{}).
+		Select("send_id, conversation_id, COUNT(*) AS pending_count, MAX(seq) AS last_seq").
+		Where("receive_id = ? AND status < ?", userId, message_status_enum.Delivered).
+		Group("send_id, conversation_id").
+		Scan(&userPending); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	summary := make([]respond.PendingSessionRespond, 0, len(userPending))
+	for _, pending := range userPending {
+		summary = append(summary, respond.PendingSessionRespond{
+			SessionId:      sessionIds[pending.SendId],
+			ConversationId: pending.ConversationId,
+			ContactId:      pending.SendId,
+			PendingCount:   pending.PendingCount,
+			LastSeq:        pending.LastSeq,
+			Muted:          muted[pending.SendId],
+		})
+	}
+
+	groupIds, err := m.getJoinedGroupIds(userId)
+	if err != nil {
 		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	for _, groupId := range groupIds {
+		var cursor model.MessageCursor
+		if res := dao.GormDB.Where("user_id = ? AND conversation_id = ?", userId, groupId).First(&cursor); res.Error != nil && !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			zlog.Error(res.Error.Error())
+			return constants.SYSTEM_ERROR, nil, -1
+		}
+		var groupPending struct {
+			PendingCount int64
+			LastSeq      int64
+		}
+		if res := dao.GormDB.Model(&model.Message{}).
+			Select("COUNT(*) AS pending_count, COALESCE(MAX(seq), 0) AS last_seq").
+			Where("conversation_id = ? AND seq > ? AND send_id <> ?", groupId, cursor.AckSeq, userId).
+			Scan(&groupPending); res.Error != nil {
+			zlog.Error(res.Error.Error())
+			return constants.SYSTEM_ERROR, nil, -1
+		}
+		if groupPending.PendingCount == 0 {
+			continue
+		}
+		summary = append(summary, respond.PendingSessionRespond{
+			SessionId:      sessionIds[groupId],
+			ConversationId: groupId,
+			ContactId:      groupId,
+			PendingCount:   groupPending.PendingCount,
+			LastSeq:        groupPending.LastSeq,
+			Muted:          muted[groupId],
+		})
+	}
+	return "获取未送达消息成功", summary, 0
+}
+
+// AckMessages 客户端确认收到会话中seq及之前的消息，推进确认进度，单聊中发给自己的消息改为已送达
+func (m *messageService) AckMessages(userId string, conversationId string, seq int64) (string, int) {
+	if seq <= 0 {
+		return "确认序号不合法", -2
+	}
+	if message, ret := m.checkConversationMember(userId, conversationId); ret != 0 {
+		return message, ret
+	}
+	now := time.Now()
+	cursor := model.MessageCursor{
+		UserId:         userId,
+		ConversationId: conversationId,
+		AckSeq:         seq,
+		UpdatedAt:      now,
+	}
+	// 确认进度只前进不后退，乱序到达的旧确认不会覆盖新的
+	if res := dao.GormDB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "conversation_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"ack_seq":    gorm.Expr("GREATEST(ack_seq, ?)", seq),
+			"updated_at": now,
+		}),
+	}).Create(&cursor); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
 	}
-	return "获取群聊记录成功", rsp, 0
+	// 群聊消息有多个接收者，送达情况只看各自的确认进度
+	if conversationId[0] != 'G' {
+		if res := dao.GormDB.Model(&model.Message{}).
+			Where("conversation_id = ? AND receive_id = ? AND seq <= ? AND status < ?", conversationId, userId, seq, message_status_enum.Delivered).
+			Update("status", message_status_enum.Delivered); res.Error != nil {
+			zlog.Error(res.Error.Error())
+			return constants.SYSTEM_ERROR, -1
+		}
+	}
+	return "确认成功", 0
 }
 
-// GetGroupMessageList 获取群聊消息记录
-func (m *messageService) GetGroupMessageList(groupId string) (string, []respond.GetGroupMessageListRespond, int) {
-	rspString, err := myredis.GetKeyNilIsErr("group_messagelist_" + groupId)
+// ReadMessages 标记会话中messageUuid及之前的消息为已读，已读进度只前进不后退
+// 返回已读到的消息，单聊时由调用方通知对方
+func (m *messageService) ReadMessages(userId string, conversationId string, messageUuid string) (string, *model.Message, int) {
+	if message, ret := m.checkConversationMember(userId, conversationId); ret != 0 {
+		return message, nil, ret
+	}
+	var readMessage model.Message
+	if res := dao.GormDB.Where("uuid = ? AND conversation_id = ?", messageUuid, conversationId).First(&readMessage); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return "消息不存在", nil, -2
+		}
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	now := time.Now()
+	cursor := model.MessageCursor{
+		UserId:         userId,
+		ConversationId: conversationId,
+		AckSeq:         readMessage.Seq,
+		ReadSeq:        readMessage.Seq,
+		UpdatedAt:      now,
+	}
+	// 已读的消息一定已经收到了，确认进度一起推进
+	if res := dao.GormDB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "conversation_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"ack_seq":    gorm.Expr("GREATEST(ack_seq, ?)", readMessage.Seq),
+			"read_seq":   gorm.Expr("GREATEST(read_seq, ?)", readMessage.Seq),
+			"updated_at": now,
+		}),
+	}).Create(&cursor); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if conversationId[0] != 'G' {
+		if res := dao.GormDB.Model(&model.Message{}).
+			Where("conversation_id = ? AND receive_id = ? AND seq <= ? AND status < ?", conversationId, userId, readMessage.Seq, message_status_enum.Read).
+			Update("status", message_status_enum.Read); res.Error != nil {
+			zlog.Error(res.Error.Error())
+			return constants.SYSTEM_ERROR, nil, -1
+		}
+	}
+	return "已读成功", &readMessage, 0
+}
+
+// GetUnreadCounts 获取用户在各个会话中的未读数，自己发的不算未读
+func (m *messageService) GetUnreadCounts(userId string, conversationIds []string) (map[string]int64, error) {
+	unreadCounts := make(map[string]int64, len(conversationIds))
+	if len(conversationIds) == 0 {
+		return unreadCounts, nil
+	}
+	var rows []struct {
+		ConversationId string
+		UnreadCount    int64
+	}
+	if res := dao.GormDB.Table("message AS m").
+		Select("m.conversation_id, COUNT(*) AS unread_count").
+		Joins("LEFT JOIN message_cursor AS c ON c.conversation_id = m.conversation_id AND c.user_id = ?", userId).
+		Where("m.conversation_id IN ? AND m.send_id <> ? AND m.seq > COALESCE(c.read_seq, 0)", conversationIds, userId).
+		Group("m.conversation_id").
+		Scan(&rows); res.Error != nil {
+		return nil, res.Error
+	}
+	for _, row := range rows {
+		unreadCounts[row.ConversationId] = row.UnreadCount
+	}
+	return unreadCounts, nil
+}
+
+// GetMentionedGroups 获取有未读消息@了自己的群聊，已读进度越过这条消息后不再算
+func (m *messageService) GetMentionedGroups(userId string, groupIds []string) (map[string]bool, error) {
+	mentioned := make(map[string]bool, len(groupIds))
+	if len(groupIds) == 0 {
+		return mentioned, nil
+	}
+	var mentionedIds []string
+	if res := dao.GormDB.Table("message_mention AS mm").
+		Joins("LEFT JOIN message_cursor AS c ON c.conversation_id = mm.group_id AND c.user_id = mm.user_id").
+		Where("mm.user_id = ? AND mm.group_id IN ? AND mm.seq > COALESCE(c.read_seq, 0)", userId, groupIds).
+		Distinct().
+		Pluck("mm.group_id", &mentionedIds); res.Error != nil {
+		return nil, res.Error
+	}
+	for _, groupId := range mentionedIds {
+		mentioned[groupId] = true
+	}
+	return mentioned, nil
+}
+
+// GetMentionList 分页获取@了自己的群聊消息，从新到旧，只包括仍在其中的群聊
+func (m *messageService) GetMentionList(userId string, req request.GetMentionListRequest) (string, *respond.GroupMessagePageRespond, int) {
+	groupIds, err := m.getJoinedGroupIds(userId)
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			var messageList []model.Message
-			if res := dao.GormDB.Where("receive_id = ?", groupId).Order("created_at ASC").Find(&messageList); res.Error != nil {
-				zlog.Error(res.Error.Error())
-				return constants.SYSTEM_ERROR, nil, -1
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if len(groupIds) == 0 {
+		return "获取成功", &respond.GroupMessagePageRespond{
+			Messages: []respond.GetGroupMessageListRespond{},
+		}, 0
+	}
+	mentionQuery := dao.GormDB.Model(&model.MessageMention{}).Select("message_uuid").Where("user_id = ?", userId)
+	query := dao.GormDB.Where("uuid IN (?) AND receive_id IN ?", mentionQuery, groupIds)
+	messageList, hasMore, message, ret := m.pageMessages(query, req.Before, req.After, getPageSize(req.PageSize))
+	if ret != 0 {
+		return message, nil, ret
+	}
+	rspList := make([]respond.GetGroupMessageListRespond, 0, len(messageList))
+	for _, message := range messageList {
+		rspList = append(rspList, toGroupMessageRespond(message))
+	}
+	attachGroupViewerState(userId, rspList)
+	return "获取成功", &respond.GroupMessagePageRespond{
+		Messages: rspList,
+		HasMore:  hasMore,
+	}, 0
+}
+
+// GetGroupReadCounts 获取群聊消息的已读人数，只统计仍在群里的成员，不包括发送者
+func (m *messageService) GetGroupReadCounts(userId string, req request.GetGroupReadCountsRequest) (string, []respond.GroupReadCountRespond, int) {
+	if message, ret := m.checkConversationMember(userId, req.GroupId); ret != 0 {
+		return message, nil, ret
+	}
+	members, err := GroupInfoService.GetGroupMemberIds(req.GroupId)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	var messageList []model.Message
+	if len(req.MessageUuids) > 0 {
+		if res := dao.GormDB.Where("uuid IN ? AND conversation_id = ?", req.MessageUuids, req.GroupId).Find(&messageList); res.Error != nil {
+			zlog.Error(res.Error.Error())
+			return constants.SYSTEM_ERROR, nil, -1
+		}
+	}
+	rsp := make([]respond.GroupReadCountRespond, 0, len(messageList))
+	for _, message := range messageList {
+		var readCount int64
+		if res := dao.GormDB.Model(&model.MessageCursor{}).
+			Where("conversation_id = ? AND read_seq >= ? AND user_id <> ? AND user_id IN ?", req.GroupId, message.Seq, message.SendId, members).
+			Count(&readCount); res.Error != nil {
+			zlog.Error(res.Error.Error())
+			return constants.SYSTEM_ERROR, nil, -1
+		}
+		memberCount := int64(len(members))
+		for _, member := range members {
+			if member == message.SendId {
+				memberCount--
+				break
 			}
-			var rspList []respond.GetGroupMessageListRespond
-			for _, message := range messageList {
-				rsp := respond.GetGroupMessageListRespond{
-					SendId:     message.SendId,
-					SendName:   message.SendName,
-					SendAvatar: message.SendAvatar,
-					ReceiveId:  message.ReceiveId,
-					Content:    message.Content,
-					Url:        message.Url,
-					Type:       message.Type,
-					FileType:   message.FileType,
-					FileName:   message.FileName,
-					FileSize:   message.FileSize,
-					CreatedAt:  message.CreatedAt.Format("2006-01-02 15:04:05"),
-				}
-				rspList = append(rspList, rsp)
+		}
+		rsp = append(rsp, respond.GroupReadCountRespond{
+			MessageUuid: message.Uuid,
+			ReadCount:   readCount,
+			MemberCount: memberCount,
+		})
+	}
+	return "获取已读人数成功", rsp, 0
+}
+
+// getWindowKeys 消息所在的聊天记录缓存窗口
+func getWindowKeys(message *model.Message) []string {
+	if message.ReceiveId[0] == 'G' {
+		return []string{"group_messagelist_" + message.ReceiveId}
+	}
+	return []string{
+		"message_list_" + message.SendId + "_" + message.ReceiveId,
+		"message_list_" + message.ReceiveId + "_" + message.SendId,
+	}
+}
+
+// patchWindows 把缓存窗口中的这条消息替换成最新的状态
+func patchWindows(message *model.Message) {
+	var rsp interface{} = toMessageRespond(*message)
+	if message.ReceiveId[0] == 'G' {
+		rsp = toGroupMessageRespond(*message)
+	}
+	rspBytes, err := json.Marshal(rsp)
+	if err != nil {
+		zlog.Error(err.Error())
+		return
+	}
+	for _, key := range getWindowKeys(message) {
+		if err := myredis.ReplaceInWindow(key, message.Uuid, string(rspBytes)); err != nil {
+			zlog.Error(err.Error())
+		}
+	}
+}
+
+// RecallMessage 撤回消息，发送者只能撤回配置时间内的消息，群主和管理员可以撤回群里任意消息
+func (m *messageService) RecallMessage(operatorId string, messageUuid string) (string, *respond.RecallEventRespond, int) {
+	var message model.Message
+	if res := dao.GormDB.Where("uuid = ?", messageUuid).First(&message); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return "消息不存在", nil, -2
+		}
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if message.RecalledAt.Valid {
+		return "消息已撤回", nil, -2
+	}
+	if message.Type == message_type_enum.AudioOrVideo {
+		return "通话记录不能撤回", nil, -2
+	}
+	if message.Type == message_type_enum.System {
+		return "系统通知不能撤回", nil, -2
+	}
+	isManager := false
+	if message.ReceiveId[0] == 'G' {
+		var err error
+		if isManager, err = GroupInfoService.IsGroupManager(message.ReceiveId, operatorId); err != nil {
+			zlog.Error(err.Error())
+			return constants.SYSTEM_ERROR, nil, -1
+		}
+	}
+	if !isManager {
+		if message.SendId != operatorId {
+			return "只能撤回自己发送的消息", nil, -3
+		}
+		if time.Since(message.CreatedAt) > config.GetConfig().MessageConfig.RecallWindow*time.Minute {
+			return "超过可撤回时间", nil, -2
+		}
+	}
+	now := time.Now()
+	// 条件中带上recalled_at，并发撤回时只有一次成功
+	res := dao.GormDB.Model(&model.Message{}).Where("uuid = ? AND recalled_at IS NULL", messageUuid).Updates(map[string]interface{}{
+		"recalled_at": now,
+		"recalled_by": operatorId,
+	})
+	if res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if res.RowsAffected == 0 {
+		return "消息已撤回", nil, -2
+	}
+	// 撤回后不再提醒被@的成员
+	if res := dao.GormDB.Where("message_uuid = ?", messageUuid).Delete(&model.MessageMention{}); res.Error != nil {
+		zlog.Error(res.Error.Error())
+	}
+	message.RecalledAt = sql.NullTime{Time: now, Valid: true}
+	message.RecalledBy = operatorId
+	patchWindows(&message)
+	SearchService.RemoveMessage(message.Uuid)
+	return "撤回成功", &respond.RecallEventRespond{
+		Event:          ws_event_enum.Recall,
+		MessageUuid:    message.Uuid,
+		ConversationId: message.ConversationId,
+		SendId:         message.SendId,
+		ReceiveId:      message.ReceiveId,
+		OperatorId:     operatorId,
+		RecalledAt:     now.Format("2006-01-02 15:04:05"),
+	}, 0
+}
+
+// EditMessage 编辑文本消息，编辑前的内容记入message_revision
+func (m *messageService) EditMessage(operatorId string, req request.EditMessageRequest) (string, *respond.EditEventRespond, int) {
+	messageConfig := config.GetConfig().MessageConfig
+	if !messageConfig.AllowEdit {
+		return "暂不支持编辑消息", nil, -2
+	}
+	if req.Content == "" {
+		return "消息内容不能为空", nil, -2
+	}
+	var message model.Message
+	if res := dao.GormDB.Where("uuid = ?", req.MessageUuid).First(&message); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return "消息不存在", nil, -2
+		}
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if message.SendId != operatorId {
+		return "只能编辑自己发送的消息", nil, -3
+	}
+	if message.RecalledAt.Valid {
+		return "消息已撤回", nil, -2
+	}
+	if message.Type != message_type_enum.Text {
+		return "只能编辑文本消息", nil, -2
+	}
+	if messageConfig.EditWindow > 0 && time.Since(message.CreatedAt) > messageConfig.EditWindow*time.Minute {
+		return "超过可编辑时间", nil, -2
+	}
+	if message.Content == req.Content {
+		return "消息内容没有变化", nil, -2
+	}
+	if messageConfig.MaxEditCount > 0 {
+		var editCount int64
+		if res := dao.GormDB.Model(&model.MessageRevision{}).Where("message_uuid = ?", message.Uuid).Count(&editCount); res.Error != nil {
+			zlog.Error(res.Error.Error())
+			return constants.SYSTEM_ERROR, nil, -1
+		}
+		if editCount >= int64(messageConfig.MaxEditCount) {
+			return "超过最大编辑次数", nil, -2
+		}
+	}
+	now := time.Now()
+	err := dao.GormDB.Transaction(func(tx *gorm.DB) error {
+		// 条件中带上旧内容，并发编辑时只有一次成功，避免历史版本错乱
+		res := tx.Model(&model.Message{}).Where("uuid = ? AND content = ? AND recalled_at IS NULL", message.Uuid, message.Content).Updates(map[string]interface{}{
+			"content":   req.Content,
+			"edited_at": now,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Create(&model.MessageRevision{
+			MessageUuid: message.Uuid,
+			Content:     message.Content,
+			EditorId:    operatorId,
+			CreatedAt:   now,
+		}).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "消息已被修改，请刷新后重试", nil, -2
+		}
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	message.Content = req.Content
+	message.EditedAt = sql.NullTime{Time: now, Valid: true}
+	patchWindows(&message)
+	SearchService.IndexMessage(&message)
+	return "编辑成功", &respond.EditEventRespond{
+		Event:          ws_event_enum.Edit,
+		MessageUuid:    message.Uuid,
+		ConversationId: message.ConversationId,
+		SendId:         message.SendId,
+		ReceiveId:      message.ReceiveId,
+		Content:        message.Content,
+		EditedAt:       now.Format("2006-01-02 15:04:05"),
+	}, 0
+}
+
+// GetMessageRevisions 获取消息的编辑历史，从旧到新
+func (m *messageService) GetMessageRevisions(userId string, messageUuid string) (string, []respond.GetMessageRevisionsRespond, int) {
+	var message model.Message
+	if res := dao.GormDB.Where("uuid = ?", messageUuid).First(&message); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return "消息不存在", nil, -2
+		}
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if message.RecalledAt.Valid {
+		return "消息已撤回", nil, -2
+	}
+	if msg, ret := m.checkConversationMember(userId, message.ConversationId); ret != 0 {
+		return msg, nil, ret
+	}
+	var revisionList []model.MessageRevision
+	if res := dao.GormDB.Where("message_uuid = ?", messageUuid).Order("id ASC").Find(&revisionList); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	rsp := make([]respond.GetMessageRevisionsRespond, 0, len(revisionList))
+	for _, revision := range revisionList {
+		rsp = append(rsp, respond.GetMessageRevisionsRespond{
+			Content:   revision.Content,
+			EditorId:  revision.EditorId,
+			CreatedAt: revision.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+	return "获取成功", rsp, 0
+}
+
+// getReactions 按消息汇总表情回应，按表情第一次出现的顺序排列
+func getReactions(userId string, messageUuids []string) map[string][]respond.ReactionRespond {
+	if len(messageUuids) == 0 {
+		return nil
+	}
+	var rows []struct {
+		MessageUuid string
+		Emoji       string
+		Count       int64
+		Reacted     bool
+	}
+	if res := dao.GormDB.Model(&model.MessageReaction{}).
+		Select("message_uuid, emoji, COUNT(*) AS count, MAX(user_id = ?) AS reacted", userId).
+		Where("message_uuid IN ?", messageUuids).
+		Group("message_uuid, emoji").
+		Order("MIN(id) ASC").
+		Scan(&rows); res.Error != nil {
+		// 表情回应不影响聊天记录的展示
+		zlog.Error(res.Error.Error())
+		return nil
+	}
+	reactions := make(map[string][]respond.ReactionRespond)
+	for _, row := range rows {
+		reactions[row.MessageUuid] = append(reactions[row.MessageUuid], respond.ReactionRespond{
+			Emoji:   row.Emoji,
+			Count:   row.Count,
+			Reacted: row.Reacted,
+		})
+	}
+	return reactions
+}
+
+// getListened 查询语音的收听状态，listeners是每条语音要看的收听者
+func getListened(listeners map[string]string) map[string]bool {
+	listened := make(map[string]bool)
+	if len(listeners) == 0 {
+		return listened
+	}
+	messageUuids := make([]string, 0, len(listeners))
+	userIds := make([]string, 0, len(listeners))
+	for messageUuid, userId := range listeners {
+		messageUuids = append(messageUuids, messageUuid)
+		userIds = append(userIds, userId)
+	}
+	var listenList []model.VoiceListen
+	if res := dao.GormDB.Select("message_uuid", "user_id").Where("message_uuid IN ? AND user_id IN ?", messageUuids, userIds).Find(&listenList); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return listened
+	}
+	for _, listen := range listenList {
+		if listeners[listen.MessageUuid] == listen.UserId {
+			listened[listen.MessageUuid] = true
+		}
+	}
+	return listened
+}
+
+// voiceListener 自己发的单聊语音看对方是否听过，群聊中自己发的不看，其余看自己是否听过
+func voiceListener(userId string, sendId string, receiveId string) string {
+	if sendId != userId {
+		return userId
+	}
+	if receiveId[0] == 'G' {
+		return ""
+	}
+	return receiveId
+}
+
+// attachViewerState 给单聊记录附上因人而异的表情回应和语音收听状态，缓存窗口中不存这部分
+func attachViewerState(userId string, rspList []respond.GetMessageListRespond) {
+	messageUuids := make([]string, 0, len(rspList))
+	listeners := make(map[string]string)
+	for _, rsp := range rspList {
+		messageUuids = append(messageUuids, rsp.Uuid)
+		if rsp.Type == message_type_enum.Voice && !rsp.Recalled {
+			if listener := voiceListener(userId, rsp.SendId, rsp.ReceiveId); listener != "" {
+				listeners[rsp.Uuid] = listener
 			}
-			//rspString, err := json.Marshal(rspList)
-			//if err != nil {
-			//	zlog.Error(err.Error())
-			//}
-			//if err := myredis.SetKeyEx("group_messagelist_"+groupId, string(rspString), time.Minute*constants.REDIS_TIMEOUT); err != nil {
-			//	zlog.Error(err.Error())
-			//}
-			return "获取聊天记录成功", rspList, 0
-		} else {
+		}
+	}
+	reactions := getReactions(userId, messageUuids)
+	listened := getListened(listeners)
+	for i := range rspList {
+		rspList[i].Reactions = reactions[rspList[i].Uuid]
+		rspList[i].Listened = listened[rspList[i].Uuid]
+	}
+}
+
+// attachGroupViewerState 给群聊记录附上因人而异的表情回应和语音收听状态，缓存窗口中不存这部分
+func attachGroupViewerState(userId string, rspList []respond.GetGroupMessageListRespond) {
+	messageUuids := make([]string, 0, len(rspList))
+	listeners := make(map[string]string)
+	for _, rsp := range rspList {
+		messageUuids = append(messageUuids, rsp.Uuid)
+		if rsp.Type == message_type_enum.Voice && !rsp.Recalled {
+			if listener := voiceListener(userId, rsp.SendId, rsp.ReceiveId); listener != "" {
+				listeners[rsp.Uuid] = listener
+			}
+		}
+	}
+	reactions := getReactions(userId, messageUuids)
+	listened := getListened(listeners)
+	for i := range rspList {
+		rspList[i].Reactions = reactions[rspList[i].Uuid]
+		rspList[i].Listened = listened[rspList[i].Uuid]
+	}
+}
+
+// ReactMessage 添加或取消表情回应，只记在message_reaction表，不产生新消息，也不更新会话的最新消息
+func (m *messageService) ReactMessage(userId string, req request.ReactMessageRequest, removed bool) (string, *respond.ReactionEventRespond, int) {
+	if req.Emoji == "" || utf8.RuneCountInString(req.Emoji) > 16 {
+		return "表情不合法", nil, -2
+	}
+	var message model.Message
+	if res := dao.GormDB.Where("uuid = ?", req.MessageUuid).First(&message); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return "消息不存在", nil, -2
+		}
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if msg, ret := m.checkConversationMember(userId, message.ConversationId); ret != 0 {
+		return msg, nil, ret
+	}
+	if message.RecalledAt.Valid {
+		return "消息已撤回", nil, -2
+	}
+	if removed {
+		res := dao.GormDB.Where("message_uuid = ? AND user_id = ? AND emoji = ?", message.Uuid, userId, req.Emoji).Delete(&model.MessageReaction{})
+		if res.Error != nil {
+			zlog.Error(res.Error.Error())
+			return constants.SYSTEM_ERROR, nil, -1
+		}
+		if res.RowsAffected == 0 {
+			return "没有添加过该表情", nil, -2
+		}
+	} else {
+		res := dao.GormDB.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.MessageReaction{
+			MessageUuid:    message.Uuid,
+			UserId:         userId,
+			Emoji:          req.Emoji,
+			ConversationId: message.ConversationId,
+			CreatedAt:      time.Now(),
+		})
+		if res.Error != nil {
+			zlog.Error(res.Error.Error())
+			return constants.SYSTEM_ERROR, nil, -1
+		}
+		if res.RowsAffected == 0 {
+			return "已经添加过该表情", nil, -2
+		}
+	}
+	return "操作成功", &respond.ReactionEventRespond{
+		Event:          ws_event_enum.Reaction,
+		MessageUuid:    message.Uuid,
+		ConversationId: message.ConversationId,
+		SendId:         message.SendId,
+		ReceiveId:      message.ReceiveId,
+		UserId:         userId,
+		Emoji:          req.Emoji,
+		Removed:        removed,
+	}, 0
+}
+
+// ForwardMessages 校验要转发的消息和目标会话，生成要发送的聊天消息，由调用方交给消息流水线发送
+// 逐条转发时每个目标每条消息各一条，合并转发时每个目标一条聊天记录卡片
+func (m *messageService) ForwardMessages(userId string, req request.ForwardMessageRequest) (string, []request.ChatMessageRequest, int) {
+	if len(req.MessageUuids) == 0 || len(req.Targets) == 0 {
+		return "请选择要转发的消息和目标", nil, -2
+	}
+	if len(req.MessageUuids) > constants.FORWARD_LIMIT {
+		return fmt.Sprintf("一次最多转发%d条消息", constants.FORWARD_LIMIT), nil, -2
+	}
+	if len(req.Targets) > constants.FORWARD_TARGETS {
+		return fmt.Sprintf("一次最多转发给%d个会话", constants.FORWARD_TARGETS), nil, -2
+	}
+	var messageList []model.Message
+	if res := dao.GormDB.Where("uuid IN ?", req.MessageUuids).Order("id ASC").Find(&messageList); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if len(messageList) == 0 || len(messageList) != len(req.MessageUuids) {
+		return "消息不存在", nil, -2
+	}
+	checked := make(map[string]bool)
+	for _, message := range messageList {
+		if message.RecalledAt.Valid {
+			return "消息已撤回", nil, -2
+		}
+		if message.Type == message_type_enum.AudioOrVideo {
+			return "通话记录不能转发", nil, -2
+		}
+		if message.Type == message_type_enum.System {
+			return "系统通知不能转发", nil, -2
+		}
+		if req.Merge {
+			if message.Type == message_type_enum.ChatHistory {
+				return "聊天记录不能再合并转发", nil, -2
+			}
+			if message.ConversationId != messageList[0].ConversationId {
+				return "只能合并转发同一会话中的消息", nil, -2
+			}
+		}
+		if !checked[message.ConversationId] {
+			if msg, ret := m.checkConversationMember(userId, message.ConversationId); ret != 0 {
+				return msg, nil, ret
+			}
+			checked[message.ConversationId] = true
+		}
+	}
+
+	var user model.UserInfo
+	if res := dao.GormDB.Where("uuid = ?", userId).First(&user); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if !req.Merge {
+		if err := grantForwardedVoices(userId, messageList); err != nil {
+			zlog.Error(err.Error())
+			return constants.SYSTEM_ERROR, nil, -1
+		}
+	}
+	var contents []request.ChatMessageRequest
+	if req.Merge {
+		content, err := m.newChatHistoryContent(messageList)
+		if err != nil {
 			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, nil, -1
 		}
+		contents = append(contents, request.ChatMessageRequest{
+			Type:    message_type_enum.ChatHistory,
+			Content: content,
+		})
+	} else {
+		for _, message := range messageList {
+			contents = append(contents, request.ChatMessageRequest{
+				Type:     message.Type,
+				Content:  message.Content,
+				Url:      message.Url,
+				FileSize: message.FileSize,
+				FileType: message.FileType,
+				FileName: message.FileName,
+				Duration: message.Duration,
+				Waveform: dispatcher.Waveform(&message),
+			})
+		}
+	}
+
+	var chatMessageReqs []request.ChatMessageRequest
+	targeted := make(map[string]bool)
+	for _, target := range req.Targets {
+		if target == "" || targeted[target] {
+			continue
+		}
+		targeted[target] = true
+		if target[0] == 'G' {
+			if msg, ret := m.checkConversationMember(userId, target); ret != 0 {
+				return msg, nil, ret
+			}
+		}
+		if msg, _, ret := SessionService.CheckOpenSessionAllowed(userId, target); ret != 0 {
+			return msg, nil, ret
+		}
+		var session model.Session
+		if res := dao.GormDB.Where("send_id = ? AND receive_id = ?", userId, target).First(&session); res.Error != nil && !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			zlog.Error(res.Error.Error())
+			return constants.SYSTEM_ERROR, nil, -1
+		}
+		for _, content := range contents {
+			content.SessionId = session.Uuid
+			content.SendId = userId
+			content.SendName = user.Nickname
+			content.SendAvatar = user.Avatar
+			content.ReceiveId = target
+			chatMessageReqs = append(chatMessageReqs, content)
+		}
+	}
+	return "转发成功", chatMessageReqs, 0
+}
+
+// grantForwardedVoices 逐条转发语音时，让转发者也可以发送原语音文件，时长和波形沿用原消息
+func grantForwardedVoices(userId string, messageList []model.Message) error {
+	var uploadList []model.VoiceUpload
+	for _, message := range messageList {
+		if message.Type != message_type_enum.Voice {
+			continue
+		}
+		fileName, ok := dispatcher.VoiceFileName(message.Url)
+		if !ok {
+			continue
+		}
+		uploadList = append(uploadList, model.VoiceUpload{
+			FileName:  fileName,
+			UserId:    userId,
+			FileSize:  message.FileSize,
+			FileType:  message.FileType,
+			Duration:  message.Duration,
+			Waveform:  message.Waveform,
+			CreatedAt: time.Now(),
+		})
+	}
+	if len(uploadList) == 0 {
+		return nil
+	}
+	return dao.GormDB.Clauses(clause.OnConflict{DoNothing: true}).Create(&uploadList).Error
+}
+
+// newChatHistoryContent 生成聊天记录卡片的content，标题用原会话的名称
+func (m *messageService) newChatHistoryContent(messageList []model.Message) (string, error) {
+	conversationId := messageList[0].ConversationId
+	var title string
+	if conversationId[0] == 'G' {
+		var group model.GroupInfo
+		if res := dao.GormDB.Where("uuid = ?", conversationId).First(&group); res.Error != nil {
+			return "", res.Error
+		}
+		title = fmt.Sprintf("群聊%s的聊天记录", group.Name)
+	} else {
+		var nicknames []string
+		if res := dao.GormDB.Model(&model.UserInfo{}).Where("uuid IN ?", strings.Split(conversationId, "_")).Pluck("nickname", &nicknames); res.Error != nil {
+			return "", res.Error
+		}
+		title = strings.Join(nicknames, "和") + "的聊天记录"
+	}
+	content := respond.ChatHistoryContent{
+		Title:        title,
+		MessageUuids: make([]string, 0, len(messageList)),
+	}
+	for i, message := range messageList {
+		content.MessageUuids = append(content.MessageUuids, message.Uuid)
+		if i < constants.HISTORY_PREVIEWS {
+			content.Previews = append(content.Previews, message.SendName+": "+dispatcher.Preview(&message))
+		}
+	}
+	contentBytes, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	return string(contentBytes), nil
+}
+
+// GetChatHistory 展开聊天记录卡片，能看到卡片的人就能看到其中的消息，不要求是原会话的成员
+func (m *messageService) GetChatHistory(userId string, messageUuid string) (string, []respond.GetMessageListRespond, int) {
+	var card model.Message
+	if res := dao.GormDB.Where("uuid = ?", messageUuid).First(&card); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return "消息不存在", nil, -2
+		}
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if card.Type != message_type_enum.ChatHistory {
+		return "不是聊天记录消息", nil, -2
+	}
+	if card.RecalledAt.Valid {
+		return "消息已撤回", nil, -2
 	}
-	var rsp []respond.GetGroupMessageListRespond
-	if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
+	if msg, ret := m.checkConversationMember(userId, card.ConversationId); ret != 0 {
+		return msg, nil, ret
+	}
+	var content respond.ChatHistoryContent
+	if err := json.Unmarshal([]byte(card.Content), &content); err != nil {
 		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	var messageList []model.Message
+	if len(content.MessageUuids) > 0 {
+		if res := dao.GormDB.Where("uuid IN ?", content.MessageUuids).Order("id ASC").Find(&messageList); res.Error != nil {
+			zlog.Error(res.Error.Error())
+			return constants.SYSTEM_ERROR, nil, -1
+		}
+	}
+	// 合并之后原消息被撤回的，展开时也只显示已撤回
+	rspList := make([]respond.GetMessageListRespond, 0, len(messageList))
+	for _, message := range messageList {
+		rspList = append(rspList, toMessageRespond(message))
+	}
+	return "获取聊天记录成功", rspList, 0
+}
+
+// voiceExts 允许上传的语音文件扩展名
+var voiceExts = map[string]bool{
+	".mp3": true, ".m4a": true, ".aac": true, ".wav": true, ".ogg": true, ".webm": true, ".amr": true,
+}
+
+// voiceContentTypes 按文件头识别出的音频类型，amr识别不出来，单独判断
+var voiceContentTypes = map[string]bool{
+	"audio/mpeg": true, "audio/wave": true, "audio/aiff": true, "application/ogg": true, "video/webm": true, "video/mp4": true,
+}
+
+// isVoiceContent 按文件头判断是不是音频，不相信客户端给的扩展名和Content-Type
+func isVoiceContent(head []byte) bool {
+	if bytes.HasPrefix(head, []byte("#!AMR\n")) {
+		return true
+	}
+	return voiceContentTypes[http.DetectContentType(head)]
+}
+
+// UploadVoice 上传语音文件，校验格式和时长并保存上传记录
+// 发送语音消息时只需要带上返回的地址，时长和波形以上传记录为准
+func (m *messageService) UploadVoice(userId string, c *gin.Context) (string, *respond.UploadVoiceRespond, int) {
+	file, fileHeader, err := c.Request.FormFile("file")
+	if err != nil {
+		zlog.Error(err.Error())
+		return "请选择语音文件", nil, -2
+	}
+	defer file.Close()
+	if fileHeader.Size <= 0 || fileHeader.Size > constants.VOICE_MAX_SIZE {
+		return fmt.Sprintf("语音文件不能超过%dMB", constants.VOICE_MAX_SIZE>>20), nil, -2
+	}
+	ext := strings.ToLower(filepath.Ext(fileHeader.Filename))
+	if !voiceExts[ext] {
+		return "不支持的语音格式", nil, -2
+	}
+	duration, err := strconv.Atoi(c.Request.FormValue("duration"))
+	maxDuration := config.GetConfig().MessageConfig.MaxVoiceDuration
+	if err != nil || duration <= 0 {
+		return "语音时长不合法", nil, -2
+	}
+	if duration > maxDuration {
+		return fmt.Sprintf("语音不能超过%d秒", maxDuration), nil, -2
+	}
+	var waveform []int
+	if value := c.Request.FormValue("waveform"); value != "" {
+		if err := json.Unmarshal([]byte(value), &waveform); err != nil {
+			return "语音波形格式错误", nil, -2
+		}
+	}
+	if err := dispatcher.CheckWaveform(waveform); err != nil {
+		return err.Error(), nil, -2
+	}
+	waveformJson, err := json.Marshal(waveform)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if !isVoiceContent(head[:n]) {
+		return "文件不是有效的音频", nil, -2
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	// 不使用客户端给的文件名，避免重名覆盖和路径穿越
+	voicePath := config.GetConfig().StaticVoicePath
+	if err := os.MkdirAll(voicePath, 0755); err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	fileName := fmt.Sprintf("V%s%s", random.GetNowAndLenRandomString(11), ext)
+	out, err := os.Create(voicePath + "/" + fileName)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, file); err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if res := dao.GormDB.Create(&model.VoiceUpload{
+		FileName:  fileName,
+		UserId:    userId,
+		FileSize:  fmt.Sprintf("%d", fileHeader.Size),
+		FileType:  ext,
+		Duration:  duration,
+		Waveform:  waveformJson,
+		CreatedAt: time.Now(),
+	}); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	return "上传成功", &respond.UploadVoiceRespond{
+		Url:      "/static/voices/" + fileName,
+		FileSize: fmt.Sprintf("%d", fileHeader.Size),
+		FileType: ext,
+		Duration: duration,
+		Waveform: waveform,
+	}, 0
+}
+
+// ListenVoice 标记语音已收听，只在第一次收听时返回要推送给发送者的事件
+func (m *messageService) ListenVoice(userId string, messageUuid string) (string, *respond.ListenedEventRespond, int) {
+	var message model.Message
+	if res := dao.GormDB.Where("uuid = ?", messageUuid).First(&message); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return "消息不存在", nil, -2
+		}
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if msg, ret := m.checkConversationMember(userId, message.ConversationId); ret != 0 {
+		return msg, nil, ret
+	}
+	if message.Type != message_type_enum.Voice {
+		return "不是语音消息", nil, -2
+	}
+	if message.RecalledAt.Valid {
+		return "消息已撤回", nil, -2
+	}
+	if message.SendId == userId {
+		return "不能标记自己发送的语音", nil, -2
+	}
+	res := dao.GormDB.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.VoiceListen{
+		MessageUuid: message.Uuid,
+		UserId:      userId,
+		CreatedAt:   time.Now(),
+	})
+	if res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if res.RowsAffected == 0 {
+		return "已收听", nil, 0
 	}
-	return "获取聊天记录成功", rsp, 0
+	return "已收听", &respond.ListenedEventRespond{
+		Event:          ws_event_enum.Listened,
+		MessageUuid:    message.Uuid,
+		ConversationId: message.ConversationId,
+		SendId:         message.SendId,
+		ListenerId:     userId,
+	}, 0
 }
 
 // UploadAvatar 上传头像
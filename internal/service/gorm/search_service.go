@@ -0,0 +1,280 @@
+package gorm
+
+import (
+	"gorm.io/gorm"
+	"kama_chat_server/internal/config"
+	"kama_chat_server/internal/dao"
+	"kama_chat_server/internal/dto/request"
+	"kama_chat_server/internal/dto/respond"
+	"kama_chat_server/internal/model"
+	"kama_chat_server/internal/service/search"
+	"kama_chat_server/pkg/constants"
+	"kama_chat_server/pkg/enum/contact/contact_type_enum"
+	"kama_chat_server/pkg/enum/message/message_type_enum"
+	"kama_chat_server/pkg/util/conversation"
+	"kama_chat_server/pkg/zlog"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type searchService struct {
+	once  sync.Once
+	index search.Index
+}
+
+var SearchService = new(searchService)
+
+// searchableTypes 可以搜索的消息类型，文本搜内容，文件搜文件名
+var searchableTypes = []int8{message_type_enum.Text, message_type_enum.File}
+
+// getIndex 第一次使用时按配置创建索引
+func (s *searchService) getIndex() search.Index {
+	s.once.Do(func() {
+		switch config.GetConfig().SearchConfig.Engine {
+		case "memory":
+			index := search.NewMemoryIndex()
+			if err := loadMemoryIndex(index); err != nil {
+				zlog.Error(err.Error())
+			}
+			s.index = index
+		default:
+			// 全文索引在启动迁移中创建
+			s.index = &mysqlIndex{}
+		}
+	})
+	return s.index
+}
+
+func toDocument(message *model.Message) search.Document {
+	text := message.Content
+	if message.Type == message_type_enum.File {
+		text = message.FileName
+	}
+	return search.Document{
+		Id:             message.Id,
+		Uuid:           message.Uuid,
+		ConversationId: message.ConversationId,
+		SendId:         message.SendId,
+		ReceiveId:      message.ReceiveId,
+		Type:           message.Type,
+		Text:           text,
+		CreatedAt:      message.CreatedAt,
+	}
+}
+
+// loadMemoryIndex 启动时把已有的消息加载到进程内索引
+func loadMemoryIndex(index *search.MemoryIndex) error {
+	var messageList []model.Message
+	res := dao.GormDB.Where("type IN ? AND recalled_at IS NULL", searchableTypes).
+		FindInBatches(&messageList, 1000, func(tx *gorm.DB, batch int) error {
+			for i := range messageList {
+				if err := index.Add(toDocument(&messageList[i])); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	return res.Error
+}
+
+// IndexMessage 消息存表或编辑后同步到索引，失败只记录日志，不影响消息本身
+func (s *searchService) IndexMessage(message *model.Message) {
+	searchable := false
+	for _, t := range searchableTypes {
+		if message.Type == t {
+			searchable = true
+			break
+		}
+	}
+	if !searchable || message.RecalledAt.Valid {
+		return
+	}
+	if err := s.getIndex().Add(toDocument(message)); err != nil {
+		zlog.Error(err.Error())
+	}
+}
+
+// RemoveMessage 消息撤回后从索引中删除
+func (s *searchService) RemoveMessage(uuid string) {
+	if err := s.getIndex().Remove(uuid); err != nil {
+		zlog.Error(err.Error())
+	}
+}
+
+// getSearchGroups 用户可以搜索的群聊，已经退出或被移出的群聊只能搜到离开之前的消息
+func (s *searchService) getSearchGroups(userId string) (map[string]time.Time, error) {
+	var contactList []model.UserContact
+	if res := dao.GormDB.Unscoped().Where("user_id = ? AND contact_type = ?", userId, contact_type_enum.GROUP).Find(&contactList); res.Error != nil {
+		return nil, res.Error
+	}
+	groups := make(map[string]time.Time, len(contactList))
+	for _, contact := range contactList {
+		limit := time.Time{}
+		if contact.DeletedAt.Valid {
+			limit = contact.DeletedAt.Time
+		}
+		// 退群后又重新加入时会有多条记录，以限制最少的为准
+		if old, ok := groups[contact.ContactId]; ok && (old.IsZero() || (!limit.IsZero() && old.After(limit))) {
+			continue
+		}
+		groups[contact.ContactId] = limit
+	}
+	return groups, nil
+}
+
+func parseSearchTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+}
+
+// SearchMessages 在用户所有会话中搜索消息，按消息从新到旧分页
+func (s *searchService) SearchMessages(userId string, req request.SearchMessageRequest) (string, *respond.SearchMessageRespond, int) {
+	keyword := strings.TrimSpace(req.Keyword)
+	if keyword == "" {
+		return "请输入搜索关键词", nil, -2
+	}
+	startTime, err := parseSearchTime(req.StartTime)
+	if err != nil {
+		return "开始时间格式错误", nil, -2
+	}
+	endTime, err := parseSearchTime(req.EndTime)
+	if err != nil {
+		return "结束时间格式错误", nil, -2
+	}
+	groups, err := s.getSearchGroups(userId)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	pageSize := req.PageSize
+	if pageSize <= 0 {
+		pageSize = constants.SEARCH_PAGE_SIZE
+	} else if pageSize > constants.MAX_PAGE_SIZE {
+		pageSize = constants.MAX_PAGE_SIZE
+	}
+	query := search.Query{
+		Keyword:   keyword,
+		UserId:    userId,
+		Groups:    groups,
+		SendId:    req.SendId,
+		Types:     searchableTypes,
+		StartTime: startTime,
+		EndTime:   endTime,
+		Before:    req.Cursor,
+		Limit:     pageSize,
+	}
+	if req.ContactId != "" {
+		query.ConversationId = conversation.GetConversationId(userId, req.ContactId)
+	}
+	if req.Type != nil {
+		query.Types = []int8{*req.Type}
+	}
+	hits, hasMore, err := s.getIndex().Search(query)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	rsp := &respond.SearchMessageRespond{
+		Hits:    make([]respond.SearchHitRespond, 0, len(hits)),
+		HasMore: hasMore,
+	}
+	if len(hits) == 0 {
+		return "搜索成功", rsp, 0
+	}
+	uuids := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		uuids = append(uuids, hit.Uuid)
+	}
+	var messageList []model.Message
+	if res := dao.GormDB.Where("uuid IN ?", uuids).Find(&messageList); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	messages := make(map[string]model.Message, len(messageList))
+	for _, message := range messageList {
+		messages[message.Uuid] = message
+	}
+	for _, hit := range hits {
+		message, ok := messages[hit.Uuid]
+		// 索引和消息表之间可能有短暂的不一致，以消息表为准
+		if !ok || message.RecalledAt.Valid {
+			continue
+		}
+		rsp.Hits = append(rsp.Hits, respond.SearchHitRespond{
+			Message:   toMessageRespond(message),
+			Highlight: search.Highlight(toDocument(&message).Text, keyword, constants.HIGHLIGHT_CONTEXT),
+		})
+	}
+	rsp.NextCursor = hits[len(hits)-1].Id
+	return "搜索成功", rsp, 0
+}
+
+// mysqlIndex 直接使用message表上的FULLTEXT索引，消息存表即完成索引，不需要额外同步
+type mysqlIndex struct {
+}
+
+func (m *mysqlIndex) Add(doc search.Document) error {
+	return nil
+}
+
+func (m *mysqlIndex) Remove(uuid string) error {
+	return nil
+}
+
+func (m *mysqlIndex) Search(query search.Query) ([]search.Hit, bool, error) {
+	tx := dao.GormDB.Model(&model.Message{}).Select("id, uuid").Where("recalled_at IS NULL")
+	// ngram默认按两个字切词，一个字的关键词用不上全文索引
+	if utf8.RuneCountInString(query.Keyword) >= 2 {
+		phrase := `"` + strings.ReplaceAll(query.Keyword, `"`, " ") + `"`
+		tx = tx.Where("MATCH(content, file_name) AGAINST(? IN BOOLEAN MODE)", phrase)
+	} else {
+		like := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query.Keyword) + "%"
+		tx = tx.Where("(type = ? AND content LIKE ?) OR (type = ? AND file_name LIKE ?)", message_type_enum.Text, like, message_type_enum.File, like)
+	}
+
+	scope := dao.GormDB.Where("receive_id NOT LIKE 'G%' AND (send_id = ? OR receive_id = ?)", query.UserId, query.UserId)
+	var joined []string
+	for groupId, limit := range query.Groups {
+		if limit.IsZero() {
+			joined = append(joined, groupId)
+		} else {
+			scope = scope.Or("receive_id = ? AND created_at <= ?", groupId, limit)
+		}
+	}
+	if len(joined) > 0 {
+		scope = scope.Or("receive_id IN ?", joined)
+	}
+	tx = tx.Where(scope)
+
+	if query.ConversationId != "" {
+		tx = tx.Where("conversation_id = ?", query.ConversationId)
+	}
+	if query.SendId != "" {
+		tx = tx.Where("send_id = ?", query.SendId)
+	}
+	if len(query.Types) > 0 {
+		tx = tx.Where("type IN ?", query.Types)
+	}
+	if !query.StartTime.IsZero() {
+		tx = tx.Where("created_at >= ?", query.StartTime)
+	}
+	if !query.EndTime.IsZero() {
+		tx = tx.Where("created_at <= ?", query.EndTime)
+	}
+	if query.Before > 0 {
+		tx = tx.Where("id < ?", query.Before)
+	}
+	var hits []search.Hit
+	// 多取一条判断是否还有更多
+	if res := tx.Order("id DESC").Limit(query.Limit + 1).Scan(&hits); res.Error != nil {
+		return nil, false, res.Error
+	}
+	if len(hits) > query.Limit {
+		return hits[:query.Limit], true, nil
+	}
+	return hits, false, nil
+}
@@ -1,6 +1,7 @@
 package gorm
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,8 +16,10 @@ import (
 	"kama_chat_server/pkg/enum/contact/contact_status_enum"
 	"kama_chat_server/pkg/enum/group_info/group_status_enum"
 	"kama_chat_server/pkg/enum/user_info/user_status_enum"
+	"kama_chat_server/pkg/util/conversation"
 	"kama_chat_server/pkg/util/random"
 	"kama_chat_server/pkg/zlog"
+	"sort"
 	"time"
 )
 
@@ -152,8 +155,100 @@ func (s *sessionService) OpenSession(req request.OpenSessionRequest) (string, st
 	return "会话创建成功", session.Uuid, 0
 }
 
-// GetUserSessionList 获取用户会话列表
+type sessionActivity struct {
+	lastMessage   string
+	lastMessageAt string
+	activeAt      time.Time // 最近一条消息的时间，没有消息时用会话创建时间
+	pinOrder      int64
+	muted         bool
+	mutedUntil    string
+	archived      bool
+}
+
+// before 会话列表的顺序：置顶的按置顶顺序在最前，归档的在最后，其余按最近活跃
+func (a sessionActivity) before(b sessionActivity) bool {
+	if a.pinOrder != b.pinOrder {
+		return a.pinOrder > b.pinOrder
+	}
+	if a.archived != b.archived {
+		return !a.archived
+	}
+	return a.activeAt.After(b.activeAt)
+}
+
+// isMuted 免打扰是否生效，到期后自动失效
+func isMuted(session *model.Session, now time.Time) bool {
+	return session.Muted && (!session.MutedUntil.Valid || session.MutedUntil.Time.After(now))
+}
+
+// getSessionActivities 获取用户各个会话的最新消息和置顶、免打扰、归档状态，按会话对象uuid索引
+// 这些状态随每条消息或用户操作变化，不进会话列表缓存，每次单独查询
+func (s *sessionService) getSessionActivities(ownerId string) (map[string]sessionActivity, error) {
+	var sessionList []model.Session
+	if res := dao.GormDB.Select("receive_id", "last_message", "last_message_at", "created_at", "pin_order", "muted", "muted_until", "archived").Where("send_id = ?", ownerId).Find(&sessionList); res.Error != nil {
+		return nil, res.Error
+	}
+	now := time.Now()
+	activities := make(map[string]sessionActivity, len(sessionList))
+	for _, session := range sessionList {
+		activity := sessionActivity{
+			lastMessage: session.LastMessage,
+			activeAt:    session.CreatedAt,
+			pinOrder:    session.PinOrder,
+			muted:       isMuted(&session, now),
+			archived:    session.Archived,
+		}
+		if session.LastMessageAt.Valid {
+			activity.lastMessageAt = session.LastMessageAt.Time.Format("2006-01-02 15:04:05")
+			activity.activeAt = session.LastMessageAt.Time
+		}
+		if activity.muted && session.MutedUntil.Valid {
+			activity.mutedUntil = session.MutedUntil.Time.Format("2006-01-02 15:04:05")
+		}
+		activities[session.ReceiveId] = activity
+	}
+	return activities, nil
+}
+
+// GetUserSessionList 获取用户会话列表，带上每个会话的未读数和置顶、免打扰、归档状态
 func (s *sessionService) GetUserSessionList(ownerId string) (string, []respond.UserSessionListRespond, int) {
+	message, sessionList, ret := s.getUserSessionList(ownerId)
+	if ret != 0 || len(sessionList) == 0 {
+		return message, sessionList, ret
+	}
+	// 未读数变化频繁，不进缓存，每次单独查询
+	conversationIds := make([]string, len(sessionList))
+	for i, session := range sessionList {
+		conversationIds[i] = conversation.GetConversationId(ownerId, session.UserId)
+	}
+	unreadCounts, err := MessageService.GetUnreadCounts(ownerId, conversationIds)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	activities, err := s.getSessionActivities(ownerId)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	for i := range sessionList {
+		sessionList[i].UnreadCount = unreadCounts[conversationIds[i]]
+		activity := activities[sessionList[i].UserId]
+		sessionList[i].LastMessage = activity.lastMessage
+		sessionList[i].LastMessageAt = activity.lastMessageAt
+		sessionList[i].Pinned = activity.pinOrder > 0
+		sessionList[i].Muted = activity.muted
+		sessionList[i].MutedUntil = activity.mutedUntil
+		sessionList[i].Archived = activity.archived
+	}
+	sort.SliceStable(sessionList, func(i, j int) bool {
+		return activities[sessionList[i].UserId].before(activities[sessionList[j].UserId])
+	})
+	return message, sessionList, ret
+}
+
+// getUserSessionList 获取用户会话列表，优先从缓存获取
+func (s *sessionService) getUserSessionList(ownerId string) (string, []respond.UserSessionListRespond, int) {
 	rspString, err := myredis.GetKeyNilIsErr("session_list_" + ownerId)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
@@ -198,8 +293,50 @@ func (s *sessionService) GetUserSessionList(ownerId string) (string, []respond.U
 	return "获取成功", rsp, 0
 }
 
-// GetGroupSessionList 获取群聊会话列表
+// GetGroupSessionList 获取群聊会话列表，带上每个会话的未读数、是否有人@自己和置顶、免打扰、归档状态
 func (s *sessionService) GetGroupSessionList(ownerId string) (string, []respond.GroupSessionListRespond, int) {
+	message, sessionList, ret := s.getGroupSessionList(ownerId)
+	if ret != 0 || len(sessionList) == 0 {
+		return message, sessionList, ret
+	}
+	conversationIds := make([]string, len(sessionList))
+	for i, session := range sessionList {
+		conversationIds[i] = session.GroupId
+	}
+	unreadCounts, err := MessageService.GetUnreadCounts(ownerId, conversationIds)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	mentioned, err := MessageService.GetMentionedGroups(ownerId, conversationIds)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	activities, err := s.getSessionActivities(ownerId)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	for i := range sessionList {
+		sessionList[i].UnreadCount = unreadCounts[conversationIds[i]]
+		sessionList[i].Mentioned = mentioned[conversationIds[i]]
+		activity := activities[sessionList[i].GroupId]
+		sessionList[i].LastMessage = activity.lastMessage
+		sessionList[i].LastMessageAt = activity.lastMessageAt
+		sessionList[i].Pinned = activity.pinOrder > 0
+		sessionList[i].Muted = activity.muted
+		sessionList[i].MutedUntil = activity.mutedUntil
+		sessionList[i].Archived = activity.archived
+	}
+	sort.SliceStable(sessionList, func(i, j int) bool {
+		return activities[sessionList[i].GroupId].before(activities[sessionList[j].GroupId])
+	})
+	return message, sessionList, ret
+}
+
+// getGroupSessionList 获取群聊会话列表，优先从缓存获取
+func (s *sessionService) getGroupSessionList(ownerId string) (string, []respond.GroupSessionListRespond, int) {
 	rspString, err := myredis.GetKeyNilIsErr("group_session_list_" + ownerId)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
@@ -248,7 +385,11 @@ func (s *sessionService) GetGroupSessionList(ownerId string) (string, []respond.
 func (s *sessionService) DeleteSession(ownerId, sessionId string) (string, int) {
 
 	var session model.Session
-	if res := dao.GormDB.Where("uuid = ?", sessionId).Find(&session); res.Error != nil {
+	if res := dao.GormDB.Where("uuid = ? AND send_id = ?", sessionId, ownerId).First(&session); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			zlog.Info("会话不存在")
+			return "会话不存在", -2
+		}
 		zlog.Error(res.Error.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
@@ -269,3 +410,130 @@ func (s *sessionService) DeleteSession(ownerId, sessionId string) (string, int)
 	}
 	return "删除成功", 0
 }
+
+// getOwnSession 获取用户自己的会话，不能操作别人的会话
+func (s *sessionService) getOwnSession(ownerId, sessionId string) (*model.Session, string, int) {
+	var session model.Session
+	if res := dao.GormDB.Where("uuid = ? AND send_id = ?", sessionId, ownerId).First(&session); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return nil, "会话不存在", -2
+		}
+		zlog.Error(res.Error.Error())
+		return nil, constants.SYSTEM_ERROR, -1
+	}
+	return &session, "", 0
+}
+
+// PinSession 置顶或取消置顶会话，新置顶的排在最前面
+func (s *sessionService) PinSession(ownerId string, req request.PinSessionRequest) (string, int) {
+	session, message, ret := s.getOwnSession(ownerId, req.SessionId)
+	if ret != 0 {
+		return message, ret
+	}
+	var pinOrder int64
+	if req.Pinned {
+		if session.PinOrder > 0 {
+			return "会话已置顶", 0
+		}
+		if res := dao.GormDB.Model(&model.Session{}).Where("send_id = ?", ownerId).Select("COALESCE(MAX(pin_order), 0)").Scan(&pinOrder); res.Error != nil {
+			zlog.Error(res.Error.Error())
+			return constants.SYSTEM_ERROR, -1
+		}
+		pinOrder++
+	}
+	if res := dao.GormDB.Model(session).Update("pin_order", pinOrder); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if req.Pinned {
+		return "置顶成功", 0
+	}
+	return "取消置顶成功", 0
+}
+
+// ReorderPinnedSessions 调整置顶会话的顺序，只能传入已经置顶的会话
+func (s *sessionService) ReorderPinnedSessions(ownerId string, req request.ReorderPinnedSessionsRequest) (string, int) {
+	var count int64
+	if res := dao.GormDB.Model(&model.Session{}).Where("uuid IN ? AND send_id = ? AND pin_order > 0", req.SessionIds, ownerId).Count(&count); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if len(req.SessionIds) == 0 || count != int64(len(req.SessionIds)) {
+		return "只能调整已置顶会话的顺序", -2
+	}
+	err := dao.GormDB.Transaction(func(tx *gorm.DB) error {
+		for i, sessionId := range req.SessionIds {
+			// 列表中越靠前的顺序值越大
+			if res := tx.Model(&model.Session{}).Where("uuid = ? AND send_id = ?", sessionId, ownerId).Update("pin_order", len(req.SessionIds)-i); res.Error != nil {
+				return res.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	return "调整成功", 0
+}
+
+// MuteSession 开启或关闭免打扰，免打扰的会话照常收消息，只是不推送提醒
+func (s *sessionService) MuteSession(ownerId string, req request.MuteSessionRequest) (string, int) {
+	session, message, ret := s.getOwnSession(ownerId, req.SessionId)
+	if ret != 0 {
+		return message, ret
+	}
+	if req.Duration < 0 {
+		return "免打扰时长不能为负数", -2
+	}
+	mutedUntil := sql.NullTime{}
+	if req.Muted && req.Duration > 0 {
+		mutedUntil = sql.NullTime{Time: time.Now().Add(time.Duration(req.Duration) * time.Minute), Valid: true}
+	}
+	if res := dao.GormDB.Model(session).Updates(map[string]interface{}{
+		"muted":       req.Muted,
+		"muted_until": mutedUntil,
+	}); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if req.Muted {
+		return "已开启免打扰", 0
+	}
+	return "已关闭免打扰", 0
+}
+
+// ArchiveSession 归档或取消归档会话
+func (s *sessionService) ArchiveSession(ownerId string, req request.ArchiveSessionRequest) (string, int) {
+	session, message, ret := s.getOwnSession(ownerId, req.SessionId)
+	if ret != 0 {
+		return message, ret
+	}
+	if res := dao.GormDB.Model(session).Update("archived", req.Archived); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if req.Archived {
+		return "归档成功", 0
+	}
+	return "取消归档成功", 0
+}
+
+// GetMutedUsers 在userIds中找出对会话对象开启了免打扰的用户
+func (s *sessionService) GetMutedUsers(receiveId string, userIds []string) (map[string]bool, error) {
+	muted := make(map[string]bool)
+	if len(userIds) == 0 {
+		return muted, nil
+	}
+	var sessionList []model.Session
+	if res := dao.GormDB.Select("send_id", "muted", "muted_until").Where("receive_id = ? AND send_id IN ? AND muted = ?", receiveId, userIds, true).Find(&sessionList); res.Error != nil {
+		return nil, res.Error
+	}
+	now := time.Now()
+	for _, session := range sessionList {
+		if isMuted(&session, now) {
+			muted[session.SendId] = true
+		}
+	}
+	return muted, nil
+}
@@ -1,6 +1,7 @@
 package gorm
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -28,6 +29,18 @@ type userContactService struct {
 
 var UserContactService = new(userContactService)
 
+// checkApplyOwner 确定处理申请的一方
+// 好友申请的被申请人就是登录用户，加群申请的被申请人是群聊，需要登录用户是群主或管理员
+func (u *userContactService) checkApplyOwner(operatorId string, ownerId string) (string, string, int) {
+	if ownerId == "" || ownerId[0] != 'G' {
+		return "", operatorId, 0
+	}
+	if _, message, ret := GroupInfoService.checkGroupManager(ownerId, operatorId); ret != 0 {
+		return message, "", ret
+	}
+	return "", ownerId, 0
+}
+
 // GetUserList 获取用户列表
 // 关于用户被禁用的问题，这里查到的是所有联系人，如果被禁用或被拉黑会以弹窗的形式提醒，无法打开会话框；如果被删除，是搜索不到该联系人的。
 func (u *userContactService) GetUserList(ownerId string) (string, []respond.MyUserListRespond, int) {
@@ -161,13 +174,23 @@ func (u *userContactService) GetContactInfo(contactId string) (string, respond.G
 		}
 		// 没被禁用
 		if group.Status != group_status_enum.DISABLE {
+			members, err := GroupInfoService.GetGroupMemberIds(group.Uuid)
+			if err != nil {
+				zlog.Error(err.Error())
+				return constants.SYSTEM_ERROR, respond.GetContactInfoRespond{}, -1
+			}
+			membersData, err := json.Marshal(members)
+			if err != nil {
+				zlog.Error(err.Error())
+				return constants.SYSTEM_ERROR, respond.GetContactInfoRespond{}, -1
+			}
 			return "获取联系人信息成功", respond.GetContactInfoRespond{
 				ContactId:        group.Uuid,
 				ContactName:      group.Name,
 				ContactAvatar:    group.Avatar,
 				ContactNotice:    group.Notice,
 				ContactAddMode:   group.AddMode,
-				ContactMembers:   group.Members,
+				ContactMembers:   membersData,
 				ContactMemberCnt: group.MemberCnt,
 				ContactOwnerId:   group.OwnerId,
 			}, 0
@@ -246,7 +269,7 @@ func (u *userContactService) DeleteContact(ownerId, contactId string) (string, i
 	return "删除联系人成功", 0
 }
 
-// ApplyContact 申请添加联系人
+// ApplyContact 申请添加好友，加群申请由GroupApplyService处理
 func (u *userContactService) ApplyContact(req request.ApplyContactRequest) (string, int) {
 	if req.ContactId[0] == 'U' {
 		var user model.UserInfo
@@ -292,56 +315,13 @@ func (u *userContactService) ApplyContact(req request.ApplyContactRequest) (stri
 		contactApply.LastApplyAt = time.Now()
 		contactApply.Status = contact_apply_status_enum.PENDING
 
-		if res := dao.GormDB.Save(&contactApply); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		return "申请成功", 0
-	} else if req.ContactId[0] == 'G' {
-		var group model.GroupInfo
-		if res := dao.GormDB.First(&group, "uuid = ?", req.ContactId); res.Error != nil {
-			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-				zlog.Error("群聊不存在")
-				return "群聊不存在", -2
-			} else {
-				zlog.Error(res.Error.Error())
-				return constants.SYSTEM_ERROR, -1
-			}
-		}
-		if group.Status == group_status_enum.DISABLE {
-			zlog.Info("群聊已被禁用")
-			return "群聊已被禁用", -2
-		}
-		var contactApply model.ContactApply
-		if res := dao.GormDB.Where("user_id = ? AND contact_id = ?", req.OwnerId, req.ContactId).First(&contactApply); res.Error != nil {
-			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-				contactApply = model.ContactApply{
-					Uuid:        fmt.Sprintf("A%s", random.GetNowAndLenRandomString(11)),
-					UserId:      req.OwnerId,
-					ContactId:   req.ContactId,
-					ContactType: contact_type_enum.GROUP,
-					Status:      contact_apply_status_enum.PENDING,
-					Message:     req.Message,
-					LastApplyAt: time.Now(),
-				}
-				if res := dao.GormDB.Create(&contactApply); res.Error != nil {
-					zlog.Error(res.Error.Error())
-					return constants.SYSTEM_ERROR, -1
-				}
-			} else {
-				zlog.Error(res.Error.Error())
-				return constants.SYSTEM_ERROR, -1
-			}
-		}
-		contactApply.LastApplyAt = time.Now()
-
 		if res := dao.GormDB.Save(&contactApply); res.Error != nil {
 			zlog.Error(res.Error.Error())
 			return constants.SYSTEM_ERROR, -1
 		}
 		return "申请成功", 0
 	} else {
-		return "用户/群聊不存在", -2
+		return "用户不存在", -2
 	}
 
 }
@@ -384,10 +364,13 @@ func (u *userContactService) GetNewContactList(ownerId string) (string, []respon
 }
 
 // GetAddGroupList 获取新的加群列表
-// 前端已经判断调用接口的用户是群主，也只有群主才能调用这个接口
-func (u *userContactService) GetAddGroupList(groupId string) (string, []respond.AddGroupListRespond, int) {
+// 只有群主和管理员才能调用这个接口
+func (u *userContactService) GetAddGroupList(operatorId string, groupId string) (string, []respond.AddGroupListRespond, int) {
+	if _, message, ret := GroupInfoService.checkGroupManager(groupId, operatorId); ret != 0 {
+		return message, nil, ret
+	}
 	var contactApplyList []model.ContactApply
-	if res := dao.GormDB.Where("contact_id = ? AND status = ?", groupId, contact_apply_status_enum.PENDING).Find(&contactApplyList); res.Error != nil {
+	if res := dao.GormDB.Where("contact_id = ? AND status = ?", groupId, contact_apply_status_enum.PENDING).Order("last_apply_at DESC").Find(&contactApplyList); res.Error != nil {
 		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
 			zlog.Info("没有在申请的联系人")
 			return "没有在申请的联系人", nil, 0
@@ -407,6 +390,7 @@ func (u *userContactService) GetAddGroupList(groupId string) (string, []respond.
 		newContact := respond.AddGroupListRespond{
 			ContactId: contactApply.Uuid,
 			Message:   message,
+			ApplyAt:   contactApply.LastApplyAt.Format("2006-01-02 15:04:05"),
 		}
 		var user model.UserInfo
 		if res := dao.GormDB.First(&user, "uuid = ?", contactApply.UserId); res.Error != nil {
@@ -420,121 +404,73 @@ func (u *userContactService) GetAddGroupList(groupId string) (string, []respond.
 	return "获取成功", rsp, 0
 }
 
-// PassContactApply 通过联系人申请
+// PassContactApply 通过好友申请，加群申请由GroupApplyService处理
+// ownerId 是登录用户
 func (u *userContactService) PassContactApply(ownerId string, contactId string) (string, int) {
-	// ownerId 如果是用户的话就是登录用户，如果是群聊的话就是群聊id
 	var contactApply model.ContactApply
 	if res := dao.GormDB.Where("contact_id = ? AND user_id = ?", ownerId, contactId).First(&contactApply); res.Error != nil {
 		zlog.Error(res.Error.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
-	if ownerId[0] == 'U' {
-		var user model.UserInfo
-		if res := dao.GormDB.Where("uuid = ?", contactId).Find(&user); res.Error != nil {
-			zlog.Error(res.Error.Error())
-		}
-		if user.Status == user_status_enum.DISABLE {
-			zlog.Error("用户已被禁用")
-			return "用户已被禁用", -2
-		}
-		contactApply.Status = contact_apply_status_enum.AGREE
-		if res := dao.GormDB.Save(&contactApply); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		newContact := model.UserContact{
-			UserId:      ownerId,
-			ContactId:   contactId,
-			ContactType: contact_type_enum.USER,     // 用户
-			Status:      contact_status_enum.NORMAL, // 正常
-			CreatedAt:   time.Now(),
-			UpdateAt:    time.Now(),
-		}
-		if res := dao.GormDB.Create(&newContact); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		anotherContact := model.UserContact{
-			UserId:      contactId,
-			ContactId:   ownerId,
-			ContactType: contact_type_enum.USER,     // 用户
-			Status:      contact_status_enum.NORMAL, // 正常
-			CreatedAt:   newContact.CreatedAt,
-			UpdateAt:    newContact.UpdateAt,
-		}
-		if res := dao.GormDB.Create(&anotherContact); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		if err := myredis.DelKeysWithPattern("contact_user_list_" + ownerId); err != nil {
-			zlog.Error(err.Error())
-		}
-		return "已添加该联系人", 0
-	} else {
-		var group model.GroupInfo
-		if res := dao.GormDB.Where("uuid = ?", ownerId).Find(&group); res.Error != nil {
-			zlog.Error(res.Error.Error())
-		}
-		if group.Status == group_status_enum.DISABLE {
-			zlog.Error("群聊已被禁用")
-			return "群聊已被禁用", -2
-		}
-		contactApply.Status = contact_apply_status_enum.AGREE
-		if res := dao.GormDB.Save(&contactApply); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		// 群聊就只用创建一个UserContact，因为一个UserContact足以表达双方的状态
-		newContact := model.UserContact{
-			UserId:      contactId,
-			ContactId:   ownerId,
-			ContactType: contact_type_enum.GROUP,    // 用户
-			Status:      contact_status_enum.NORMAL, // 正常
-			CreatedAt:   time.Now(),
-			UpdateAt:    time.Now(),
-		}
-		if res := dao.GormDB.Create(&newContact); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		var members []string
-		if err := json.Unmarshal(group.Members, &members); err != nil {
-			zlog.Error(err.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		members = append(members, contactId)
-		group.MemberCnt = len(members)
-		group.Members, _ = json.Marshal(members)
-		if res := dao.GormDB.Save(&group); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		if err := myredis.DelKeysWithPattern("my_joined_group_list_" + ownerId); err != nil {
-			zlog.Error(err.Error())
-		}
-		return "已通过加群申请", 0
+	var user model.UserInfo
+	if res := dao.GormDB.Where("uuid = ?", contactId).Find(&user); res.Error != nil {
+		zlog.Error(res.Error.Error())
+	}
+	if user.Status == user_status_enum.DISABLE {
+		zlog.Error("用户已被禁用")
+		return "用户已被禁用", -2
+	}
+	contactApply.Status = contact_apply_status_enum.AGREE
+	if res := dao.GormDB.Save(&contactApply); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	newContact := model.UserContact{
+		UserId:      ownerId,
+		ContactId:   contactId,
+		ContactType: contact_type_enum.USER,     // 用户
+		Status:      contact_status_enum.NORMAL, // 正常
+		CreatedAt:   time.Now(),
+		UpdateAt:    time.Now(),
+	}
+	if res := dao.GormDB.Create(&newContact); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	anotherContact := model.UserContact{
+		UserId:      contactId,
+		ContactId:   ownerId,
+		ContactType: contact_type_enum.USER,     // 用户
+		Status:      contact_status_enum.NORMAL, // 正常
+		CreatedAt:   newContact.CreatedAt,
+		UpdateAt:    newContact.UpdateAt,
 	}
+	if res := dao.GormDB.Create(&anotherContact); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if err := myredis.DelKeysWithPattern("contact_user_list_" + ownerId); err != nil {
+		zlog.Error(err.Error())
+	}
+	return "已添加该联系人", 0
 }
 
-// RefuseContactApply 拒绝联系人申请
+// RefuseContactApply 拒绝好友申请，加群申请由GroupApplyService处理
+// ownerId 是登录用户
 func (u *userContactService) RefuseContactApply(ownerId string, contactId string) (string, int) {
-	// ownerId 如果是用户的话就是登录用户，如果是群聊的话就是群聊id
 	var contactApply model.ContactApply
 	if res := dao.GormDB.Where("contact_id = ? AND user_id = ?", ownerId, contactId).First(&contactApply); res.Error != nil {
 		zlog.Error(res.Error.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	contactApply.Status = contact_apply_status_enum.REFUSE
+	contactApply.HandledBy = ownerId
+	contactApply.HandledAt = sql.NullTime{Time: time.Now(), Valid: true}
 	if res := dao.GormDB.Save(&contactApply); res.Error != nil {
 		zlog.Error(res.Error.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
-	if ownerId[0] == 'U' {
-		return "已拒绝该联系人申请", 0
-	} else {
-		return "已拒绝该加群申请", 0
-	}
-
+	return "已拒绝该联系人申请", 0
 }
 
 // BlackContact 拉黑联系人
@@ -601,7 +537,11 @@ func (u *userContactService) CancelBlackContact(ownerId string, contactId string
 }
 
 // BlackApply 拉黑申请
-func (u *userContactService) BlackApply(ownerId string, contactId string) (string, int) {
+func (u *userContactService) BlackApply(operatorId string, ownerId string, contactId string) (string, int) {
+	message, ownerId, ret := u.checkApplyOwner(operatorId, ownerId)
+	if ret != 0 {
+		return message, ret
+	}
 	var contactApply model.ContactApply
 	if res := dao.GormDB.Where("contact_id = ? AND user_id = ?", ownerId, contactId).First(&contactApply); res.Error != nil {
 		zlog.Error(res.Error.Error())
@@ -614,3 +554,25 @@ func (u *userContactService) BlackApply(ownerId string, contactId string) (strin
 	}
 	return "已拉黑该申请", 0
 }
+
+// GetContactAudience 获取把该用户作为正常联系人的用户，用于广播在线状态
+func (u *userContactService) GetContactAudience(userId string) ([]string, error) {
+	var userIds []string
+	if res := dao.GormDB.Model(&model.UserContact{}).
+		Where("contact_id = ? AND contact_type = ? AND status = ?", userId, contact_type_enum.USER, contact_status_enum.NORMAL).
+		Pluck("user_id", &userIds); res.Error != nil {
+		return nil, res.Error
+	}
+	return userIds, nil
+}
+
+// IsNormalContact 判断ownerId的联系人中是否有contactId，且没有拉黑或删除
+func (u *userContactService) IsNormalContact(ownerId string, contactId string) (bool, error) {
+	var count int64
+	if res := dao.GormDB.Model(&model.UserContact{}).
+		Where("user_id = ? AND contact_id = ? AND status = ?", ownerId, contactId, contact_status_enum.NORMAL).
+		Count(&count); res.Error != nil {
+		return false, res.Error
+	}
+	return count > 0, nil
+}
@@ -10,10 +10,12 @@ import (
 	"kama_chat_server/internal/dto/request"
 	"kama_chat_server/internal/dto/respond"
 	"kama_chat_server/internal/model"
+	myjwt "kama_chat_server/internal/service/jwt"
 	myredis "kama_chat_server/internal/service/redis"
 	"kama_chat_server/internal/service/sms"
 	"kama_chat_server/pkg/constants"
 	"kama_chat_server/pkg/enum/user_info/user_status_enum"
+	mypassword "kama_chat_server/pkg/util/password"
 	"kama_chat_server/pkg/util/random"
 	"kama_chat_server/pkg/zlog"
 	"regexp"
@@ -46,9 +48,54 @@ func (u *userInfoService) checkEmailValid(email string) bool {
 	return match
 }
 
-// checkUserIsAdminOrNot 检验用户是否为管理员
-func (u *userInfoService) checkUserIsAdminOrNot(user model.UserInfo) int8 {
-	return user.IsAdmin
+// checkPasswordValid 校验密码长度，bcrypt最多只处理72字节
+func (u *userInfoService) checkPasswordValid(password string) (string, int) {
+	if len(password) < 6 || len(password) > 72 {
+		message := "密码长度需要在6到72位之间"
+		zlog.Info(message)
+		return message, -2
+	}
+	return "", 0
+}
+
+// checkSmsCode 校验短信验证码，校验通过后删除验证码，保证只能用一次
+func (u *userInfoService) checkSmsCode(telephone string, smsCode string) (string, int) {
+	key := "auth_code_" + telephone
+	code, err := myredis.GetKey(key)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if code == "" || code != smsCode {
+		message := "验证码不正确，请重试"
+		zlog.Info(message)
+		return message, -2
+	}
+	if err := myredis.DelKeyIfExists(key); err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	return "", 0
+}
+
+// CheckUserIsAdmin 检验用户是否为管理员，被禁用的管理员也没有权限
+func (u *userInfoService) CheckUserIsAdmin(uuid string) (string, int) {
+	var user model.UserInfo
+	if res := dao.GormDB.First(&user, "uuid = ?", uuid); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			message := "用户不存在"
+			zlog.Info(message)
+			return message, -3
+		}
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if user.IsAdmin != 1 || user.Status == user_status_enum.DISABLE {
+		message := "没有权限，只有管理员可以操作"
+		zlog.Info(message)
+		return message, -3
+	}
+	return "", 0
 }
 
 // Login 登录
@@ -65,11 +112,20 @@ func (u *userInfoService) Login(loginReq request.LoginRequest) (string, *respond
 		zlog.Error(res.Error.Error())
 		return constants.SYSTEM_ERROR, nil, -1
 	}
-	if user.Password != password {
+	ok, needRehash := mypassword.Check(user.Password, password)
+	if !ok {
 		message := "密码不正确，请重试"
 		zlog.Error(message)
 		return message, nil, -2
 	}
+	// 老数据存的是明文，登录成功后透明地迁移为哈希
+	if needRehash {
+		if hashed, err := mypassword.Hash(password); err != nil {
+			zlog.Error(err.Error())
+		} else if res := dao.GormDB.Model(&user).Update("password", hashed); res.Error != nil {
+			zlog.Error(res.Error.Error())
+		}
+	}
 
 	loginRsp := &respond.LoginRespond{
 		Uuid:      user.Uuid,
@@ -85,6 +141,13 @@ func (u *userInfoService) Login(loginReq request.LoginRequest) (string, *respond
 	}
 	year, month, day := user.CreatedAt.Date()
 	loginRsp.CreatedAt = fmt.Sprintf("%d.%d.%d", year, month, day)
+	accessToken, refreshToken, err := myjwt.GenerateTokenPair(user.Uuid)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	loginRsp.AccessToken = accessToken
+	loginRsp.RefreshToken = refreshToken
 
 	return "登陆成功", loginRsp, 0
 }
@@ -134,10 +197,49 @@ func (u *userInfoService) SmsLogin(req request.SmsLoginRequest) (string, *respon
 	}
 	year, month, day := user.CreatedAt.Date()
 	loginRsp.CreatedAt = fmt.Sprintf("%d.%d.%d", year, month, day)
+	accessToken, refreshToken, err := myjwt.GenerateTokenPair(user.Uuid)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	loginRsp.AccessToken = accessToken
+	loginRsp.RefreshToken = refreshToken
 
 	return "登陆成功", loginRsp, 0
 }
 
+// RefreshToken 用refresh token换取新的token对
+func (u *userInfoService) RefreshToken(refreshToken string) (string, *respond.RefreshTokenRespond, int) {
+	claims, err := myjwt.ParseToken(refreshToken, myjwt.REFRESH_TOKEN)
+	if err != nil {
+		zlog.Info(err.Error())
+		return "登录已失效，请重新登录", nil, -2
+	}
+	// 用户被删除或禁用后，不能再续期
+	var user model.UserInfo
+	if res := dao.GormDB.First(&user, "uuid = ?", claims.Uuid); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			message := "用户不存在，请注册"
+			zlog.Error(message)
+			return message, nil, -2
+		}
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if user.Status == user_status_enum.DISABLE {
+		message := "该用户已被禁用"
+		zlog.Info(message)
+		return message, nil, -2
+	}
+	rsp := &respond.RefreshTokenRespond{}
+	rsp.AccessToken, rsp.RefreshToken, err = myjwt.GenerateTokenPair(user.Uuid)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	return "刷新成功", rsp, 0
+}
+
 // SendSmsCode 发送短信验证码 - 验证码登录
 func (u *userInfoService) SendSmsCode(telephone string) (string, int) {
 	return sms.VerificationCode(telephone)
@@ -161,6 +263,9 @@ func (u *userInfoService) checkTelephoneExist(telephone string) (string, int) {
 
 // Register 注册，返回(message, register_respond_string, error)
 func (u *userInfoService) Register(registerReq request.RegisterRequest) (string, *respond.RegisterRespond, int) {
+	if message, ret := u.checkPasswordValid(registerReq.Password); ret != 0 {
+		return message, nil, ret
+	}
 	key := "auth_code_" + registerReq.Telephone
 	code, err := myredis.GetKey(key)
 	if err != nil {
@@ -186,11 +291,15 @@ func (u *userInfoService) Register(registerReq request.RegisterRequest) (string,
 	var newUser model.UserInfo
 	newUser.Uuid = "U" + random.GetNowAndLenRandomString(11)
 	newUser.Telephone = registerReq.Telephone
-	newUser.Password = registerReq.Password
+	newUser.Password, err = mypassword.Hash(registerReq.Password)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
 	newUser.Nickname = registerReq.Nickname
 	newUser.Avatar = "https://cube.elemecdn.com/0/88/03b0d39583f48206768a7534e55bcpng.png"
 	newUser.CreatedAt = time.Now()
-	newUser.IsAdmin = u.checkUserIsAdminOrNot(newUser)
+	newUser.IsAdmin = 0 // 新注册用户都不是管理员，只能由管理员设置
 	newUser.Status = user_status_enum.NORMAL
 	// 手机号验证，最后一步才调用api，省钱hhh
 	//err := sms.VerificationCode(registerReq.Telephone)
@@ -222,6 +331,11 @@ func (u *userInfoService) Register(registerReq request.RegisterRequest) (string,
 	}
 	year, month, day := newUser.CreatedAt.Date()
 	registerRsp.CreatedAt = fmt.Sprintf("%d.%d.%d", year, month, day)
+	registerRsp.AccessToken, registerRsp.RefreshToken, err = myjwt.GenerateTokenPair(newUser.Uuid)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
 
 	return "注册成功", registerRsp, 0
 }
@@ -260,6 +374,60 @@ func (u *userInfoService) UpdateUserInfo(updateReq request.UpdateUserInfoRequest
 	return "修改用户信息成功", 0
 }
 
+// ChangePassword 修改密码，需要校验旧密码
+func (u *userInfoService) ChangePassword(uuid string, req request.ChangePasswordRequest) (string, int) {
+	if message, ret := u.checkPasswordValid(req.NewPassword); ret != 0 {
+		return message, ret
+	}
+	var user model.UserInfo
+	if res := dao.GormDB.First(&user, "uuid = ?", uuid); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if ok, _ := mypassword.Check(user.Password, req.OldPassword); !ok {
+		message := "原密码不正确，请重试"
+		zlog.Info(message)
+		return message, -2
+	}
+	return u.updatePassword(&user, req.NewPassword)
+}
+
+// ResetPassword 忘记密码时通过短信验证码重置密码
+func (u *userInfoService) ResetPassword(req request.ResetPasswordRequest) (string, int) {
+	if message, ret := u.checkPasswordValid(req.NewPassword); ret != 0 {
+		return message, ret
+	}
+	// 先校验验证码再查用户，避免未登录时通过该接口探测手机号是否注册
+	if message, ret := u.checkSmsCode(req.Telephone, req.SmsCode); ret != 0 {
+		return message, ret
+	}
+	var user model.UserInfo
+	if res := dao.GormDB.First(&user, "telephone = ?", req.Telephone); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			message := "用户不存在，请注册"
+			zlog.Info(message)
+			return message, -2
+		}
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	return u.updatePassword(&user, req.NewPassword)
+}
+
+// updatePassword 哈希后保存新密码
+func (u *userInfoService) updatePassword(user *model.UserInfo, newPassword string) (string, int) {
+	hashed, err := mypassword.Hash(newPassword)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if res := dao.GormDB.Model(user).Update("password", hashed); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	return "修改密码成功", 0
+}
+
 // GetUserInfoList 获取用户列表除了ownerId之外 - 管理员
 // 管理员少，而且如果用户更改了，那么管理员会一直频繁删除redis，更新redis，比较麻烦，所以管理员暂时不使用redis缓存
 func (u *userInfoService) GetUserInfoList(ownerId string) (string, []respond.GetUserListRespond, int) {
@@ -481,10 +649,28 @@ func (u *userInfoService) GetUserInfo(uuid string) (string, *respond.GetUserInfo
 	return "获取用户信息成功", &rsp, 0
 }
 
+// SetLastOnlineAt 记录用户最近上线时间，websocket连接建立时调用
+func (u *userInfoService) SetLastOnlineAt(uuid string, onlineAt time.Time) (string, int) {
+	if res := dao.GormDB.Model(&model.UserInfo{}).Where("uuid = ?", uuid).Update("last_online_at", onlineAt); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	return "更新成功", 0
+}
+
+// SetLastOfflineAt 记录用户最近离线时间，websocket断开时调用
+func (u *userInfoService) SetLastOfflineAt(uuid string, offlineAt time.Time) (string, int) {
+	if res := dao.GormDB.Model(&model.UserInfo{}).Where("uuid = ?", uuid).Update("last_offline_at", offlineAt); res.Error != nil {
+		zlog.Error(res.Error.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	return "更新成功", 0
+}
+
 // SetAdmin 设置管理员
 func (u *userInfoService) SetAdmin(uuidList []string, isAdmin int8) (string, int) {
 	var users []model.UserInfo
-	if res := dao.GormDB.Where("uuid = (?)", uuidList).Find(&users); res.Error != nil {
+	if res := dao.GormDB.Where("uuid in (?)", uuidList).Find(&users); res.Error != nil {
 		zlog.Error(res.Error.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
@@ -0,0 +1,79 @@
+package jwt
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"kama_chat_server/internal/config"
+	"time"
+)
+
+const (
+	ACCESS_TOKEN  = "access"  // 访问令牌，携带在每次请求中
+	REFRESH_TOKEN = "refresh" // 刷新令牌，只能用来换取新的令牌对
+)
+
+type Claims struct {
+	Uuid      string `json:"uuid"`
+	TokenType string `json:"token_type"`
+	jwt.StandardClaims
+}
+
+// GenerateToken 签发指定类型的token
+func GenerateToken(uuid string, tokenType string) (string, error) {
+	jwtConfig := config.GetConfig().JwtConfig
+	expire := jwtConfig.AccessExpire * time.Hour
+	if tokenType == REFRESH_TOKEN {
+		expire = jwtConfig.RefreshExpire * time.Hour
+	}
+	now := time.Now()
+	claims := Claims{
+		Uuid:      uuid,
+		TokenType: tokenType,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   uuid,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(expire).Unix(),
+			Issuer:    config.GetConfig().AppName,
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(jwtConfig.Secret))
+}
+
+// GenerateTokenPair 签发access token和refresh token
+func GenerateTokenPair(uuid string) (string, string, error) {
+	accessToken, err := GenerateToken(uuid, ACCESS_TOKEN)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := GenerateToken(uuid, REFRESH_TOKEN)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+// ParseToken 解析并校验token，签名、过期时间和token类型任一不符合都视为无效
+func ParseToken(tokenString string, tokenType string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		// 只接受签发时使用的HMAC算法，防止alg被篡改
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(config.GetConfig().JwtConfig.Secret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("token无效")
+	}
+	if claims.TokenType != tokenType {
+		return nil, errors.New("token类型不匹配")
+	}
+	if claims.Uuid == "" {
+		return nil, errors.New("token缺少用户信息")
+	}
+	return claims, nil
+}
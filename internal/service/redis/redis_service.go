@@ -58,6 +58,86 @@ func GetKeyNilIsErr(key string) (string, error) {
 	return value, nil
 }
 
+// SetKeyNX key不存在时才写入，返回是否写入成功
+func SetKeyNX(key string, value string, timeout time.Duration) (bool, error) {
+	return redisClient.SetNX(ctx, key, value, timeout).Result()
+}
+
+// Incr key的值自增1并返回自增后的值
+func Incr(key string) (int64, error) {
+	return redisClient.Incr(ctx, key).Result()
+}
+
+// PushToWindow 在列表头部追加一条并截断到maxLen条，列表不存在时跳过，等下次查询时再整体加载
+func PushToWindow(key string, value string, maxLen int64, timeout time.Duration) error {
+	pipe := redisClient.TxPipeline()
+	pipe.LPushX(ctx, key, value)
+	pipe.LTrim(ctx, key, 0, maxLen-1)
+	pipe.Expire(ctx, key, timeout)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ReplaceInWindow 替换列表中uuid字段等于uuid的那一条，不存在时跳过
+// 在redis中原子完成查找和替换，避免和并发的PushToWindow错位
+func ReplaceInWindow(key string, uuid string, value string) error {
+	script := redis.NewScript(`
+local items = redis.call("LRANGE", KEYS[1], 0, -1)
+for i, item in ipairs(items) do
+	local ok, decoded = pcall(cjson.decode, item)
+	if ok and decoded["uuid"] == ARGV[1] then
+		redis.call("LSET", KEYS[1], i - 1, ARGV[2])
+		return 1
+	end
+end
+return 0`)
+	return script.Run(ctx, redisClient, []string{key}, uuid, value).Err()
+}
+
+// SetList 用values整体替换列表
+func SetList(key string, values []string, timeout time.Duration) error {
+	pipe := redisClient.TxPipeline()
+	pipe.Del(ctx, key)
+	if len(values) > 0 {
+		args := make([]interface{}, len(values))
+		for i, value := range values {
+			args[i] = value
+		}
+		pipe.RPush(ctx, key, args...)
+		pipe.Expire(ctx, key, timeout)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetListRange 获取列表[start, stop]范围的元素，列表不存在时返回redis.Nil
+func GetListRange(key string, start int64, stop int64) ([]string, error) {
+	exists, err := redisClient.Exists(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, redis.Nil
+	}
+	return redisClient.LRange(ctx, key, start, stop).Result()
+}
+
+// DelKeyIfValueEquals 只有key当前的值等于value时才删除，用于删除自己写入的key，避免误删其他实例覆盖后的值
+func DelKeyIfValueEquals(key string, value string) error {
+	script := redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`)
+	return script.Run(ctx, redisClient, []string{key}, value).Err()
+}
+
+// Publish 向频道发布消息
+func Publish(channel string, message string) error {
+	return redisClient.Publish(ctx, channel, message).Err()
+}
+
+// Subscribe 订阅频道，调用方负责Close
+func Subscribe(channel string) *redis.PubSub {
+	return redisClient.Subscribe(ctx, channel)
+}
+
 func GetKeyWithPrefixNilIsErr(prefix string) (string, error) {
 	var keys []string
 	var err error
@@ -0,0 +1,75 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+const (
+	HighlightStart = "<em>"
+	HighlightEnd   = "</em>"
+)
+
+// Highlight 截取关键词第一次出现位置前后context个字的片段，片段中的关键词用<em>包裹，其余内容做html转义
+// 不区分大小写，没有命中时返回开头的片段
+func Highlight(text string, keyword string, context int) string {
+	runes := []rune(text)
+	lowerRunes := make([]rune, len(runes))
+	for i, r := range runes {
+		lowerRunes[i] = unicode.ToLower(r)
+	}
+	keywordRunes := []rune(strings.ToLower(keyword))
+	matchAt := func(i int) bool {
+		if len(keywordRunes) == 0 || i+len(keywordRunes) > len(lowerRunes) {
+			return false
+		}
+		for j, r := range keywordRunes {
+			if lowerRunes[i+j] != r {
+				return false
+			}
+		}
+		return true
+	}
+	first := -1
+	for i := range lowerRunes {
+		if matchAt(i) {
+			first = i
+			break
+		}
+	}
+	start, end := 0, len(runes)
+	if first >= 0 {
+		if first > context {
+			start = first - context
+		}
+		if first+len(keywordRunes)+context < end {
+			end = first + len(keywordRunes) + context
+		}
+	} else if 2*context < end {
+		end = 2 * context
+	}
+
+	var builder strings.Builder
+	if start > 0 {
+		builder.WriteString("...")
+	}
+	plainStart := start
+	for i := start; i < end; {
+		if matchAt(i) && i+len(keywordRunes) <= end {
+			builder.WriteString(html.EscapeString(string(runes[plainStart:i])))
+			builder.WriteString(HighlightStart)
+			builder.WriteString(html.EscapeString(string(runes[i : i+len(keywordRunes)])))
+			builder.WriteString(HighlightEnd)
+			i += len(keywordRunes)
+			plainStart = i
+			continue
+		}
+		i++
+	}
+	builder.WriteString(html.EscapeString(string(runes[plainStart:end])))
+	if end < len(runes) {
+		builder.WriteString("...")
+	}
+	return builder.String()
+}
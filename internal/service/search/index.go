@@ -0,0 +1,93 @@
+package search
+
+import (
+	"time"
+)
+
+// Document 被索引的一条消息，Text是可以搜索的文本，文本消息是内容，文件消息是文件名
+type Document struct {
+	Id             int64
+	Uuid           string
+	ConversationId string
+	SendId         string
+	ReceiveId      string
+	Type           int8
+	Text           string
+	CreatedAt      time.Time
+}
+
+// Query 搜索条件，Keyword必填，其余为空时不限制
+type Query struct {
+	Keyword string
+	// UserId 单聊只能搜到自己参与的
+	UserId string
+	// Groups 可以搜索的群聊，值不为零时只能搜到这个时间之前的消息，用于已经退出的群聊
+	Groups         map[string]time.Time
+	ConversationId string
+	SendId         string
+	Types          []int8
+	StartTime      time.Time
+	EndTime        time.Time
+	// Before 消息自增id游标，只返回比它更早的，为0时从最新的开始
+	Before int64
+	Limit  int
+}
+
+// Hit 命中的消息，从新到旧排列
+type Hit struct {
+	Id   int64
+	Uuid string
+}
+
+// Index 消息搜索索引，由消息流水线在存表、编辑、撤回时同步
+type Index interface {
+	// Add 新增或覆盖一条消息
+	Add(doc Document) error
+	// Remove 删除一条消息，不存在时忽略
+	Remove(uuid string) error
+	// Search 返回一页命中的消息和是否还有更多
+	Search(query Query) ([]Hit, bool, error)
+}
+
+// Covers 判断消息是否在搜索范围内并满足筛选条件，不比较关键词
+func (q *Query) Covers(doc *Document) bool {
+	if doc.ReceiveId == "" {
+		return false
+	}
+	if doc.ReceiveId[0] == 'G' {
+		limit, ok := q.Groups[doc.ReceiveId]
+		if !ok || (!limit.IsZero() && doc.CreatedAt.After(limit)) {
+			return false
+		}
+	} else if doc.SendId != q.UserId && doc.ReceiveId != q.UserId {
+		return false
+	}
+	if q.ConversationId != "" && doc.ConversationId != q.ConversationId {
+		return false
+	}
+	if q.SendId != "" && doc.SendId != q.SendId {
+		return false
+	}
+	if len(q.Types) > 0 {
+		matched := false
+		for _, t := range q.Types {
+			if t == doc.Type {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if !q.StartTime.IsZero() && doc.CreatedAt.Before(q.StartTime) {
+		return false
+	}
+	if !q.EndTime.IsZero() && doc.CreatedAt.After(q.EndTime) {
+		return false
+	}
+	if q.Before > 0 && doc.Id >= q.Before {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryIndex 进程内的倒排索引，按相邻两个字切词，中英文都适用
+// 索引只在本进程中，多实例部署时各节点看到的数据不一致，只适合单节点部署
+type MemoryIndex struct {
+	mutex    sync.RWMutex
+	docs     map[string]*Document
+	postings map[string]map[string]struct{}
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{
+		docs:     make(map[string]*Document),
+		postings: make(map[string]map[string]struct{}),
+	}
+}
+
+// bigrams 文本切成相邻两个字的词，不足两个字时返回空
+func bigrams(text string) []string {
+	runes := []rune(strings.ToLower(text))
+	if len(runes) < 2 {
+		return nil
+	}
+	grams := make([]string, 0, len(runes)-1)
+	seen := make(map[string]struct{}, len(runes)-1)
+	for i := 0; i+1 < len(runes); i++ {
+		gram := string(runes[i : i+2])
+		if _, ok := seen[gram]; ok {
+			continue
+		}
+		seen[gram] = struct{}{}
+		grams = append(grams, gram)
+	}
+	return grams
+}
+
+func (m *MemoryIndex) Add(doc Document) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.remove(doc.Uuid)
+	m.docs[doc.Uuid] = &doc
+	for _, gram := range bigrams(doc.Text) {
+		uuids, ok := m.postings[gram]
+		if !ok {
+			uuids = make(map[string]struct{})
+			m.postings[gram] = uuids
+		}
+		uuids[doc.Uuid] = struct{}{}
+	}
+	return nil
+}
+
+func (m *MemoryIndex) Remove(uuid string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.remove(uuid)
+	return nil
+}
+
+func (m *MemoryIndex) remove(uuid string) {
+	doc, ok := m.docs[uuid]
+	if !ok {
+		return
+	}
+	for _, gram := range bigrams(doc.Text) {
+		delete(m.postings[gram], uuid)
+		if len(m.postings[gram]) == 0 {
+			delete(m.postings, gram)
+		}
+	}
+	delete(m.docs, uuid)
+}
+
+// candidates 包含关键词所有切词的消息，关键词只有一个字时返回全部消息
+func (m *MemoryIndex) candidates(keyword string) []*Document {
+	grams := bigrams(keyword)
+	if len(grams) == 0 {
+		docs := make([]*Document, 0, len(m.docs))
+		for _, doc := range m.docs {
+			docs = append(docs, doc)
+		}
+		return docs
+	}
+	// 从最短的倒排表开始取交集
+	sort.Slice(grams, func(i, j int) bool {
+		return len(m.postings[grams[i]]) < len(m.postings[grams[j]])
+	})
+	var docs []*Document
+	for uuid := range m.postings[grams[0]] {
+		matched := true
+		for _, gram := range grams[1:] {
+			if _, ok := m.postings[gram][uuid]; !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			docs = append(docs, m.docs[uuid])
+		}
+	}
+	return docs
+}
+
+func (m *MemoryIndex) Search(query Query) ([]Hit, bool, error) {
+	keyword := strings.ToLower(query.Keyword)
+	if keyword == "" {
+		return nil, false, nil
+	}
+	m.mutex.RLock()
+	var hits []Hit
+	for _, doc := range m.candidates(keyword) {
+		// 切词都命中不代表连续出现，还要再确认一次
+		if query.Covers(doc) && strings.Contains(strings.ToLower(doc.Text), keyword) {
+			hits = append(hits, Hit{Id: doc.Id, Uuid: doc.Uuid})
+		}
+	}
+	m.mutex.RUnlock()
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].Id > hits[j].Id
+	})
+	if query.Limit > 0 && len(hits) > query.Limit {
+		return hits[:query.Limit], true, nil
+	}
+	return hits, false, nil
+}
@@ -1,8 +1,23 @@
 package constants
 
 const (
-	CHANNEL_SIZE  = 100            // 通道大小
-	SYSTEM_ERROR  = "系统错误，请联系工作人员" // 系统错误
-	FILE_MAX_SIZE = 50000          // 文件最大大小
-	REDIS_TIMEOUT = 1              // redis timeout
+	CHANNEL_SIZE      = 100            // 通道大小
+	SYSTEM_ERROR      = "系统错误，请联系工作人员" // 系统错误
+	FILE_MAX_SIZE     = 50000          // 文件最大大小
+	REDIS_TIMEOUT     = 1              // redis timeout
+	PRESENCE_TTL      = 90             // 在线状态过期时间，单位秒，节点宕机后自动失效
+	SYNC_LIMIT        = 200            // 按seq同步消息时每次最多返回的条数
+	PAGE_SIZE         = 30             // 聊天记录默认每页条数
+	MAX_PAGE_SIZE     = 100            // 聊天记录每页最多条数
+	CACHE_WINDOW      = 100            // 聊天记录缓存最近的条数
+	FORWARD_LIMIT     = 100            // 一次最多转发的消息条数
+	FORWARD_TARGETS   = 9              // 一次最多转发给几个会话
+	HISTORY_PREVIEWS  = 4              // 聊天记录卡片上展示的消息条数
+	SEARCH_PAGE_SIZE  = 20             // 消息搜索默认每页条数
+	HIGHLIGHT_CONTEXT = 20             // 搜索结果中关键词前后保留的字数
+	VOICE_MAX_SIZE    = 2 << 20        // 语音文件最大大小
+	MAX_MUTE_DURATION = 30 * 24 * 3600 // 禁言最长时间，单位秒
+	APPLY_COOLDOWN    = 24 * 3600      // 加群申请被拒绝后再次申请的间隔，单位秒
+
+	INVITE_QR_PREFIX = "kamachat://group/invite?token=" // 邀请二维码内容的前缀
 )
@@ -0,0 +1,7 @@
+package member_role_enum
+
+const (
+	MEMBER = iota
+	ADMIN
+	OWNER
+)
@@ -5,4 +5,8 @@ const (
 	Unsent = iota
 	// 已发送
 	Sent
+	// 已送达，客户端已确认收到
+	Delivered
+	// 已读
+	Read
 )
@@ -8,4 +8,8 @@ const (
 	File
 	// 通话
 	AudioOrVideo
+	// 合并转发的聊天记录，只能通过转发接口发送
+	ChatHistory
+	// 系统通知，只能由服务端生成，例如群主转让，send_id是通知涉及的用户
+	System
 )
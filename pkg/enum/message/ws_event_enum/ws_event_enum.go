@@ -0,0 +1,37 @@
+package ws_event_enum
+
+// websocket上的非聊天消息事件，通过event字段区分，没有event字段的是聊天消息
+const (
+	// 客户端确认收到消息
+	Ack = "ack"
+	// 已读到某条消息，客户端发来后单聊会转发给对方
+	Read = "read"
+	// 开始输入，转发给对方或群成员，不存表
+	TypingStart = "typing_start"
+	// 停止输入
+	TypingStop = "typing_stop"
+	// 在线状态变化，广播给联系人，不存表
+	Presence = "presence"
+	// 服务端在会话有新消息时推送最新消息预览
+	SessionUpdate = "session_update"
+	// 服务端推送消息被撤回
+	Recall = "recall"
+	// 服务端推送消息被编辑
+	Edit = "edit"
+	// 服务端推送表情回应的增加或取消
+	Reaction = "reaction"
+	// 服务端提醒用户在群聊中被@
+	Mention = "mention"
+	// 服务端通知发送者语音已被收听
+	Listened = "listened"
+	// 服务端通知发送者消息没有发出，例如被禁言
+	SendFailed = "send_failed"
+	// 服务端推送新的加群申请给群主和管理员
+	GroupApply = "group_apply"
+	// 服务端推送加群申请的处理结果给申请人和群主、管理员
+	GroupApplyResult = "group_apply_result"
+	// 服务端通知新群主群聊已经转让
+	OwnerTransfer = "owner_transfer"
+	// 服务端在登录时推送未送达消息概况
+	PendingSummary = "pending_summary"
+)
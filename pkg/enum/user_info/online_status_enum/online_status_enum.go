@@ -0,0 +1,11 @@
+package online_status_enum
+
+// 在线状态只通过websocket广播，不存表
+const (
+	// 在线
+	Online = "online"
+	// 离开，连接还在但用户不活跃
+	Away = "away"
+	// 离线
+	Offline = "offline"
+)
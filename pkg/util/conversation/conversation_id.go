@@ -0,0 +1,12 @@
+package conversation
+
+// GetConversationId 会话标识，单聊双方共用一个，用两个用户uuid排序后拼接，群聊直接用群聊uuid
+func GetConversationId(sendId string, receiveId string) string {
+	if receiveId != "" && receiveId[0] == 'G' {
+		return receiveId
+	}
+	if sendId > receiveId {
+		sendId, receiveId = receiveId, sendId
+	}
+	return sendId + "_" + receiveId
+}
@@ -0,0 +1,34 @@
+package password
+
+import (
+	"crypto/subtle"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// Hash 使用bcrypt加盐哈希密码，盐值包含在结果中
+func Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// IsHashed 判断数据库中存的是否已经是bcrypt哈希，老数据是明文
+func IsHashed(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$")
+}
+
+// Check 校验密码，兼容还没有迁移的明文密码
+// needRehash为true表示校验通过但存的是明文，调用方应该重新哈希后保存
+func Check(stored string, password string) (ok bool, needRehash bool) {
+	if IsHashed(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil, false
+	}
+	// 明文比较也用常量时间，避免时序攻击
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1 {
+		return true, true
+	}
+	return false, false
+}
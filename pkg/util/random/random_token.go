@@ -0,0 +1,15 @@
+package random
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// GetSecureToken 生成不可预测的随机令牌，n是随机字节数，结果是url安全的base64
+func GetSecureToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,58 @@
+package chat
+
+import (
+	"kama_chat_server/internal/service/chat/ack"
+	"testing"
+	"time"
+)
+
+func TestTrackerAckIsCumulative(t *testing.T) {
+	tracker := ack.NewTracker()
+	now := time.Now()
+	tracker.Track("M1", "U1_U2", 1, nil, now)
+	tracker.Track("M2", "U1_U2", 2, nil, now)
+	tracker.Track("M3", "U1_U2", 3, nil, now)
+	tracker.Track("M4", "G1", 1, nil, now)
+	if n := tracker.Ack("U1_U2", 2); n != 2 {
+		t.Fatalf("expected 2 acked, got %d", n)
+	}
+	if tracker.Len() != 2 {
+		t.Fatalf("expected 2 pending, got %d", tracker.Len())
+	}
+}
+
+func TestTrackerRetryBackoff(t *testing.T) {
+	tracker := ack.NewTracker()
+	now := time.Now()
+	tracker.Track("M1", "U1_U2", 1, []byte("m1"), now)
+	if due := tracker.Due(now.Add(ack.BaseBackoff - time.Millisecond)); len(due) != 0 {
+		t.Fatalf("should not resend before backoff, got %v", due)
+	}
+	due := tracker.Due(now.Add(ack.BaseBackoff))
+	if len(due) != 1 || string(due[0].Payload) != "m1" {
+		t.Fatalf("expected resend after backoff, got %v", due)
+	}
+	// 重发后等待时间翻倍
+	resendAt := now.Add(ack.BaseBackoff)
+	tracker.Track("M1", "U1_U2", 1, []byte("m1"), resendAt)
+	if due := tracker.Due(resendAt.Add(ack.BaseBackoff)); len(due) != 0 {
+		t.Fatalf("second backoff should be doubled, got %v", due)
+	}
+	if due := tracker.Due(resendAt.Add(2 * ack.BaseBackoff)); len(due) != 1 {
+		t.Fatalf("expected second resend, got %v", due)
+	}
+}
+
+func TestTrackerGivesUp(t *testing.T) {
+	tracker := ack.NewTracker()
+	now := time.Now()
+	for i := 0; i < ack.MaxAttempts; i++ {
+		tracker.Track("M1", "U1_U2", 1, nil, now)
+	}
+	if due := tracker.Due(now.Add(time.Hour)); len(due) != 0 {
+		t.Fatalf("should give up after max attempts, got %v", due)
+	}
+	if tracker.Len() != 0 {
+		t.Fatalf("given up message should be dropped, got %d pending", tracker.Len())
+	}
+}
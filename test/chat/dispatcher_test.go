@@ -0,0 +1,698 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"kama_chat_server/internal/dto/request"
+	"kama_chat_server/internal/model"
+	"kama_chat_server/internal/service/chat/dispatcher"
+	"kama_chat_server/pkg/enum/group_member/member_role_enum"
+	"kama_chat_server/pkg/enum/message/message_type_enum"
+	"kama_chat_server/pkg/util/conversation"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	saved    []*model.Message
+	members  map[string][]string
+	roles    map[string]int8
+	seqs     map[string]int64
+	previews []string
+	mentions map[string][]string
+	muted    map[string]bool
+	mutes    map[string]dispatcher.MuteState
+	voices   map[string]*model.VoiceUpload
+}
+
+func (f *fakeStore) SaveMessage(message *model.Message) error {
+	f.saved = append(f.saved, message)
+	return nil
+}
+
+func (f *fakeStore) GetGroupMembers(groupId string) ([]string, error) {
+	return f.members[groupId], nil
+}
+
+func (f *fakeStore) NextSeq(conversationId string) (int64, error) {
+	f.seqs[conversationId]++
+	return f.seqs[conversationId], nil
+}
+
+func (f *fakeStore) GetMessage(uuid string) (*model.Message, error) {
+	for _, message := range f.saved {
+		if message.Uuid == uuid {
+			return message, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (f *fakeStore) IsGroupOwner(groupId string, userId string) (bool, error) {
+	return f.roles[groupId+"/"+userId] == member_role_enum.OWNER, nil
+}
+
+func (f *fakeStore) SaveMentions(message *model.Message, userIds []string) error {
+	f.mentions[message.Uuid] = userIds
+	return nil
+}
+
+func (f *fakeStore) GetMutedUsers(receiveId string, userIds []string) (map[string]bool, error) {
+	muted := make(map[string]bool)
+	for _, userId := range userIds {
+		if f.muted[receiveId+"/"+userId] {
+			muted[userId] = true
+		}
+	}
+	return muted, nil
+}
+
+func (f *fakeStore) GetMuteState(groupId string, userId string) (dispatcher.MuteState, error) {
+	return f.mutes[groupId+"/"+userId], nil
+}
+
+func (f *fakeStore) GetVoiceUpload(fileName string, userId string) (*model.VoiceUpload, error) {
+	upload, ok := f.voices[fileName+"/"+userId]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+	return upload, nil
+}
+
+func (f *fakeStore) UpdateSessions(message *model.Message, preview string) error {
+	f.previews = append(f.previews, preview)
+	return nil
+}
+
+type fakeCache struct {
+	keys []string
+}
+
+func (f *fakeCache) AppendMessage(key string, rsp interface{}) error {
+	f.keys = append(f.keys, key)
+	return nil
+}
+
+// fakeDelivery 分开记录聊天消息和会话更新这类事件
+type fakeDelivery struct {
+	delivered []string
+	messages  []dispatcher.OutboundMessage
+	events    map[string][]string
+}
+
+func (f *fakeDelivery) Deliver(uuid string, message dispatcher.OutboundMessage) {
+	if message.MessageUuid == "" {
+		f.events[uuid] = append(f.events[uuid], string(message.Payload))
+		return
+	}
+	f.delivered = append(f.delivered, uuid)
+	f.messages = append(f.messages, message)
+}
+
+func newTestDispatcher() (*dispatcher.Dispatcher, *fakeStore, *fakeCache, *fakeDelivery) {
+	store := &fakeStore{
+		members:  map[string][]string{"G1": {"U1", "U2", "U3"}},
+		roles:    map[string]int8{"G1/U1": member_role_enum.OWNER, "G1/U2": member_role_enum.ADMIN, "G1/U3": member_role_enum.MEMBER},
+		seqs:     map[string]int64{},
+		mentions: map[string][]string{},
+		muted:    map[string]bool{},
+		mutes:    map[string]dispatcher.MuteState{},
+		voices: map[string]*model.VoiceUpload{
+			"V1.m4a/U1": {FileName: "V1.m4a", UserId: "U1", FileSize: "1024", FileType: ".m4a", Duration: 5, Waveform: json.RawMessage(`[0,128,255]`)},
+			"V2.m4a/U3": {FileName: "V2.m4a", UserId: "U3", FileSize: "1024", FileType: ".m4a", Duration: 5},
+		},
+	}
+	cache := &fakeCache{}
+	delivery := &fakeDelivery{events: map[string][]string{}}
+	return dispatcher.NewDispatcher(store, cache, delivery), store, cache, delivery
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDispatchTextToUser(t *testing.T) {
+	d, store, cache, delivery := newTestDispatcher()
+	err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+		Type:       message_type_enum.Text,
+		Content:    "hello",
+		SendId:     "U1",
+		SendAvatar: "https://127.0.0.1:8000/static/avatars/a.png",
+		ReceiveId:  "U2",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.saved) != 1 || store.saved[0].SendAvatar != "/static/avatars/a.png" {
+		t.Fatalf("unexpected saved messages: %+v", store.saved)
+	}
+	if len(delivery.delivered) != 2 || delivery.delivered[0] != "U2" || delivery.delivered[1] != "U1" {
+		t.Fatalf("expected delivery to receiver then sender, got %v", delivery.delivered)
+	}
+	if len(cache.keys) != 2 || cache.keys[0] != "message_list_U1_U2" || cache.keys[1] != "message_list_U2_U1" {
+		t.Fatalf("unexpected cache keys: %v", cache.keys)
+	}
+}
+
+func TestDispatchAssignsConversationSeq(t *testing.T) {
+	d, store, _, delivery := newTestDispatcher()
+	for _, sendId := range []string{"U2", "U1", "U2"} {
+		receiveId := "U1"
+		if sendId == "U1" {
+			receiveId = "U2"
+		}
+		err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+			Type:      message_type_enum.Text,
+			SendId:    sendId,
+			ReceiveId: receiveId,
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, message := range store.saved {
+		if message.ConversationId != "U1_U2" || message.Seq != int64(i+1) {
+			t.Fatalf("message %d: conversation=%s seq=%d", i, message.ConversationId, message.Seq)
+		}
+	}
+	last := delivery.messages[len(delivery.messages)-1]
+	if last.ConversationId != "U1_U2" || last.Seq != 3 {
+		t.Fatalf("outbound message should carry conversation and seq, got %+v", last)
+	}
+	var rsp struct {
+		Seq int64 `json:"seq"`
+	}
+	if err := json.Unmarshal(last.Payload, &rsp); err != nil || rsp.Seq != 3 {
+		t.Fatalf("payload should carry seq, got %s", last.Payload)
+	}
+}
+
+func TestConversationId(t *testing.T) {
+	if conversation.GetConversationId("U2", "U1") != conversation.GetConversationId("U1", "U2") {
+		t.Fatal("both sides of a conversation should share one id")
+	}
+	if got := conversation.GetConversationId("U1", "G1"); got != "G1" {
+		t.Fatalf("group conversation id should be the group uuid, got %s", got)
+	}
+}
+
+func TestDispatchFileToGroup(t *testing.T) {
+	d, store, cache, delivery := newTestDispatcher()
+	err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+		Type:      message_type_enum.File,
+		Url:       "/static/files/a.txt",
+		SendId:    "U2",
+		ReceiveId: "G1",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.saved) != 1 || store.saved[0].Url != "/static/files/a.txt" {
+		t.Fatalf("unexpected saved messages: %+v", store.saved)
+	}
+	if len(delivery.delivered) != 3 {
+		t.Fatalf("expected fan-out to all members, got %v", delivery.delivered)
+	}
+	if len(cache.keys) != 1 || cache.keys[0] != "group_messagelist_G1" {
+		t.Fatalf("unexpected cache keys: %v", cache.keys)
+	}
+}
+
+func TestDispatchRejectsNonMember(t *testing.T) {
+	d, store, _, delivery := newTestDispatcher()
+	err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+		Type:      message_type_enum.Text,
+		SendId:    "U9",
+		ReceiveId: "G1",
+	}))
+	if err == nil {
+		t.Fatal("non-member should be rejected")
+	}
+	if len(store.saved) != 0 || len(delivery.delivered) != 0 {
+		t.Fatalf("rejected message should not be saved or delivered: %v %v", store.saved, delivery.delivered)
+	}
+}
+
+func TestDispatchReplyStartsGroupThread(t *testing.T) {
+	d, store, _, delivery := newTestDispatcher()
+	send := func(sendId string, content string, replyTo string) {
+		err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+			Type:      message_type_enum.Text,
+			Content:   content,
+			SendId:    sendId,
+			SendName:  sendId,
+			ReceiveId: "G1",
+			ReplyTo:   replyTo,
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	send("U1", "root", "")
+	root := store.saved[0]
+	send("U2", "first", root.Uuid)
+	send("U3", "second", store.saved[1].Uuid)
+	for _, reply := range store.saved[1:] {
+		if reply.ThreadId != root.Uuid {
+			t.Fatalf("reply should belong to the root thread, got %q", reply.ThreadId)
+		}
+	}
+	second := store.saved[2]
+	if second.ReplyTo != store.saved[1].Uuid || second.ReplySendId != "U2" || second.ReplySnippet != "first" {
+		t.Fatalf("reply should keep a snapshot of the quoted message: %+v", second)
+	}
+	var rsp struct {
+		Quote struct {
+			Uuid    string `json:"uuid"`
+			Snippet string `json:"snippet"`
+		} `json:"quote"`
+		ThreadId string `json:"thread_id"`
+	}
+	last := delivery.messages[len(delivery.messages)-1]
+	if err := json.Unmarshal(last.Payload, &rsp); err != nil || rsp.Quote.Snippet != "first" || rsp.ThreadId != root.Uuid {
+		t.Fatalf("payload should carry quote and thread, got %s", last.Payload)
+	}
+}
+
+func TestDispatchRejectsReplyFromOtherConversation(t *testing.T) {
+	d, store, _, _ := newTestDispatcher()
+	err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+		Type:      message_type_enum.Text,
+		SendId:    "U1",
+		ReceiveId: "G1",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+		Type:      message_type_enum.Text,
+		SendId:    "U1",
+		ReceiveId: "U2",
+		ReplyTo:   store.saved[0].Uuid,
+	}))
+	if err == nil || len(store.saved) != 1 {
+		t.Fatalf("reply to a message in another conversation should be rejected, err=%v", err)
+	}
+}
+
+func TestDispatchMentionNotifiesOnlyMentioned(t *testing.T) {
+	d, store, _, delivery := newTestDispatcher()
+	err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+		Type:      message_type_enum.Text,
+		Content:   "look",
+		SendId:    "U2",
+		ReceiveId: "G1",
+		Mentions:  []string{"U3", "U3", "U2"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := store.saved[0]
+	if got := store.mentions[message.Uuid]; len(got) != 1 || got[0] != "U3" {
+		t.Fatalf("mentions should be deduplicated and skip the sender, got %v", got)
+	}
+	mentionEvents := func(uuid string) int {
+		count := 0
+		for _, event := range delivery.events[uuid] {
+			if strings.Contains(event, `"event":"mention"`) {
+				count++
+			}
+		}
+		return count
+	}
+	if mentionEvents("U3") != 1 || mentionEvents("U1") != 0 || mentionEvents("U2") != 0 {
+		t.Fatalf("only the mentioned member should be notified, got %v", delivery.events)
+	}
+	var rsp struct {
+		Mentions []string `json:"mentions"`
+	}
+	if err := json.Unmarshal(delivery.messages[0].Payload, &rsp); err != nil || len(rsp.Mentions) != 1 || rsp.Mentions[0] != "U3" {
+		t.Fatalf("payload should carry mentions, got %s", delivery.messages[0].Payload)
+	}
+}
+
+func TestDispatchMutedMemberNotNotified(t *testing.T) {
+	d, store, _, delivery := newTestDispatcher()
+	store.muted["G1/U3"] = true
+	err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+		Type:       message_type_enum.Text,
+		SendId:     "U1",
+		ReceiveId:  "G1",
+		MentionAll: true,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := store.mentions[store.saved[0].Uuid]; len(got) != 2 {
+		t.Fatalf("muted member should still be recorded as mentioned, got %v", got)
+	}
+	for _, event := range delivery.events["U3"] {
+		if strings.Contains(event, `"event":"mention"`) {
+			t.Fatalf("muted member should not get mention notifications: %v", delivery.events["U3"])
+		}
+	}
+	if len(delivery.delivered) != 3 {
+		t.Fatalf("muted member should still receive the message itself, got %v", delivery.delivered)
+	}
+}
+
+func TestDispatchMutedMemberRejected(t *testing.T) {
+	d, store, _, delivery := newTestDispatcher()
+	store.mutes["G1/U2"] = dispatcher.MuteState{MutedUntil: time.Now().Add(time.Hour)}
+	err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+		Type:      message_type_enum.Text,
+		Content:   "hello",
+		SendId:    "U2",
+		ReceiveId: "G1",
+	}))
+	if err == nil {
+		t.Fatal("muted member should be rejected")
+	}
+	if len(store.saved) != 0 || len(delivery.delivered) != 0 {
+		t.Fatalf("rejected message should not be saved or delivered: %v %v", store.saved, delivery.delivered)
+	}
+	events := delivery.events["U2"]
+	if len(events) != 1 || !strings.Contains(events[0], `"event":"send_failed"`) || !strings.Contains(events[0], `"muted_until"`) {
+		t.Fatalf("sender should get a send_failed event, got %v", events)
+	}
+}
+
+func TestDispatchExpiredMuteAllowed(t *testing.T) {
+	d, store, _, _ := newTestDispatcher()
+	store.mutes["G1/U2"] = dispatcher.MuteState{MutedUntil: time.Now().Add(-time.Minute)}
+	err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+		Type:      message_type_enum.Text,
+		Content:   "hello",
+		SendId:    "U2",
+		ReceiveId: "G1",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.saved) != 1 {
+		t.Fatalf("expired mute should not block sending, saved %d", len(store.saved))
+	}
+}
+
+func TestDispatchMuteAllExemptsManagers(t *testing.T) {
+	d, store, _, delivery := newTestDispatcher()
+	store.mutes["G1/U1"] = dispatcher.MuteState{MuteAll: true, Exempt: true}
+	store.mutes["G1/U3"] = dispatcher.MuteState{MuteAll: true}
+	for _, sendId := range []string{"U1", "U3"} {
+		_ = d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+			Type:      message_type_enum.Text,
+			Content:   "hello",
+			SendId:    sendId,
+			ReceiveId: "G1",
+		}))
+	}
+	if len(store.saved) != 1 || store.saved[0].SendId != "U1" {
+		t.Fatalf("only the exempt manager should be able to send, saved %+v", store.saved)
+	}
+	if len(delivery.events["U3"]) == 0 || !strings.Contains(delivery.events["U3"][len(delivery.events["U3"])-1], "全员禁言") {
+		t.Fatalf("muted member should be told about mute all, got %v", delivery.events["U3"])
+	}
+}
+
+func TestDispatchSystemNoticeIgnoresMute(t *testing.T) {
+	d, store, _, delivery := newTestDispatcher()
+	store.mutes["G1/G1"] = dispatcher.MuteState{MuteAll: true}
+	content := "U1 将群主转让给了 U3"
+	err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+		Type:      message_type_enum.System,
+		Content:   content,
+		SendId:    "G1",
+		ReceiveId: "G1",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.saved) != 1 || store.saved[0].Content != content || store.saved[0].Seq != 1 {
+		t.Fatalf("system notice should be saved with a seq: %+v", store.saved)
+	}
+	if len(delivery.delivered) != 3 || store.previews[0] != content {
+		t.Fatalf("system notice should reach every member, delivered=%v previews=%v", delivery.delivered, store.previews)
+	}
+}
+
+func TestDispatchRejectsInvalidMentions(t *testing.T) {
+	d, store, _, _ := newTestDispatcher()
+	for _, chatMessageReq := range []request.ChatMessageRequest{
+		{Type: message_type_enum.Text, SendId: "U2", ReceiveId: "G1", Mentions: []string{"U9"}},
+		{Type: message_type_enum.Text, SendId: "U2", ReceiveId: "G1", MentionAll: true},
+	} {
+		if err := d.Dispatch(mustMarshal(t, chatMessageReq)); err == nil {
+			t.Fatalf("mention should be rejected: %+v", chatMessageReq)
+		}
+	}
+	if len(store.saved) != 0 {
+		t.Fatalf("rejected messages should not be saved: %v", store.saved)
+	}
+}
+
+func TestDispatchRejectionNotifiesSender(t *testing.T) {
+	for name, req := range map[string]request.ChatMessageRequest{
+		"mention":     {Type: message_type_enum.Text, SendId: "U2", ReceiveId: "G1", Mentions: []string{"U9"}},
+		"mention_all": {Type: message_type_enum.Text, SendId: "U2", ReceiveId: "G1", MentionAll: true},
+		"quote":       {Type: message_type_enum.Text, SendId: "U2", ReceiveId: "G1", ReplyTo: "M404"},
+		"voice":       {Type: message_type_enum.Voice, SendId: "U1", ReceiveId: "U2", Url: "/static/voices/V9.m4a"},
+	} {
+		d, store, _, delivery := newTestDispatcher()
+		if err := d.Dispatch(mustMarshal(t, req)); err == nil {
+			t.Fatalf("%s should be rejected", name)
+		}
+		if len(store.saved) != 0 {
+			t.Fatalf("rejected %s should not be saved", name)
+		}
+		events := delivery.events[req.SendId]
+		if len(events) != 1 || !strings.Contains(events[0], `"event":"send_failed"`) || !strings.Contains(events[0], `"receive_id":"`+req.ReceiveId+`"`) {
+			t.Fatalf("sender should be told that %s failed, got %v", name, events)
+		}
+	}
+}
+
+func TestDispatchOwnerMentionAll(t *testing.T) {
+	d, store, _, _ := newTestDispatcher()
+	err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+		Type:       message_type_enum.Text,
+		SendId:     "U1",
+		ReceiveId:  "G1",
+		MentionAll: true,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := store.saved[0]
+	if got := store.mentions[message.Uuid]; !message.MentionAll || len(got) != 2 || got[0] != "U2" || got[1] != "U3" {
+		t.Fatalf("@all should mention every member except the sender, got %v", got)
+	}
+}
+
+func TestDispatchAdminMentionAllRejected(t *testing.T) {
+	d, store, _, delivery := newTestDispatcher()
+	err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+		Type:       message_type_enum.Text,
+		SendId:     "U2",
+		ReceiveId:  "G1",
+		MentionAll: true,
+	}))
+	if err == nil {
+		t.Fatal("only the owner should be able to @all, admins included")
+	}
+	if len(store.saved) != 0 || len(delivery.delivered) != 0 {
+		t.Fatalf("rejected @all should not be saved or delivered, saved=%v delivered=%v", store.saved, delivery.delivered)
+	}
+}
+
+func TestDispatchVoiceToUser(t *testing.T) {
+	d, store, cache, delivery := newTestDispatcher()
+	// 客户端传来的时长和波形不可信，以上传记录为准
+	err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+		Type:      message_type_enum.Voice,
+		Url:       "https://127.0.0.1:8000/static/voices/V1.m4a",
+		Duration:  3600,
+		Waveform:  []int{1},
+		SendId:    "U1",
+		ReceiveId: "U2",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.saved) != 1 {
+		t.Fatalf("expected 1 saved message, got %d", len(store.saved))
+	}
+	saved := store.saved[0]
+	if saved.Url != "/static/voices/V1.m4a" || saved.Duration != 5 || saved.FileSize != "1024" {
+		t.Fatalf("unexpected saved voice: %+v", saved)
+	}
+	if waveform := dispatcher.Waveform(saved); len(waveform) != 3 || waveform[1] != 128 {
+		t.Fatalf("unexpected waveform: %v", waveform)
+	}
+	if len(delivery.delivered) != 2 || len(cache.keys) != 2 || store.previews[0] != "[语音]" {
+		t.Fatalf("voice should fan out like a file, delivered=%v cache=%v previews=%v", delivery.delivered, cache.keys, store.previews)
+	}
+}
+
+func TestDispatchRejectsInvalidVoice(t *testing.T) {
+	for name, req := range map[string]request.ChatMessageRequest{
+		"url":       {Url: "/static/files/a.m4a"},
+		"traversal": {Url: "/static/voices/../files/V1.m4a"},
+		"unknown":   {Url: "/static/voices/V9.m4a"},
+		"others":    {Url: "/static/voices/V2.m4a"},
+	} {
+		d, store, _, delivery := newTestDispatcher()
+		req.Type = message_type_enum.Voice
+		req.SendId = "U1"
+		req.ReceiveId = "U2"
+		if err := d.Dispatch(mustMarshal(t, req)); err == nil {
+			t.Fatalf("invalid voice %s should be rejected", name)
+		}
+		if len(store.saved) != 0 || len(delivery.delivered) != 0 {
+			t.Fatalf("rejected voice %s should not be saved or delivered", name)
+		}
+	}
+}
+
+func TestCheckWaveform(t *testing.T) {
+	if err := dispatcher.CheckWaveform([]int{0, 128, 255}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dispatcher.CheckWaveform([]int{256}); err == nil {
+		t.Fatal("sample out of range should be rejected")
+	}
+	if err := dispatcher.CheckWaveform(make([]int, 101)); err == nil {
+		t.Fatal("too many samples should be rejected")
+	}
+}
+
+func TestDispatchChatHistoryCard(t *testing.T) {
+	d, store, _, delivery := newTestDispatcher()
+	content := `{"title":"U1和U2的聊天记录","message_uuids":["M1","M2"]}`
+	err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+		Type:      message_type_enum.ChatHistory,
+		Content:   content,
+		SendId:    "U1",
+		ReceiveId: "G1",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.saved) != 1 || store.saved[0].Content != content {
+		t.Fatalf("card content should be saved as is: %+v", store.saved)
+	}
+	if len(delivery.delivered) != 3 || store.previews[0] != "[聊天记录]" {
+		t.Fatalf("card should fan out like a normal message, delivered=%v previews=%v", delivery.delivered, store.previews)
+	}
+}
+
+func TestDispatchAV(t *testing.T) {
+	d, store, cache, delivery := newTestDispatcher()
+	for _, avData := range []request.AVData{
+		{MessageId: "PROXY", Type: "start_call"},
+		{MessageId: "PROXY", Type: "candidate"},
+	} {
+		err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+			Type:      message_type_enum.AudioOrVideo,
+			SendId:    "U1",
+			ReceiveId: "U2",
+			AVdata:    string(mustMarshal(t, avData)),
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(store.saved) != 1 {
+		t.Fatalf("only start_call should be saved, got %d", len(store.saved))
+	}
+	if len(delivery.delivered) != 2 || delivery.delivered[0] != "U2" || delivery.delivered[1] != "U2" {
+		t.Fatalf("av messages should only go to the receiver, got %v", delivery.delivered)
+	}
+	for _, message := range delivery.messages {
+		if message.Seq != 0 {
+			t.Fatalf("av messages should not require ack, got seq %d", message.Seq)
+		}
+	}
+	if len(cache.keys) != 0 {
+		t.Fatalf("av messages should not be cached, got %v", cache.keys)
+	}
+}
+
+func TestDispatchUnknownType(t *testing.T) {
+	d, _, _, _ := newTestDispatcher()
+	if err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{Type: 99, SendId: "U1", ReceiveId: "U2"})); err == nil {
+		t.Fatal("unknown message type should return an error")
+	}
+}
+
+func TestDispatchUpdatesSessions(t *testing.T) {
+	d, store, _, delivery := newTestDispatcher()
+	err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+		Type:      message_type_enum.Text,
+		Content:   "hello",
+		SendId:    "U1",
+		ReceiveId: "U2",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.previews) != 1 || store.previews[0] != "hello" {
+		t.Fatalf("unexpected previews: %v", store.previews)
+	}
+	for uuid, contactId := range map[string]string{"U1": "U2", "U2": "U1"} {
+		var event struct {
+			Event       string `json:"event"`
+			ContactId   string `json:"contact_id"`
+			LastMessage string `json:"last_message"`
+		}
+		if len(delivery.events[uuid]) != 1 {
+			t.Fatalf("%s should get one session update, got %v", uuid, delivery.events[uuid])
+		}
+		if err := json.Unmarshal([]byte(delivery.events[uuid][0]), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Event != "session_update" || event.ContactId != contactId || event.LastMessage != "hello" {
+			t.Fatalf("unexpected session update for %s: %+v", uuid, event)
+		}
+	}
+
+	if err := d.Dispatch(mustMarshal(t, request.ChatMessageRequest{
+		Type:      message_type_enum.File,
+		SendId:    "U1",
+		ReceiveId: "G1",
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if store.previews[1] != "[文件]" {
+		t.Fatalf("file preview should be a type label, got %s", store.previews[1])
+	}
+	if len(delivery.events["U3"]) != 1 {
+		t.Fatalf("every group member should get a session update, got %v", delivery.events)
+	}
+}
+
+func TestPreviewTruncatesText(t *testing.T) {
+	content := strings.Repeat("好", 60)
+	preview := dispatcher.Preview(&model.Message{Type: message_type_enum.Text, Content: content})
+	if preview != strings.Repeat("好", 50)+"..." {
+		t.Fatalf("unexpected preview: %s", preview)
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	cases := map[string]string{
+		"https://127.0.0.1:8000/static/avatars/a.png":                         "/static/avatars/a.png",
+		"/static/avatars/a.png":                                               "/static/avatars/a.png",
+		"https://cube.elemecdn.com/0/88/03b0d39583f48206768a7534e55bcpng.png": "https://cube.elemecdn.com/0/88/03b0d39583f48206768a7534e55bcpng.png",
+		"": "",
+	}
+	for in, want := range cases {
+		if got := dispatcher.NormalizePath(in); got != want {
+			t.Errorf("NormalizePath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
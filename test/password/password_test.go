@@ -0,0 +1,31 @@
+package password
+
+import (
+	"kama_chat_server/pkg/util/password"
+	"testing"
+)
+
+func TestHashAndCheck(t *testing.T) {
+	hashed, err := password.Hash("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !password.IsHashed(hashed) {
+		t.Fatalf("expected bcrypt hash, got %s", hashed)
+	}
+	if ok, needRehash := password.Check(hashed, "123456"); !ok || needRehash {
+		t.Fatalf("check hashed password: ok=%v needRehash=%v", ok, needRehash)
+	}
+	if ok, _ := password.Check(hashed, "654321"); ok {
+		t.Fatal("wrong password should not pass")
+	}
+}
+
+func TestCheckPlaintext(t *testing.T) {
+	if ok, needRehash := password.Check("123456", "123456"); !ok || !needRehash {
+		t.Fatalf("plaintext password should pass and need rehash: ok=%v needRehash=%v", ok, needRehash)
+	}
+	if ok, needRehash := password.Check("123456", "1234567"); ok || needRehash {
+		t.Fatalf("wrong plaintext password: ok=%v needRehash=%v", ok, needRehash)
+	}
+}
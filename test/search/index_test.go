@@ -0,0 +1,120 @@
+package search
+
+import (
+	"kama_chat_server/internal/service/search"
+	"testing"
+	"time"
+)
+
+func newTestIndex(t *testing.T) *search.MemoryIndex {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	index := search.NewMemoryIndex()
+	docs := []search.Document{
+		{Id: 1, Uuid: "M1", ConversationId: "U1_U2", SendId: "U1", ReceiveId: "U2", Text: "明天一起吃火锅", CreatedAt: base},
+		{Id: 2, Uuid: "M2", ConversationId: "U2_U3", SendId: "U2", ReceiveId: "U3", Text: "火锅店见", CreatedAt: base.Add(time.Hour)},
+		{Id: 3, Uuid: "M3", ConversationId: "G1", SendId: "U2", ReceiveId: "G1", Text: "今晚火锅", CreatedAt: base.Add(2 * time.Hour)},
+		{Id: 4, Uuid: "M4", ConversationId: "G1", SendId: "U3", ReceiveId: "G1", Text: "又是火锅", CreatedAt: base.Add(4 * time.Hour)},
+		{Id: 5, Uuid: "M5", ConversationId: "U1_U2", SendId: "U2", ReceiveId: "U1", Text: "Hello World", CreatedAt: base.Add(5 * time.Hour)},
+	}
+	for _, doc := range docs {
+		if err := index.Add(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return index
+}
+
+func uuidsOf(hits []search.Hit) []string {
+	uuids := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		uuids = append(uuids, hit.Uuid)
+	}
+	return uuids
+}
+
+func assertUuids(t *testing.T, hits []search.Hit, want ...string) {
+	t.Helper()
+	got := uuidsOf(hits)
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+}
+
+func TestMemoryIndexScopesToUser(t *testing.T) {
+	index := newTestIndex(t)
+	hits, _, err := index.Search(search.Query{Keyword: "火锅", UserId: "U1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertUuids(t, hits, "M1")
+
+	// 退群之后只能搜到离开之前的群聊消息
+	leftAt := time.Date(2024, 1, 1, 15, 0, 0, 0, time.Local)
+	hits, _, _ = index.Search(search.Query{Keyword: "火锅", UserId: "U1", Groups: map[string]time.Time{"G1": leftAt}})
+	assertUuids(t, hits, "M3", "M1")
+
+	hits, _, _ = index.Search(search.Query{Keyword: "火锅", UserId: "U1", Groups: map[string]time.Time{"G1": {}}})
+	assertUuids(t, hits, "M4", "M3", "M1")
+}
+
+func TestMemoryIndexFiltersAndPages(t *testing.T) {
+	index := newTestIndex(t)
+	groups := map[string]time.Time{"G1": {}}
+	hits, _, _ := index.Search(search.Query{Keyword: "火锅", UserId: "U2", Groups: groups, SendId: "U2"})
+	assertUuids(t, hits, "M3", "M2")
+
+	hits, _, _ = index.Search(search.Query{Keyword: "火锅", UserId: "U2", Groups: groups, ConversationId: "G1"})
+	assertUuids(t, hits, "M4", "M3")
+
+	hits, hasMore, _ := index.Search(search.Query{Keyword: "火锅", UserId: "U2", Groups: groups, Limit: 2})
+	assertUuids(t, hits, "M4", "M3")
+	if !hasMore {
+		t.Fatal("first page should report more hits")
+	}
+	hits, hasMore, _ = index.Search(search.Query{Keyword: "火锅", UserId: "U2", Groups: groups, Limit: 2, Before: hits[1].Id})
+	assertUuids(t, hits, "M2", "M1")
+	if hasMore {
+		t.Fatal("last page should not report more hits")
+	}
+}
+
+func TestMemoryIndexKeywordMatching(t *testing.T) {
+	index := newTestIndex(t)
+	hits, _, _ := index.Search(search.Query{Keyword: "world", UserId: "U1"})
+	assertUuids(t, hits, "M5")
+	// 每个切词都出现但不连续的不算命中
+	hits, _, _ = index.Search(search.Query{Keyword: "吃锅", UserId: "U1"})
+	assertUuids(t, hits)
+	hits, _, _ = index.Search(search.Query{Keyword: "吃", UserId: "U1"})
+	assertUuids(t, hits, "M1")
+}
+
+func TestMemoryIndexUpdateAndRemove(t *testing.T) {
+	index := newTestIndex(t)
+	if err := index.Add(search.Document{Id: 1, Uuid: "M1", ConversationId: "U1_U2", SendId: "U1", ReceiveId: "U2", Text: "改成烧烤"}); err != nil {
+		t.Fatal(err)
+	}
+	hits, _, _ := index.Search(search.Query{Keyword: "火锅", UserId: "U1"})
+	assertUuids(t, hits)
+	hits, _, _ = index.Search(search.Query{Keyword: "烧烤", UserId: "U1"})
+	assertUuids(t, hits, "M1")
+	if err := index.Remove("M1"); err != nil {
+		t.Fatal(err)
+	}
+	hits, _, _ = index.Search(search.Query{Keyword: "烧烤", UserId: "U1"})
+	assertUuids(t, hits)
+}
+
+func TestHighlight(t *testing.T) {
+	if got := search.Highlight("明天一起吃火锅", "火锅", 20); got != "明天一起吃<em>火锅</em>" {
+		t.Fatalf("unexpected highlight: %s", got)
+	}
+	if got := search.Highlight("Hello <b>World</b> world", "world", 3); got != "...&lt;b&gt;<em>World</em>&lt;/b..." {
+		t.Fatalf("unexpected highlight: %s", got)
+	}
+}